@@ -0,0 +1,242 @@
+//go:build cluster
+
+// The cluster tests run three broker processes on localhost, they are run with
+// go test -tags cluster ./cmd/broker
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+const clusterWait = 15 * time.Second
+
+type brokerProcess struct {
+	id   string
+	port int
+	dir  string
+	cmd  *exec.Cmd
+}
+
+func (p *brokerProcess) url() string {
+	return fmt.Sprintf("http://localhost:%d", p.port)
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func startClusterProcesses(t *testing.T) []*brokerProcess {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "broker")
+	build := exec.Command("go", "build", "-o", bin, ".")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		t.Fatalf("failed to build the broker: %s", err)
+	}
+
+	var processes []*brokerProcess
+	nodes := ""
+	for _, id := range []string{"node1", "node2", "node3"} {
+		p := &brokerProcess{id: id, port: freePort(t), dir: filepath.Join(dir, id)}
+		processes = append(processes, p)
+		nodes += fmt.Sprintf("    - id: %s\n      address: %s\n", p.id, p.url())
+	}
+
+	for _, p := range processes {
+		if err := os.MkdirAll(p.dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		config := fmt.Sprintf(`api:
+  port: %d
+topic:
+  buffer: 100
+message:
+  ttl: 600
+  cleanup_time: 300
+subscriber:
+  retry_count: 3
+  retry_interval: 1
+  cleanup_time: 300
+  timeout: 2
+  inactive_time: 300
+cluster:
+  node_id: %s
+  data_dir: raft
+  election_timeout: 300ms
+  heartbeat_interval: 50ms
+  api_key: cluster-secret
+  nodes:
+%s`, p.port, p.id, nodes)
+		if err := os.WriteFile(filepath.Join(p.dir, "config.yml"), []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		p.start(t, bin)
+	}
+	t.Cleanup(func() {
+		for _, p := range processes {
+			p.cmd.Process.Signal(syscall.SIGCONT)
+			p.cmd.Process.Kill()
+			p.cmd.Wait()
+		}
+	})
+
+	return processes
+}
+
+// start runs the broker in its directory, a restarted broker recovers from its raft data dir.
+func (p *brokerProcess) start(t *testing.T, bin string) {
+	logs, err := os.OpenFile(filepath.Join(p.dir, "broker.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.cmd = exec.Command(bin, "-config", "config.yml")
+	p.cmd.Dir = p.dir
+	p.cmd.Stdout = logs
+	p.cmd.Stderr = logs
+	if err := p.cmd.Start(); err != nil {
+		t.Fatalf("failed to start %s: %s", p.id, err)
+	}
+}
+
+func (p *brokerProcess) crash() {
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+}
+
+func getJSON(url string, v any) error {
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// waitLeader waits until one of the processes leads the cluster.
+func waitLeader(t *testing.T, processes []*brokerProcess) *brokerProcess {
+	deadline := time.Now().Add(clusterWait)
+	for time.Now().Before(deadline) {
+		for _, p := range processes {
+			var status request.ReplicationStatus
+			if err := getJSON(p.url()+"/admin/replication", &status); err == nil && status.Role == "leader" {
+				return p
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("no broker leads the cluster")
+
+	return nil
+}
+
+func publish(t *testing.T, p *brokerProcess, id string) {
+	body, _ := json.Marshal(request.PublishMessageRequest{Id: id, Topic: "orders", Message: id})
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(p.url()+"/publish", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to publish %s to %s: %s", id, p.id, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("publishing %s to %s responded with %d", id, p.id, resp.StatusCode)
+	}
+}
+
+// waitMessages waits until every process holds the number of messages in the orders topic.
+func waitMessages(t *testing.T, processes []*brokerProcess, expected int) {
+	deadline := time.Now().Add(clusterWait)
+	for _, p := range processes {
+		for {
+			var stats request.BrokerStats
+			messages := 0
+			if err := getJSON(p.url()+"/admin/stats", &stats); err == nil {
+				for _, topic := range stats.Topics {
+					if topic.Name == "orders" {
+						messages = topic.Messages
+					}
+				}
+			}
+			if messages == expected {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %s to hold %d messages, got %d", p.id, expected, messages)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+func without(processes []*brokerProcess, p *brokerProcess) []*brokerProcess {
+	var rest []*brokerProcess
+	for _, other := range processes {
+		if other != p {
+			rest = append(rest, other)
+		}
+	}
+
+	return rest
+}
+
+func TestClusterProcesses(t *testing.T) {
+	processes := startClusterProcesses(t)
+	bin := filepath.Join(filepath.Dir(processes[0].dir), "broker")
+
+	leader := waitLeader(t, processes)
+	publish(t, leader, "m1")
+	// followers redirect the publishes to the leader
+	publish(t, without(processes, leader)[0], "m2")
+	waitMessages(t, processes, 2)
+
+	// crash: the leader is killed, the other nodes elect a new leader
+	leader.crash()
+	rest := without(processes, leader)
+	newLeader := waitLeader(t, rest)
+	publish(t, newLeader, "m3")
+	waitMessages(t, rest, 3)
+
+	// the crashed node restarts from its raft log and catches up
+	leader.start(t, bin)
+	waitMessages(t, processes, 3)
+
+	// partition: the leader is frozen, the other nodes elect a new leader and the frozen one
+	// steps down once it resumes
+	newLeader.cmd.Process.Signal(syscall.SIGSTOP)
+	rest = without(processes, newLeader)
+	thirdLeader := waitLeader(t, rest)
+	publish(t, thirdLeader, "m4")
+	waitMessages(t, rest, 4)
+
+	newLeader.cmd.Process.Signal(syscall.SIGCONT)
+	waitMessages(t, processes, 4)
+	if p := waitLeader(t, processes); p != thirdLeader {
+		t.Errorf("expected %s to keep leading, %s leads", thirdLeader.id, p.id)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -13,23 +14,73 @@ import (
 
 	"github.com/NamanBalaji/flux/internal/broker/api"
 	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/audit"
 	"github.com/NamanBalaji/flux/pkg/config"
 	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/raft"
+	"github.com/NamanBalaji/flux/pkg/request"
+	"github.com/NamanBalaji/flux/pkg/tlsconfig"
 )
 
 func main() {
-	configFile := flag.String(constants.ConfigFlag, fmt.Sprintf("cmd/broker/%s", constants.DefaultConfigFile), "config file name")
+	configFile := flag.String(constants.ConfigFlag, fmt.Sprintf("cmd/broker/%s", constants.DefaultConfigFile), "config file name, empty to use the defaults and the FLUX_* environment variables only")
 	flag.Parse()
 
-	cfg, err := config.LoadConfig(*configFile)
+	file := *configFile
+	if _, err := os.Stat(file); os.IsNotExist(err) && !configFlagSet() {
+		log.Printf("Config file %s not found, using the defaults and the FLUX_* environment variables", file)
+		file = ""
+	}
+
+	cfg, err := config.LoadConfig(file)
 	if err != nil {
 		log.Fatalf("Error loading config file: %v", err)
 	}
+	settings := config.NewHolder(file, *cfg)
 
 	broker := service.NewBroker()
-	go broker.StartRequestPrecessing(*cfg)
+	broker.Audit, err = audit.New(cfg.Audit)
+	if err != nil {
+		log.Fatalf("Error opening the audit log: %v", err)
+	}
+	defer broker.Audit.Close()
 
-	apiRouter := api.SetupRouter(*cfg, broker)
+	leaderCredentials := request.Credentials{APIKey: cfg.Replication.APIKey}
+	if cfg.Subscriber.TLS.Enabled() {
+		pushTLS, err := tlsconfig.NewReloader(cfg.Subscriber.TLS)
+		if err != nil {
+			log.Fatalf("Error loading subscriber tls config: %v", err)
+		}
+		go pushTLS.Watch(context.Background(), constants.CertReloadInterval)
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = pushTLS.ClientConfig()
+		broker.PushTransport = transport
+		// followers present the same client certificate to the leader
+		leaderCredentials.TLS = pushTLS.ClientConfig()
+	}
+	if len(cfg.Cluster.Nodes) > 0 {
+		clusterCredentials := request.Credentials{APIKey: cfg.Cluster.APIKey, TLS: leaderCredentials.TLS}
+		transport := raft.NewHTTPTransport(cfg.Cluster.Addresses(), clusterCredentials)
+		if err := broker.StartCluster(settings, transport); err != nil {
+			log.Fatalf("Error starting the cluster node: %v", err)
+		}
+		defer broker.StopCluster()
+	} else {
+		broker.StartReplication(settings, leaderCredentials)
+	}
+	if cfg.Subscriber.StateFile != "" {
+		if err := broker.RestoreState(*cfg, cfg.Subscriber.StateFile); err != nil {
+			log.Fatalf("Error restoring the subscriber state: %v", err)
+		}
+	}
+	go broker.StartRequestPrecessing(settings)
+	settings.OnReload(broker.Reconfigure)
+
+	apiRouter, err := api.SetupRouter(settings, broker)
+	if err != nil {
+		log.Fatalf("Error setting up the api: %v", err)
+	}
 
 	port := fmt.Sprintf(":%d", cfg.Api.Port)
 
@@ -38,6 +89,16 @@ func main() {
 		Handler: apiRouter,
 	}
 
+	if cfg.Api.TLS.CertFile != "" {
+		apiTLS, err := tlsconfig.NewReloader(cfg.Api.TLS)
+		if err != nil {
+			log.Fatalf("Error loading api tls config: %v", err)
+		}
+		go apiTLS.Watch(context.Background(), constants.CertReloadInterval)
+
+		server.TLSConfig = apiTLS.ServerConfig()
+	}
+
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
 
@@ -45,15 +106,29 @@ func main() {
 
 	go func() {
 		log.Printf("starting broken on port %s", port)
-		err := server.ListenAndServe()
+		var err error
+		if server.TLSConfig != nil {
+			// the certificates come from the tls config so they can be reloaded
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Printf("Error occurred while trying to start the server %v \n", err)
 			close(serverErrChan)
 		}
 	}()
 
-	go subscriberCleanupScheduler(*cfg, broker, time.Duration(cfg.Subscriber.CleanupTime)*time.Second)
-	go messagesCleanupScheduler(*cfg, broker, time.Duration(cfg.Message.CleanupTime)*time.Second)
+	go subscriberCleanupScheduler(settings, broker)
+	go messagesCleanupScheduler(settings, broker)
+	go reloadOnHangup(settings, broker)
+	if cfg.Subscriber.StateFile != "" {
+		interval := time.Duration(cfg.Subscriber.StateInterval)
+		if interval <= 0 {
+			interval = constants.DefaultStateInterval
+		}
+		go stateScheduler(cfg.Subscriber.StateFile, broker, interval)
+	}
 
 	select {
 	case <-stopChan:
@@ -61,28 +136,106 @@ func main() {
 	case <-serverErrChan:
 		log.Fatalf("Shutting down server")
 	}
+
+	if cfg.Subscriber.StateFile != "" {
+		if err := broker.SaveState(cfg.Subscriber.StateFile); err != nil {
+			log.Printf("Error saving the subscriber state: %v", err)
+		}
+	}
+}
+
+// configFlagSet reports whether the config file was given on the command line, a missing default
+// config file isn't an error.
+func configFlagSet() bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == constants.ConfigFlag {
+			set = true
+		}
+	})
+
+	return set
+}
+
+// reloadOnHangup reloads the config file every time the broker receives a SIGHUP.
+func reloadOnHangup(settings *config.Holder, broker *service.Broker) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		log.Println("Reloading config")
+		before := settings.Get()
+		after, err := settings.Reload()
+		if err != nil {
+			log.Printf("Error reloading config, keeping the current one: %v", err)
+
+			continue
+		}
+
+		if err := broker.Audit.Record(audit.Event{
+			Action:    audit.ConfigReload,
+			Principal: audit.SystemPrincipal,
+			Target:    "config",
+			Before:    before.Reloadable(),
+			After:     after.Reloadable(),
+		}); err != nil {
+			log.Printf("Error recording the config reload: %v", err)
+		}
+		log.Println("Config reloaded")
+	}
 }
 
-func subscriberCleanupScheduler(cfg config.Config, broker *service.Broker, interval time.Duration) {
+func subscriberCleanupScheduler(settings *config.Holder, broker *service.Broker) {
+	interval := time.Duration(settings.Get().Subscriber.CleanupTime)
 	ticker := time.NewTicker(interval)
 	for {
 		select {
 		case <-ticker.C:
+			cfg := settings.Get()
 			log.Println("Cleaning up subscribers")
 			broker.CleanSubscribers(cfg)
 			log.Printf("Subscriber cleanup completed")
+
+			interval = resetTicker(ticker, interval, time.Duration(cfg.Subscriber.CleanupTime))
 		}
 	}
 }
 
-func messagesCleanupScheduler(cfg config.Config, broker *service.Broker, interval time.Duration) {
+func messagesCleanupScheduler(settings *config.Holder, broker *service.Broker) {
+	interval := time.Duration(settings.Get().Message.CleanupTime)
 	ticker := time.NewTicker(interval)
 	for {
 		select {
 		case <-ticker.C:
+			cfg := settings.Get()
 			log.Println("Cleaning up messages")
 			broker.CleanupMessages(cfg)
+			broker.CleanupTemporaryTopics()
 			log.Println("Message cleanup completed")
+
+			interval = resetTicker(ticker, interval, time.Duration(cfg.Message.CleanupTime))
+		}
+	}
+}
+
+// resetTicker moves the ticker to the interval of a reloaded config and returns the interval in use.
+func resetTicker(ticker *time.Ticker, current time.Duration, reloaded time.Duration) time.Duration {
+	if reloaded == current || reloaded <= 0 {
+		return current
+	}
+	ticker.Reset(reloaded)
+
+	return reloaded
+}
+
+func stateScheduler(file string, broker *service.Broker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			if err := broker.SaveState(file); err != nil {
+				log.Printf("Error saving the subscriber state: %v", err)
+			}
 		}
 	}
 }
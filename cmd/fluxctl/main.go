@@ -0,0 +1,110 @@
+// fluxctl administers a broker, e.g. to move its state to another broker:
+//
+//	fluxctl -broker http://old:9092 snapshot flux.snapshot
+//	fluxctl -broker http://new:9092 restore flux.snapshot
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+func main() {
+	broker := flag.String("broker", "http://localhost:9092", "url of the broker's api")
+	apiKey := flag.String("api-key", "", "api key of an acl admin")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fluxctl [flags] snapshot <file>|restore <file>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	address := strings.TrimSuffix(*broker, "/")
+	credentials := request.Credentials{APIKey: *apiKey}
+
+	var err error
+	switch command, file := flag.Arg(0), flag.Arg(1); command {
+	case "snapshot":
+		err = snapshot(address, credentials, file)
+	case "restore":
+		err = restore(address, credentials, file)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s failed: %v", flag.Arg(0), err)
+	}
+}
+
+// snapshot writes the snapshot of the broker to the file, a file is only replaced by a complete snapshot.
+func snapshot(address string, credentials request.Credentials, file string) error {
+	body, status, err := request.SendAuthenticatedRequest(context.Background(), http.MethodGet, address+"/admin/snapshot", nil, credentials)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("broker responded with %d: %s", status, data)
+	}
+
+	var s request.Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+
+	log.Printf("Saved a snapshot of %d topics to %s", len(s.Topics), file)
+
+	return nil
+}
+
+// restore loads the snapshot in the file into the broker.
+func restore(address string, credentials request.Credentials, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	body, status, err := request.SendAuthenticatedRequest(context.Background(), http.MethodPost, address+"/admin/snapshot", bytes.NewReader(data), credentials)
+	if err != nil {
+		return err
+	}
+
+	response, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("broker responded with %d: %s", status, response)
+	}
+
+	log.Printf("Restored %s: %s", file, response)
+
+	return nil
+}
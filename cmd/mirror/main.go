@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/NamanBalaji/flux/internal/mirror/api"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/mirror"
+)
+
+func main() {
+	configFile := flag.String(constants.ConfigFlag, fmt.Sprintf("cmd/mirror/%s", constants.DefaultConfigFile), "config file name")
+	flag.Parse()
+
+	cfg, err := config.LoadMirrorConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Error loading config file: %v", err)
+	}
+
+	m, err := mirror.New(*cfg)
+	if err != nil {
+		log.Fatalf("Error creating the mirror: %v", err)
+	}
+
+	port := fmt.Sprintf(":%d", cfg.Port)
+	server := &http.Server{
+		Addr:    port,
+		Handler: api.SetupRouter(m),
+	}
+
+	stopChan := make(chan os.Signal, 1)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+
+	serverErrChan := make(chan struct{})
+
+	go func() {
+		log.Printf("starting mirror on port %s", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Error occurred while trying to start the server %v \n", err)
+			close(serverErrChan)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-stopChan:
+		log.Println("Shutting down mirror...")
+	case <-serverErrChan:
+		log.Println("Shutting down mirror")
+	}
+
+	server.Shutdown(context.Background())
+	// the last checkpoint is written once the pushes in flight are mirrored
+	cancel()
+	<-done
+}
@@ -7,21 +7,95 @@ import (
 
 	"github.com/NamanBalaji/flux/internal/broker/handler"
 	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/acl"
+	"github.com/NamanBalaji/flux/pkg/auth"
 	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/raft"
+	"github.com/NamanBalaji/flux/pkg/ratelimit"
 )
 
-func SetupRouter(cfg config.Config, broker *service.Broker) *gin.Engine {
-	r := gin.Default()
+func SetupRouter(cfg *config.Holder, broker *service.Broker) (*gin.Engine, error) {
+	// auth, acl and the publish rates are only read at startup, a reload can't change them
+	authenticator, err := auth.New(cfg.Get().Auth)
+	if err != nil {
+		return nil, err
+	}
 
-	r.GET("/ping", func(c *gin.Context) {
+	acls, err := acl.NewList(cfg.Get().ACL)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := ratelimit.NewPublishLimits(cfg.Get().Limits)
+
+	engine := gin.Default()
+
+	engine.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "alive",
 		})
 	})
 
-	r.POST("/publish", handler.PublishMessageHandler(cfg, broker))
-	r.POST("/subscribe", handler.RegisterSubscriberHandler(cfg, broker))
-	r.POST("/unsubscribe", handler.UnsubscribeHandler(broker))
+	r := engine.Group("/")
+	if authenticator != nil {
+		r.Use(handler.AuthMiddleware(authenticator))
+	}
+
+	// changes are only accepted by the leader, followers replicate them
+	leader := r.Group("/", handler.LeaderOnly(broker))
+	registerTopicRoutes(leader, cfg, broker, acls, limits)
+	leader.POST("/transactions/:id/commit", handler.CommitTransactionHandler(broker))
+	leader.POST("/transactions/:id/abort", handler.AbortTransactionHandler(broker))
+	registerTopicRoutes(leader.Group("/namespaces/:namespace"), cfg, broker, acls, limits)
+
+	admin := r.Group("/admin", handler.RequireAdmin(acls))
+	admin.GET("/stats", handler.StatsHandler(broker, limits))
+	registerTopicAdminRoutes(admin, broker)
+	namespaceAdmin := r.Group("/namespaces/:namespace/admin", handler.RequireAdmin(acls))
+	namespaceAdmin.GET("/stats", handler.NamespaceStatsHandler(broker, limits))
+	registerTopicAdminRoutes(namespaceAdmin, broker)
+
+	// the other admin routes act on the whole broker, they are not scoped to a namespace
+	admin.GET("/acl", handler.ListACLHandler(acls))
+	admin.POST("/acl", handler.AddACLHandler(broker, acls))
+	admin.DELETE("/acl/:id", handler.RemoveACLHandler(broker, acls))
+	admin.GET("/audit", handler.AuditLogHandler(broker))
+	admin.GET("/replication", handler.ReplicationStatusHandler(broker))
+	admin.GET("/replication/log", handler.ReplicationLogHandler(cfg, broker))
+	admin.POST("/replication/promote", handler.PromoteHandler(cfg, broker))
+	admin.GET("/cluster", handler.ClusterStatusHandler(broker))
+	admin.GET("/snapshot", handler.SnapshotHandler(cfg, broker))
+	admin.POST("/snapshot", handler.RestoreSnapshotHandler(cfg, broker))
+	admin.POST("/config/reload", handler.ReloadConfigHandler(cfg, broker))
+
+	// the nodes of a cluster authenticate to each other with the cluster api key
+	nodes := r.Group("/", handler.RequireAdmin(acls), handler.RequireClusterKey(cfg))
+	nodes.POST(raft.VotePath, handler.RaftVoteHandler(broker))
+	nodes.POST(raft.AppendPath, handler.RaftAppendHandler(broker))
+	nodes.POST(raft.SnapshotPath, handler.RaftSnapshotHandler(broker))
+
+	return engine, nil
+}
+
+// registerTopicRoutes registers the routes addressing topics, on the root group they address the
+// default namespace and on /namespaces/:namespace the namespace of the path.
+func registerTopicRoutes(r *gin.RouterGroup, cfg *config.Holder, broker *service.Broker, acls *acl.List, limits *ratelimit.PublishLimits) {
+	r.POST("/publish", handler.PublishMessageHandler(cfg, broker, acls, limits))
+	r.POST("/publish/raw/:topic", handler.PublishRawMessageHandler(cfg, broker, acls, limits))
+	r.POST("/subscribe", handler.RegisterSubscriberHandler(cfg, broker, acls))
+	r.POST("/unsubscribe", handler.UnsubscribeHandler(broker, acls))
+
+	r.POST("/transactions", handler.BeginTransactionHandler(cfg, broker))
+	r.POST("/transactions/:id/publish", handler.TransactionPublishHandler(cfg, broker, acls, limits))
+
+	r.POST("/reply-topics", handler.CreateReplyTopicHandler(cfg, broker, acls))
+	r.GET("/reply-topics/:topic/reply", handler.AwaitReplyHandler(broker))
+	r.DELETE("/reply-topics/:topic", handler.DeleteReplyTopicHandler(broker))
+}
 
-	return r
+// registerTopicAdminRoutes registers the admin routes addressing topics, like registerTopicRoutes
+// they are served for the default namespace and under /namespaces/:namespace.
+func registerTopicAdminRoutes(r *gin.RouterGroup, broker *service.Broker) {
+	r.GET("/scheduled", handler.ListScheduledHandler(broker))
+	r.DELETE("/scheduled/:id", handler.CancelScheduledHandler(broker))
 }
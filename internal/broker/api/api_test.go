@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/broker/subscriber"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/raft"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+func TestRaftRoutesRequireClusterKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Default()
+	cfg.Cluster.APIKey = "cluster-secret"
+
+	router, err := SetupRouter(config.NewHolder("", cfg), service.NewBroker())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendAppend := func(key string) int {
+		req := httptest.NewRequest(http.MethodPost, raft.AppendPath, strings.NewReader(`{"term":1,"leader":"node2"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(constants.APIKeyHeader, key)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	if code := sendAppend(""); code != http.StatusUnauthorized {
+		t.Fatalf("expected an append without the cluster api key to get 401, got %d", code)
+	}
+
+	if code := sendAppend("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("expected an append with a wrong api key to get 401, got %d", code)
+	}
+
+	if code := sendAppend("cluster-secret"); code == http.StatusUnauthorized {
+		t.Fatal("expected an append with the cluster api key to get through")
+	}
+}
+
+func TestSnapshotRedactsSecrets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Default()
+	cfg.Replication.APIKey = "replication-secret"
+
+	broker := service.NewBroker()
+	opts := subscriber.Options{Secret: []byte("push-secret")}
+	if err := broker.Subscribe(context.Background(), cfg, "orders", "http://localhost:1", false, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Subscribe(context.Background(), cfg, "payments.*", "http://localhost:1", false, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := SetupRouter(config.NewHolder("", cfg), broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(key string) request.Snapshot {
+		req := httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+		if key != "" {
+			req.Header.Set(constants.APIKeyHeader, key)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected the snapshot to be returned, got %d", w.Code)
+		}
+
+		var snapshot request.Snapshot
+		if err := json.Unmarshal(w.Body.Bytes(), &snapshot); err != nil {
+			t.Fatal(err)
+		}
+
+		return snapshot
+	}
+
+	snapshot := fetch("")
+	if len(snapshot.Topics) != 1 || len(snapshot.Topics[0].Subscribers) != 1 || len(snapshot.Patterns) != 1 {
+		t.Fatalf("expected the snapshot to hold the subscription and the pattern, got %+v", snapshot)
+	}
+	if snapshot.Topics[0].Subscribers[0].Subscription.Secret != nil || snapshot.Patterns[0].Subscription.Secret != nil {
+		t.Fatal("expected a snapshot fetched without the replication api key to hold no secrets")
+	}
+
+	snapshot = fetch("replication-secret")
+	if string(snapshot.Topics[0].Subscribers[0].Subscription.Secret) != "push-secret" ||
+		string(snapshot.Patterns[0].Subscription.Secret) != "push-secret" {
+		t.Fatal("expected a snapshot fetched with the replication api key to hold the secrets")
+	}
+}
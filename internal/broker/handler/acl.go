@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/acl"
+	"github.com/NamanBalaji/flux/pkg/audit"
+)
+
+// authorize checks the caller may perform the action on the topic and responds with 403 if not.
+func authorize(c *gin.Context, acls *acl.List, action acl.Action, topic string) bool {
+	subject := subjectFromContext(c)
+	if acls.Allowed(subject, action, topic) {
+		return true
+	}
+
+	reason := fmt.Sprintf("%s is not allowed to %s topic %s", subject, action, topic)
+	log.Printf("forbidden request [ERROR]: %s", reason)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"message": reason,
+	})
+
+	return false
+}
+
+// RequireAdmin only lets the subjects configured as acl admins use the routes.
+func RequireAdmin(acls *acl.List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subject := subjectFromContext(c)
+		if !acls.IsAdmin(subject) {
+			reason := fmt.Sprintf("%s is not allowed to use admin endpoints", subject)
+			log.Printf("forbidden request [ERROR]: %s", reason)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": reason,
+			})
+
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func ListACLHandler(acls *acl.List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !aclsEnabled(c, acls) {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"rules": acls.Rules(),
+		})
+	}
+}
+
+func AddACLHandler(broker *service.Broker, acls *acl.List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !aclsEnabled(c, acls) {
+			return
+		}
+
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Printf("invalid request body [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, err)
+
+			return
+		}
+
+		var body acl.Rule
+		err = json.Unmarshal(jsonData, &body)
+		if err != nil {
+			log.Printf("invalid body format [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, err)
+
+			return
+		}
+
+		rule, err := acls.Add(body)
+		if err != nil {
+			log.Printf("invalid acl rule [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		recordAudit(c, broker, audit.ACLAdd, rule.Id, nil, rule)
+
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+func RemoveACLHandler(broker *service.Broker, acls *acl.List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !aclsEnabled(c, acls) {
+			return
+		}
+
+		id := c.Param("id")
+		rule, ok := acls.Remove(id)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": fmt.Sprintf("no acl rule with id %s", id),
+			})
+
+			return
+		}
+		recordAudit(c, broker, audit.ACLRemove, id, rule, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": fmt.Sprintf("acl rule %s removed", id),
+		})
+	}
+}
+
+func aclsEnabled(c *gin.Context, acls *acl.List) bool {
+	if acls == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "acls are disabled",
+		})
+
+		return false
+	}
+
+	return true
+}
+
+func subjectFromContext(c *gin.Context) string {
+	if identity := IdentityFromContext(c); identity != nil {
+		return identity.Subject
+	}
+
+	return ""
+}
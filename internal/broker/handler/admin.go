@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/audit"
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
+	"github.com/NamanBalaji/flux/pkg/ratelimit"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+// ListScheduledHandler lists the scheduled messages of every topic, or of the topics of the namespace
+// of the path.
+func ListScheduledHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messages := broker.ScheduledMessages()
+		if namespace := c.Param("namespace"); namespace != "" {
+			filtered := []request.ScheduledMessage{}
+			for _, msg := range messages {
+				if ns, _ := topicPkg.SplitName(msg.Topic); ns == namespace {
+					filtered = append(filtered, msg)
+				}
+			}
+			messages = filtered
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"messages": messages,
+		})
+	}
+}
+
+func CancelScheduledHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if c.Query("topic") == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "the topic of the scheduled message is required",
+			})
+
+			return
+		}
+
+		topic, ok := qualifyOrReject(c, c.Query("topic"))
+		if !ok {
+			return
+		}
+
+		var before *request.ScheduledMessage
+		for _, msg := range broker.ScheduledMessages() {
+			if msg.Topic == topic && msg.Id == id {
+				before = &msg
+			}
+		}
+
+		if !broker.CancelScheduled(topic, id) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": fmt.Sprintf("no scheduled message with id %s for topic %s", id, topic),
+			})
+
+			return
+		}
+		recordAudit(c, broker, audit.ScheduledCancel, topic+"/"+id, before, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": fmt.Sprintf("scheduled message with id %s for topic %s cancelled", id, topic),
+		})
+	}
+}
+
+func StatsHandler(broker *service.Broker, limits *ratelimit.PublishLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats := broker.Stats()
+		stats.Throttled = limits.Stats()
+
+		c.JSON(http.StatusOK, stats)
+	}
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/audit"
+)
+
+// recordAudit appends a change made by the caller to the audit trail.
+func recordAudit(c *gin.Context, broker *service.Broker, action audit.Action, target string, before any, after any) {
+	err := broker.Audit.Record(audit.Event{
+		Action:    action,
+		Principal: subjectFromContext(c),
+		IP:        c.ClientIP(),
+		Target:    target,
+		Before:    before,
+		After:     after,
+	})
+	if err != nil {
+		log.Printf("failed to record audit event %s on %s [ERROR]: %s", action, target, err)
+	}
+}
+
+// AuditLogHandler returns the audit events matching the action, principal, target, since and
+// until (RFC 3339) query parameters, the most recent limit events are returned.
+func AuditLogHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if broker.Audit == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "audit log is disabled",
+			})
+
+			return
+		}
+
+		q := audit.Query{
+			Action:    audit.Action(c.Query("action")),
+			Principal: c.Query("principal"),
+			Target:    c.Query("target"),
+		}
+
+		var err error
+		if since := c.Query("since"); since != "" {
+			if q.Since, err = time.Parse(time.RFC3339, since); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid since %q: %s", since, err),
+				})
+
+				return
+			}
+		}
+
+		if until := c.Query("until"); until != "" {
+			if q.Until, err = time.Parse(time.RFC3339, until); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid until %q: %s", until, err),
+				})
+
+				return
+			}
+		}
+
+		if limit := c.Query("limit"); limit != "" {
+			if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid limit %q", limit),
+				})
+
+				return
+			}
+		}
+
+		events, err := broker.Audit.Query(q)
+		if err != nil {
+			log.Printf("failed to query the audit log [ERROR]: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "failed to query the audit log",
+			})
+
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"events": events,
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/pkg/auth"
+	"github.com/NamanBalaji/flux/pkg/constants"
+)
+
+// AuthMiddleware rejects unauthenticated requests and attaches the caller's identity to the context.
+func AuthMiddleware(authenticator auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, err := authenticator.Authenticate(c.Request)
+		if err != nil {
+			log.Printf("unauthenticated request to %s [ERROR]: %s", c.Request.URL.Path, err)
+			c.Header("WWW-Authenticate", `Bearer realm="flux"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message": "authentication required",
+			})
+
+			return
+		}
+
+		c.Set(constants.IdentityKey, identity)
+		c.Next()
+	}
+}
+
+// IdentityFromContext returns the authenticated caller, nil when authentication is disabled.
+func IdentityFromContext(c *gin.Context) *auth.Identity {
+	identity, ok := c.Get(constants.IdentityKey)
+	if !ok {
+		return nil
+	}
+
+	return identity.(*auth.Identity)
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/raft"
+)
+
+// RequireClusterKey only lets the nodes of the cluster, sending the cluster api key, use the raft routes.
+func RequireClusterKey(cfg *config.Holder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := cfg.Get().Cluster.APIKey
+		if key == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader(constants.APIKeyHeader)), []byte(key)) != 1 {
+			log.Printf("unauthorized raft request [ERROR]: missing or invalid cluster api key")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message": "the raft routes require the cluster api key",
+			})
+
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RaftVoteHandler answers the vote requests of the candidates of the cluster.
+func RaftVoteHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req raft.VoteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.Printf("failed to bind the raft vote request [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		res, err := broker.RaftVote(req)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// RaftAppendHandler stores the entries sent by the leader of the cluster.
+func RaftAppendHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req raft.AppendRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.Printf("failed to bind the raft append request [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		res, err := broker.RaftAppend(req)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// RaftSnapshotHandler installs the snapshot sent by the leader of the cluster.
+func RaftSnapshotHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req raft.SnapshotRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.Printf("failed to bind the raft snapshot request [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		res, err := broker.RaftInstallSnapshot(req)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+func ClusterStatusHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, err := broker.ClusterStatus()
+		if errors.Is(err, service.ErrNotClustered) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	}
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/audit"
+	"github.com/NamanBalaji/flux/pkg/config"
+)
+
+// ReloadConfigHandler reloads the config file and applies it to the running broker.
+func ReloadConfigHandler(cfg *config.Holder, broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		before := cfg.Get()
+		after, err := cfg.Reload()
+		if err != nil {
+			log.Printf("failed to reload the config [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		recordAudit(c, broker, audit.ConfigReload, "config", before.Reloadable(), after.Reloadable())
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "config reloaded successfully",
+		})
+	}
+}
@@ -2,20 +2,32 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 
 	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/acl"
+	"github.com/NamanBalaji/flux/pkg/audit"
+	"github.com/NamanBalaji/flux/pkg/broker/subscriber"
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
 	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/filter"
 	"github.com/NamanBalaji/flux/pkg/message"
+	"github.com/NamanBalaji/flux/pkg/ratelimit"
 	"github.com/NamanBalaji/flux/pkg/request"
 )
 
-func PublishMessageHandler(cfg config.Config, broker *service.Broker) gin.HandlerFunc {
+func PublishMessageHandler(cfg *config.Holder, broker *service.Broker, acls *acl.List, limits *ratelimit.PublishLimits) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		jsonData, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -34,22 +46,181 @@ func PublishMessageHandler(cfg config.Config, broker *service.Broker) gin.Handle
 			return
 		}
 
-		msg := message.NewMessage(body.Id, body.Message)
+		if !qualifyPublish(c, &body) {
+			return
+		}
 
-		transformedRequest := service.PublishRequest{
-			Topic:   body.Topic,
-			Message: msg,
+		if !authorize(c, acls, acl.Publish, body.Topic) || !admitPublish(c, cfg.Get(), broker, limits, body) {
+			return
 		}
 
-		broker.EnqueueRequest(transformedRequest)
+		enqueuePublish(c, broker, body)
+	}
+}
 
-		c.JSON(http.StatusOK, gin.H{
-			"message": fmt.Sprintf("message enqued for processing %s", body.Topic),
+// PublishRawMessageHandler publishes the request body as an opaque payload, the message id,
+// content type and headers are read from the HTTP headers.
+func PublishRawMessageHandler(cfg *config.Holder, broker *service.Broker, acls *acl.List, limits *ratelimit.PublishLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payload, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Printf("invalid request body [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, err)
+
+			return
+		}
+
+		body := request.PublishMessageRequest{
+			Id:            c.GetHeader(constants.IdHeader),
+			Data:          payload,
+			ContentType:   c.ContentType(),
+			Topic:         c.Param("topic"),
+			Headers:       request.HeadersFromHTTP(c.Request.Header),
+			Delay:         c.GetHeader(constants.DelayHeader),
+			TTL:           c.GetHeader(constants.TTLHeader),
+			ReplyTo:       c.GetHeader(constants.ReplyToHeader),
+			CorrelationId: c.GetHeader(constants.CorrelationHeader),
+		}
+		if body.Id == "" {
+			body.Id = uuid.New().String()
+		}
+		if body.ContentType == "" {
+			body.ContentType = constants.OctetStream
+		}
+		body.ProducerId = c.GetHeader(constants.ProducerHeader)
+		if sequence := c.GetHeader(constants.SequenceHeader); sequence != "" {
+			body.Sequence, err = strconv.ParseUint(sequence, 10, 64)
+			if err != nil {
+				log.Printf("invalid %s header [ERROR]: %s", constants.SequenceHeader, err)
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid %s header: %s", constants.SequenceHeader, err),
+				})
+
+				return
+			}
+		}
+		if priority := c.GetHeader(constants.PriorityHeader); priority != "" {
+			body.Priority, err = strconv.Atoi(priority)
+			if err != nil {
+				log.Printf("invalid %s header [ERROR]: %s", constants.PriorityHeader, err)
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid %s header: %s", constants.PriorityHeader, err),
+				})
+
+				return
+			}
+		}
+		if deliverAt := c.GetHeader(constants.DeliverAtHeader); deliverAt != "" {
+			t, err := time.Parse(time.RFC3339, deliverAt)
+			if err != nil {
+				log.Printf("invalid %s header [ERROR]: %s", constants.DeliverAtHeader, err)
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid %s header: %s", constants.DeliverAtHeader, err),
+				})
+
+				return
+			}
+			body.DeliverAt = &t
+		}
+
+		if !qualifyPublish(c, &body) {
+			return
+		}
+
+		if !authorize(c, acls, acl.Publish, body.Topic) || !admitPublish(c, cfg.Get(), broker, limits, body) {
+			return
+		}
+
+		enqueuePublish(c, broker, body)
+	}
+}
+
+// toPublishRequest validates the publish request and turns it into a message of owner for the broker.
+func toPublishRequest(body request.PublishMessageRequest, owner string) (service.PublishRequest, error) {
+	deliverAt, err := body.DeliveryTime(time.Now())
+	if err != nil {
+		return service.PublishRequest{}, err
+	}
+
+	ttl, err := body.MessageTTL()
+	if err != nil {
+		return service.PublishRequest{}, err
+	}
+
+	msg := message.NewMessage(body.Id, body.Payload())
+	msg.ContentType = body.ContentType
+	msg.SetHeaders(body.Headers)
+	msg.TTL = ttl
+	msg.Priority = body.Priority
+	msg.ReplyTo = body.ReplyTo
+	msg.CorrelationId = body.CorrelationId
+	msg.Owner = owner
+
+	return service.PublishRequest{
+		Topic:      body.Topic,
+		Message:    msg,
+		DeliverAt:  deliverAt,
+		ProducerId: body.ProducerId,
+		Sequence:   body.Sequence,
+	}, nil
+}
+
+// enqueuePublish turns the publish request into a message and hands it to the broker.
+func enqueuePublish(c *gin.Context, broker *service.Broker, body request.PublishMessageRequest) {
+	transformedRequest, err := toPublishRequest(body, subjectFromContext(c))
+	if err != nil {
+		log.Printf("invalid publish request [ERROR]: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
 		})
+
+		return
+	}
+	transformedRequest.Result = make(chan service.PublishResult, 1)
+	deliverAt := transformedRequest.DeliverAt
+
+	broker.EnqueueRequest(transformedRequest)
+
+	response := request.PublishResponse{
+		Message: fmt.Sprintf("message enqued for processing %s", body.Topic),
+		Id:      body.Id,
+	}
+	if !deliverAt.IsZero() {
+		response.DeliverAt = &deliverAt
+	}
+
+	select {
+	case result := <-transformedRequest.Result:
+		if result.Err != nil {
+			log.Printf("failed to publish message with id %s [ERROR]: %s", body.Id, result.Err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"message": result.Err.Error(),
+			})
+
+			return
+		}
+		if result.OutOfSequence {
+			response.Message = fmt.Sprintf("sequence %d of producer %s is out of order for topic %s", body.Sequence, body.ProducerId, body.Topic)
+			response.ExpectedSequence = result.ExpectedSequence
+			c.JSON(http.StatusConflict, response)
+
+			return
+		}
+		if result.Duplicate {
+			response.Message = fmt.Sprintf("duplicate message with id %s ignored for topic %s", body.Id, body.Topic)
+			response.Duplicate = true
+		}
+		if !waitReplicated(c, broker, result.Offset) {
+			return
+		}
+	case <-c.Request.Context().Done():
+		return
 	}
+
+	c.JSON(http.StatusOK, response)
 }
 
-func RegisterSubscriberHandler(cfg config.Config, broker *service.Broker) gin.HandlerFunc {
+func RegisterSubscriberHandler(cfg *config.Holder, broker *service.Broker, acls *acl.List) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		jsonData, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -68,11 +239,72 @@ func RegisterSubscriberHandler(cfg config.Config, broker *service.Broker) gin.Ha
 			return
 		}
 
-		// create new subscriber for each topic
+		f, err := filter.Parse(body.Filter)
+		if err != nil {
+			log.Printf("invalid filter [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("invalid filter: %s", err),
+			})
+
+			return
+		}
+
+		var ok bool
+		if body.Topics, ok = qualifyTopics(c, body.Topics); !ok {
+			return
+		}
+
 		for _, topic := range body.Topics {
-			broker.Subscribe(c, cfg, topic, body.Address, body.ReadOld)
+			_, name := topicPkg.SplitName(topic)
+			if err := topicPkg.ValidatePattern(name); err != nil {
+				log.Printf("invalid topic [ERROR]: %s", err)
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+
+				return
+			}
+
+			if !authorize(c, acls, acl.Subscribe, topic) {
+				return
+			}
+
+			if err := broker.CheckReplySubscription(topic, subjectFromContext(c)); err != nil {
+				replyTopicError(c, err)
+
+				return
+			}
 		}
 
+		settings := cfg.Get()
+		err = broker.ReserveSubscriptions(settings, subjectFromContext(c), body.Topics, body.Address)
+		if err != nil {
+			tooManyRequests(c, 0, err.Error())
+
+			return
+		}
+
+		before := broker.Subscriptions(body.Address)
+		opts := subscriber.Options{Raw: body.Raw, Filter: f, Secret: []byte(body.Secret), Owner: subjectFromContext(c)}
+		// create new subscriber for each topic
+		for i, topic := range body.Topics {
+			if err := broker.Subscribe(c, settings, topic, body.Address, body.ReadOld, opts); err != nil {
+				log.Printf("failed to subscribe to %s [ERROR]: %s", topic, err)
+				// the reservations of the subscriptions not made are given back
+				for _, t := range body.Topics[i:] {
+					if !slices.Contains(before, t) {
+						broker.ReleaseSubscription(subjectFromContext(c), t, body.Address)
+					}
+				}
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"message": err.Error(),
+				})
+
+				return
+			}
+		}
+		recordAudit(c, broker, audit.Subscribe, body.Address, before, broker.Subscriptions(body.Address))
+
 		c.JSON(http.StatusOK, gin.H{
 			"message": "subscriber registered successfully",
 			"topics":  body.Topics,
@@ -80,7 +312,29 @@ func RegisterSubscriberHandler(cfg config.Config, broker *service.Broker) gin.Ha
 	}
 }
 
-func UnsubscribeHandler(broker *service.Broker) gin.HandlerFunc {
+// authorizeOwner checks the caller registered the subscription of the address to the topic, or is an
+// admin, and responds with 403 if not so a consumer can't unsubscribe the others. It returns the owner
+// of the subscription, the caller when there is none.
+func authorizeOwner(c *gin.Context, broker *service.Broker, acls *acl.List, topic string, address string) (string, bool) {
+	subject := subjectFromContext(c)
+	owner, ok := broker.SubscriptionOwner(topic, address)
+	if !ok {
+		return subject, true
+	}
+	if owner == subject || acls.IsAdmin(subject) {
+		return owner, true
+	}
+
+	reason := fmt.Sprintf("%s is not allowed to unsubscribe %s from topic %s, it was subscribed by %s", subject, address, topic, owner)
+	log.Printf("forbidden request [ERROR]: %s", reason)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"message": reason,
+	})
+
+	return "", false
+}
+
+func UnsubscribeHandler(broker *service.Broker, acls *acl.List) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		jsonData, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -99,22 +353,56 @@ func UnsubscribeHandler(broker *service.Broker) gin.HandlerFunc {
 			return
 		}
 
+		var ok bool
+		if body.Topics, ok = qualifyTopics(c, body.Topics); !ok {
+			return
+		}
+
+		// the quota of the subscriptions is given back to their owners, not to an admin unsubscribing them
+		owners := make(map[string]string, len(body.Topics))
+		for _, topic := range body.Topics {
+			if !authorize(c, acls, acl.Subscribe, topic) {
+				return
+			}
+
+			owner, ok := authorizeOwner(c, broker, acls, topic, body.Address)
+			if !ok {
+				return
+			}
+			owners[topic] = owner
+		}
+
 		// if topics absent return error
 		err = broker.ValidateTopics(body.Topics)
 		if err != nil {
 			log.Printf("topics not valid: %s", err)
 
 			c.JSON(http.StatusBadRequest, fmt.Errorf("topics not valid: %s", err))
+
+			return
 		}
 
+		before := broker.Subscriptions(body.Address)
 		for _, topic := range body.Topics {
 			err := broker.Unsubscribe(topic, body.Address)
+			if errors.Is(err, service.ErrNotCommitted) {
+				log.Printf("failed to unsubscribe [%s]: %s", topic, err)
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"message": err.Error(),
+				})
+
+				return
+			}
 			if err != nil {
 				log.Printf("failed to unsubscribe [%s]: %s", topic, err)
 
 				c.JSON(http.StatusBadRequest, fmt.Errorf("topics not valid: %s", err))
+
+				return
 			}
+			broker.ReleaseSubscription(owners[topic], topic, body.Address)
 		}
+		recordAudit(c, broker, audit.Unsubscribe, body.Address, before, broker.Subscriptions(body.Address))
 
 		c.JSON(http.StatusOK, gin.H{
 			"message": "unsubscribed successfully",
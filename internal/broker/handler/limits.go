@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/ratelimit"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+// admitPublish checks the publish against the rate limits and the caller's storage quota and
+// responds with 429 if it is rejected.
+func admitPublish(c *gin.Context, cfg config.Config, broker *service.Broker, limits *ratelimit.PublishLimits, body request.PublishMessageRequest) bool {
+	subject := subjectFromContext(c)
+	size := len(body.Payload())
+
+	if throttled := limits.Allow(subject, body.Topic, c.ClientIP(), size, time.Now()); throttled != nil {
+		tooManyRequests(c, throttled.RetryAfter, throttled.Error())
+
+		return false
+	}
+
+	if err := broker.CheckStorageQuota(cfg, subject, body.Topic, size); err != nil {
+		// storage is only given back when acknowledged messages are cleaned up
+		tooManyRequests(c, time.Duration(cfg.Message.CleanupTime), err.Error())
+
+		return false
+	}
+
+	return true
+}
+
+func tooManyRequests(c *gin.Context, retryAfter time.Duration, reason string) {
+	log.Printf("request rejected [ERROR]: %s", reason)
+	if retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"message": reason,
+	})
+}
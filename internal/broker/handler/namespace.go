@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
+	"github.com/NamanBalaji/flux/pkg/ratelimit"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+// qualifyTopic returns the qualified name of a topic addressed in the namespace of the api path.
+// Outside of /namespaces/:namespace paths topics are in the default namespace unless already
+// qualified, e.g. payments/orders. Inside them names already qualified with the namespace of the
+// path, like the topics of pushed messages, are kept.
+func qualifyTopic(c *gin.Context, name string) (string, error) {
+	qualified := name
+	if namespace := c.Param("namespace"); namespace != "" {
+		qualified = topicPkg.QualifiedName(namespace, name)
+		if strings.Contains(name, topicPkg.NamespaceSeparator) {
+			if ns, _ := topicPkg.SplitName(name); ns != namespace {
+				return "", fmt.Errorf("topic %s is not in the namespace %s", name, namespace)
+			}
+			qualified = name
+		}
+	}
+
+	namespace, local := topicPkg.SplitName(qualified)
+	if err := topicPkg.ValidateNamespace(namespace); err != nil {
+		return "", err
+	}
+
+	if strings.Contains(local, topicPkg.NamespaceSeparator) {
+		return "", fmt.Errorf("topic %s can not contain %s", local, topicPkg.NamespaceSeparator)
+	}
+
+	return qualified, nil
+}
+
+// qualifyOrReject qualifies the topic and responds with 400 if it is invalid.
+func qualifyOrReject(c *gin.Context, name string) (string, bool) {
+	qualified, err := qualifyTopic(c, name)
+	if err != nil {
+		log.Printf("invalid topic [ERROR]: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+
+		return "", false
+	}
+
+	return qualified, true
+}
+
+// qualifyPublish qualifies the topic a message is published to, and its reply topic, and responds
+// with 400 if one is invalid or the topic contains wildcards, publishing creates the topic so it
+// has to be a literal name.
+func qualifyPublish(c *gin.Context, body *request.PublishMessageRequest) bool {
+	if err := topicPkg.ValidateName(body.Topic); err != nil {
+		log.Printf("invalid topic [ERROR]: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+
+		return false
+	}
+
+	var ok bool
+	if body.Topic, ok = qualifyOrReject(c, body.Topic); !ok {
+		return false
+	}
+
+	if body.ReplyTo != "" {
+		if body.ReplyTo, ok = qualifyOrReject(c, body.ReplyTo); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// qualifyTopics qualifies every topic and responds with 400 if one of them is invalid.
+func qualifyTopics(c *gin.Context, topics []string) ([]string, bool) {
+	qualified := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		q, ok := qualifyOrReject(c, topic)
+		if !ok {
+			return nil, false
+		}
+		qualified = append(qualified, q)
+	}
+
+	return qualified, true
+}
+
+// NamespaceStatsHandler returns the stats of the namespace and of its topics.
+func NamespaceStatsHandler(broker *service.Broker, limits *ratelimit.PublishLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		namespace := c.Param("namespace")
+		stats := broker.Stats()
+
+		filtered := request.BrokerStats{
+			Topics:     []request.TopicStats{},
+			Namespaces: []request.NamespaceStats{},
+			Throttled:  limits.Stats(),
+		}
+		for _, t := range stats.Topics {
+			if ns, _ := topicPkg.SplitName(t.Name); ns == namespace {
+				filtered.Topics = append(filtered.Topics, t)
+			}
+		}
+		for _, ns := range stats.Namespaces {
+			if ns.Name == namespace {
+				filtered.Namespaces = append(filtered.Namespaces, ns)
+			}
+		}
+
+		c.JSON(http.StatusOK, filtered)
+	}
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/audit"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+)
+
+// LeaderOnly redirects the changes sent to a follower to the leader with 307, or rejects them with
+// 503 while no leader is known.
+func LeaderOnly(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := broker.ReplicationStatus()
+		if status.Role != constants.RoleFollower {
+			c.Next()
+
+			return
+		}
+
+		if status.Leader == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"message": "broker is a follower and no leader is elected yet, retry later",
+			})
+
+			return
+		}
+
+		c.Header(constants.LeaderHeader, status.Leader)
+		c.Redirect(http.StatusTemporaryRedirect, status.Leader+c.Request.URL.RequestURI())
+		c.Abort()
+	}
+}
+
+// ReplicationLogHandler returns the changes from the from query parameter on to the follower,
+// holding the request open until there are new changes. The push secrets of the subscriptions are
+// only returned to requests sending the replication api key, other admins get them redacted.
+func ReplicationLogHandler(cfg *config.Holder, broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		follower := c.Query("follower")
+		if follower == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "follower is required",
+			})
+
+			return
+		}
+
+		from, err := strconv.ParseUint(c.DefaultQuery("from", "1"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("invalid from %q", c.Query("from")),
+			})
+
+			return
+		}
+
+		wait := constants.ReplicationPollWait
+		if w := c.Query("wait"); w != "" {
+			if wait, err = time.ParseDuration(w); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid wait %q: %s", w, err),
+				})
+
+				return
+			}
+		}
+
+		key := cfg.Get().Replication.APIKey
+		withSecrets := key != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader(constants.APIKeyHeader)), []byte(key)) == 1
+
+		res, err := broker.ReplicationLog(c.Request.Context(), follower, from, wait, withSecrets)
+		switch {
+		case errors.Is(err, service.ErrNotLeader):
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"message": err.Error(),
+			})
+		case errors.Is(err, service.ErrLogTrimmed):
+			log.Printf("follower %s is too far behind [ERROR]: %s", follower, err)
+			c.JSON(http.StatusGone, gin.H{
+				"message": err.Error(),
+			})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": err.Error(),
+			})
+		default:
+			c.JSON(http.StatusOK, res)
+		}
+	}
+}
+
+func ReplicationStatusHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, broker.ReplicationStatus())
+	}
+}
+
+// PromoteHandler turns the follower into the leader.
+func PromoteHandler(cfg *config.Holder, broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		before := broker.ReplicationStatus()
+		if err := broker.Promote(cfg.Get()); err != nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+		after := broker.ReplicationStatus()
+		recordAudit(c, broker, audit.Promote, "replication", before, after)
+
+		c.JSON(http.StatusOK, after)
+	}
+}
+
+// waitReplicated waits until the in sync followers have the published offset, it responds with
+// 504 if the request is cancelled first.
+func waitReplicated(c *gin.Context, broker *service.Broker, offset uint64) bool {
+	if err := broker.WaitReplicated(c.Request.Context(), offset); err != nil {
+		log.Printf("failed to wait for the replication of offset %d [ERROR]: %s", offset, err)
+		c.JSON(http.StatusGatewayTimeout, gin.H{
+			"message": "message published but not confirmed by the followers",
+		})
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/acl"
+	"github.com/NamanBalaji/flux/pkg/audit"
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+// CreateReplyTopicHandler creates a reply topic in the namespace of the path owned by the authenticated
+// principal, which has to be allowed to subscribe to the reply topics. The name of the topic within
+// the namespace is returned.
+func CreateReplyTopicHandler(cfg *config.Holder, broker *service.Broker, acls *acl.List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		replyTopics, ok := qualifyOrReject(c, constants.ReplyTopicPrefix+topicPkg.SingleLevelWildcard)
+		if !ok || !authorize(c, acls, acl.Subscribe, replyTopics) {
+			return
+		}
+
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Printf("invalid request body [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, err)
+
+			return
+		}
+
+		var body request.CreateReplyTopicRequest
+		if len(jsonData) > 0 {
+			err = json.Unmarshal(jsonData, &body)
+			if err != nil {
+				log.Printf("invalid body format [ERROR]: %s", err)
+				c.JSON(http.StatusBadRequest, err)
+
+				return
+			}
+		}
+
+		var ttl time.Duration
+		if body.TTL != "" {
+			ttl, err = time.ParseDuration(body.TTL)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid ttl %q: %s", body.TTL, err),
+				})
+
+				return
+			}
+		}
+
+		namespace, _ := topicPkg.SplitName(replyTopics)
+		topic, err := broker.CreateReplyTopic(cfg.Get(), namespace, ttl, subjectFromContext(c))
+		if err != nil {
+			replyTopicError(c, err)
+
+			return
+		}
+		recordAudit(c, broker, audit.TopicCreate, topic, nil, gin.H{"temporary": true})
+
+		_, name := topicPkg.SplitName(topic)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "reply topic created",
+			"topic":   name,
+		})
+	}
+}
+
+// AwaitReplyHandler holds the request until a reply with the correlation id is published
+// to the reply topic or the timeout query parameter, a Go duration, elapses. Only the principal
+// that created the reply topic may wait on it.
+func AwaitReplyHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		topic, ok := qualifyOrReject(c, c.Param("topic"))
+		if !ok {
+			return
+		}
+
+		correlationId := c.Query("correlationId")
+		if correlationId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "correlationId is required",
+			})
+
+			return
+		}
+
+		timeout := constants.MaxReplyWait
+		if t := c.Query("timeout"); t != "" {
+			d, err := time.ParseDuration(t)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid timeout %q: %s", t, err),
+				})
+
+				return
+			}
+			timeout = min(d, constants.MaxReplyWait)
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		msg, err := broker.AwaitReply(ctx, topic, correlationId, subjectFromContext(c))
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			c.JSON(http.StatusRequestTimeout, gin.H{
+				"message": fmt.Sprintf("no reply with correlation id %s received", correlationId),
+			})
+
+			return
+		}
+		if err != nil {
+			replyTopicError(c, err)
+
+			return
+		}
+
+		c.JSON(http.StatusOK, request.PollMessage{
+			Id:            msg.Id,
+			Payload:       msg.Payload,
+			ContentType:   msg.ContentType,
+			Topic:         topic,
+			Headers:       msg.Headers,
+			CorrelationId: msg.CorrelationId,
+		})
+	}
+}
+
+// DeleteReplyTopicHandler deletes a reply topic, only the principal that created it may delete it.
+func DeleteReplyTopicHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		topic, ok := qualifyOrReject(c, c.Param("topic"))
+		if !ok {
+			return
+		}
+
+		if !service.IsReplyTopic(topic) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("%s is not a reply topic", topic),
+			})
+
+			return
+		}
+
+		stats, err := broker.DeleteReplyTopic(topic, subjectFromContext(c))
+		if err != nil {
+			replyTopicError(c, err)
+
+			return
+		}
+		recordAudit(c, broker, audit.TopicDelete, topic, stats, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": fmt.Sprintf("reply topic %s deleted", topic),
+		})
+	}
+}
+
+func replyTopicError(c *gin.Context, err error) {
+	status := http.StatusNotFound
+	switch {
+	case errors.Is(err, service.ErrNotReplyTopicOwner):
+		status = http.StatusForbidden
+	case errors.Is(err, service.ErrReplyTopicPattern):
+		status = http.StatusBadRequest
+	case errors.Is(err, service.ErrNotCommitted):
+		status = http.StatusServiceUnavailable
+	}
+
+	log.Printf("reply topic request failed [ERROR]: %s", err)
+	c.JSON(status, gin.H{
+		"message": err.Error(),
+	})
+}
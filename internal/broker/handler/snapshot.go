@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/audit"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+// SnapshotHandler returns the snapshot of the whole broker state, the push secrets are only
+// returned to the followers sending the replication api key.
+func SnapshotHandler(cfg *config.Holder, broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := cfg.Get().Replication.APIKey
+		withSecrets := key != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader(constants.APIKeyHeader)), []byte(key)) == 1
+
+		c.JSON(http.StatusOK, broker.Snapshot(withSecrets))
+	}
+}
+
+// RestoreSnapshotHandler loads the snapshot in the body into a broker without topics.
+func RestoreSnapshotHandler(cfg *config.Holder, broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var snapshot request.Snapshot
+		if err := c.ShouldBindJSON(&snapshot); err != nil {
+			log.Printf("invalid snapshot [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		if err := broker.RestoreSnapshot(cfg.Get(), snapshot, subjectFromContext(c)); err != nil {
+			log.Printf("failed to restore the snapshot [ERROR]: %s", err)
+			status := http.StatusBadRequest
+			if errors.Is(err, service.ErrBrokerNotEmpty) || errors.Is(err, service.ErrSnapshotReplicated) {
+				status = http.StatusConflict
+			}
+			c.JSON(status, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		recordAudit(c, broker, audit.SnapshotRestore, "broker", nil, gin.H{
+			"createdAt": snapshot.CreatedAt,
+			"topics":    len(snapshot.Topics),
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "snapshot restored successfully",
+			"topics":  len(snapshot.Topics),
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/broker/service"
+	"github.com/NamanBalaji/flux/pkg/acl"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/ratelimit"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+func BeginTransactionHandler(cfg *config.Holder, broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Printf("invalid request body [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, err)
+
+			return
+		}
+
+		var body request.BeginTransactionRequest
+		if len(jsonData) > 0 {
+			err = json.Unmarshal(jsonData, &body)
+			if err != nil {
+				log.Printf("invalid body format [ERROR]: %s", err)
+				c.JSON(http.StatusBadRequest, err)
+
+				return
+			}
+		}
+
+		timeout := time.Duration(cfg.Get().Message.TransactionTimeout)
+		if timeout <= 0 {
+			timeout = constants.DefaultTransactionTimeout
+		}
+		if body.Timeout != "" {
+			timeout, err = time.ParseDuration(body.Timeout)
+			if err != nil || timeout <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid timeout %q", body.Timeout),
+				})
+
+				return
+			}
+		}
+
+		id := broker.BeginTransaction(timeout)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "transaction started",
+			"id":      id,
+		})
+	}
+}
+
+// TransactionPublishHandler buffers a message in the transaction, it is only published on commit.
+func TransactionPublishHandler(cfg *config.Holder, broker *service.Broker, acls *acl.List, limits *ratelimit.PublishLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Printf("invalid request body [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, err)
+
+			return
+		}
+
+		var body request.PublishMessageRequest
+		err = json.Unmarshal(jsonData, &body)
+		if err != nil {
+			log.Printf("invalid body format [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, err)
+
+			return
+		}
+
+		if !qualifyPublish(c, &body) {
+			return
+		}
+
+		if !authorize(c, acls, acl.Publish, body.Topic) || !admitPublish(c, cfg.Get(), broker, limits, body) {
+			return
+		}
+
+		req, err := toPublishRequest(body, subjectFromContext(c))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+		// producer sequences are not tracked for transactional messages
+		req.ProducerId = ""
+
+		id := c.Param("id")
+		if err := broker.AddToTransaction(id, req); err != nil {
+			transactionError(c, err)
+
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": fmt.Sprintf("message added to transaction %s", id),
+			"id":      body.Id,
+		})
+	}
+}
+
+func CommitTransactionHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		result, err := broker.CommitTransaction(id)
+		if err != nil {
+			transactionError(c, err)
+
+			return
+		}
+
+		response := request.CommitTransactionResponse{
+			Message:   fmt.Sprintf("transaction %s committed", id),
+			Published: len(result.BatchResults),
+		}
+
+		if !waitReplicated(c, broker, result.Offset) {
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func AbortTransactionHandler(broker *service.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if err := broker.AbortTransaction(id); err != nil {
+			transactionError(c, err)
+
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": fmt.Sprintf("transaction %s aborted", id),
+		})
+	}
+}
+
+func transactionError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrNoTransaction):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrScheduledInTransaction):
+		status = http.StatusBadRequest
+	case errors.Is(err, service.ErrTransactionRejected):
+		status = http.StatusConflict
+	case errors.Is(err, service.ErrNotCommitted):
+		status = http.StatusServiceUnavailable
+	}
+
+	c.JSON(status, gin.H{
+		"message": err.Error(),
+	})
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"sort"
+
+	"github.com/NamanBalaji/flux/pkg/audit"
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
+)
+
+// recordAudit queues the event to the audit trail, it is called while holding b.mu so the file is
+// written in the background and failures are logged so they don't fail the change.
+func (b *Broker) recordAudit(e audit.Event) {
+	b.Audit.RecordAsync(e)
+}
+
+func (b *Broker) recordTopicCreated(topic *topicPkg.Topic, principal string) {
+	b.recordAudit(audit.Event{
+		Action:    audit.TopicCreate,
+		Principal: principal,
+		Target:    topic.Name,
+		After: map[string]any{
+			"priority": topic.Priority,
+		},
+	})
+}
+
+// Subscriptions returns the patterns and the qualified topics the address is actively subscribed to.
+func (b *Broker) Subscriptions(address string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscriptions := make([]string, 0)
+	for _, p := range b.patterns {
+		if p.address == address {
+			subscriptions = append(subscriptions, p.pattern)
+		}
+	}
+	for name, topic := range b.Topics {
+		if topic.HasActiveSubscriber(address) {
+			subscriptions = append(subscriptions, name)
+		}
+	}
+	sort.Strings(subscriptions)
+
+	return subscriptions
+}
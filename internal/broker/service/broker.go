@@ -4,58 +4,300 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/NamanBalaji/flux/pkg/audit"
+	"github.com/NamanBalaji/flux/pkg/broker/subscriber"
 	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
 	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/dedup"
 	"github.com/NamanBalaji/flux/pkg/message"
+	"github.com/NamanBalaji/flux/pkg/request"
 )
 
 type Broker struct {
 	mu          sync.Mutex
 	Topics      topicPkg.Topics
 	MessageChan chan PublishRequest
+	patterns    []patternSubscription
+	scheduler   *scheduler
+	// producers tracks the producer sequence numbers per topic
+	producers    map[string]map[string]*producerState
+	transactions transactions
+	quotas       quotas
+	// PushTransport is used by the subscribers of every topic to push messages, nil uses the default transport
+	PushTransport http.RoundTripper
+	// Audit records the topics created and deleted by the broker, nil records nothing
+	Audit *audit.Log
+	// role is the replication role, a follower replicates the leader's changes to its standby topics
+	role          string
+	leader        string
+	standby       bool
+	replication   *replicationLog
+	stopFollowing context.CancelFunc
+	followDone    chan struct{}
+	// cluster replaces the replication when the broker is a node of a raft cluster
+	cluster *cluster
+	// processing is held while a publish request is processed, holding it fences the publishes
+	processing sync.Mutex
+}
+
+// patternSubscription is a wildcard subscription which is attached to every matching topic,
+// including topics created after the subscription.
+type patternSubscription struct {
+	pattern string
+	address string
+	readOld bool
+	opts    subscriber.Options
 }
 
 type PublishRequest struct {
 	Topic   string
 	Message *message.Message
+	// DeliverAt delays the delivery of the message until the given time when set
+	DeliverAt time.Time
+	// ProducerId and Sequence make the publish idempotent, sequences must increase by one per topic
+	ProducerId string
+	Sequence   uint64
+	// Batch holds the messages of a committed transaction which are published together
+	Batch []PublishRequest
+	// Result receives the outcome of the request once processed when set, it should be buffered
+	Result chan PublishResult
+}
+
+type PublishResult struct {
+	// Duplicate is set when the message id is inside the topic dedup window
+	Duplicate bool
+	// Scheduled is set when the message is held until its delivery time
+	Scheduled bool
+	// Dropped is set when the message was discarded, e.g. a reply to a deleted reply topic
+	Dropped bool
+	// OutOfSequence is set when the producer skipped sequence numbers, ExpectedSequence is the next one accepted
+	OutOfSequence    bool
+	ExpectedSequence uint64
+	// BatchResults holds the result of every message of a batch in order
+	BatchResults []PublishResult
+	// Rejected is set when no message of the batch was published, BatchResults flags the ones that couldn't be
+	Rejected bool
+	// Offset is the replication offset of the published message, or of the last message of a batch
+	Offset uint64
+	// Err is set when a cluster failed to commit the message, it may still be published
+	Err error
 }
 
 func NewBroker() *Broker {
 	return &Broker{
 		Topics:      topicPkg.CreateTopics(),
 		MessageChan: make(chan PublishRequest, 100),
+		scheduler:   newScheduler(),
+		replication: newReplicationLog(),
+		producers:   make(map[string]map[string]*producerState),
+		transactions: transactions{
+			open: make(map[string]*transaction),
+		},
+		quotas: quotas{
+			subscriptions:    make(map[string]map[subscriptionKey]bool),
+			storage:          make(map[string]int64),
+			namespaceStorage: make(map[string]int64),
+		},
 	}
 }
 
-func (b *Broker) StartRequestPrecessing(cfg config.Config) {
+// StartRequestPrecessing processes the publish requests with the current config of the holder.
+func (b *Broker) StartRequestPrecessing(cfg *config.Holder) {
+	go b.runScheduler(cfg)
+
 	go func() {
 		for req := range b.MessageChan {
-			b.publishMessage(cfg, req.Topic, req.Message)
+			b.processing.Lock()
+			b.processRequest(cfg.Get(), req)
+			b.processing.Unlock()
 		}
 	}()
 }
 
+func (b *Broker) processRequest(cfg config.Config, req PublishRequest) {
+	if len(req.Batch) > 0 {
+		if b.cluster != nil {
+			b.proposeRequest(req, batchEntry(req.Batch))
+
+			return
+		}
+		req.respond(b.publishBatch(cfg, req.Batch))
+
+		return
+	}
+
+	if result, ok := b.checkSequence(req); !ok {
+		req.respond(result)
+
+		return
+	}
+
+	if req.DeliverAt.After(time.Now()) {
+		b.mu.Lock()
+		result, ok := b.acceptSequenceLocked(req)
+		if ok {
+			// scheduled messages stay on this broker, the sequence is accepted once the message is
+			// scheduled so the released message is published without it
+			req.ProducerId, req.Sequence = "", 0
+			result = b.scheduleLocked(req)
+		}
+		b.mu.Unlock()
+		req.respond(result)
+
+		return
+	}
+
+	b.publish(cfg, req)
+}
+
+// publish publishes the message of the request, a cluster answers the request once the message is committed.
+func (b *Broker) publish(cfg config.Config, req PublishRequest) {
+	if b.cluster != nil {
+		b.proposeRequest(req, publishEntry(req))
+
+		return
+	}
+
+	req.respond(b.publishMessage(cfg, req))
+}
+
+func (req PublishRequest) respond(result PublishResult) {
+	if req.Result != nil {
+		req.Result <- result
+	}
+}
+
 func (b *Broker) EnqueueRequest(req PublishRequest) {
 	b.MessageChan <- req
 }
 
-func (b *Broker) publishMessage(cfg config.Config, topicName string, msg *message.Message) {
-	log.Printf("Trying to publish message with id: %s \n", msg.Id)
+func (b *Broker) publishMessage(cfg config.Config, req PublishRequest) PublishResult {
 	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.publishLocked(cfg, req)
+}
+
+// publishLocked publishes the message of the request to its topic and accepts its producer sequence,
+// the caller must hold b.mu.
+func (b *Broker) publishLocked(cfg config.Config, req PublishRequest) PublishResult {
+	topicName, msg := req.Topic, req.Message
+	log.Printf("Trying to publish message with id: %s \n", msg.Id)
+
+	if result, ok := b.acceptSequenceLocked(req); !ok {
+		return result
+	}
+
+	if _, ok := b.Topics[topicName]; !ok && IsReplyTopic(topicName) {
+		log.Printf("Reply topic %s does not exist anymore, dropping message with id %s \n", topicName, msg.Id)
+
+		return PublishResult{Dropped: true}
+	}
+
+	topic := b.getOrCreateTopic(cfg, topicName, msg.Owner)
 
+	if !topic.ShouldEnqueue(msg) {
+		return PublishResult{Duplicate: true}
+	}
+
+	// recorded before the message can be delivered so its acks are replicated after it
+	offset := b.recordChange(publishEntry(req))
+	topic.AddMessage(msg)
+	namespace, _ := topicPkg.SplitName(topicName)
+	b.quotas.storage[msg.Owner] += int64(len(msg.Payload))
+	b.quotas.namespaceStorage[namespace] += int64(len(msg.Payload))
+
+	return PublishResult{Offset: offset}
+}
+
+// getOrCreateTopic returns the named topic, creating it on behalf of the principal and attaching the
+// matching wildcard subscriptions if it does not exist yet. The caller must hold b.mu.
+func (b *Broker) getOrCreateTopic(cfg config.Config, topicName string, principal string) *topicPkg.Topic {
 	topic, ok := b.Topics[topicName]
-	if !ok {
-		topic = topicPkg.CreateTopic(topicName, cfg.Topic.Buffer)
-		b.Topics[topicName] = topic
+	if ok {
+		return topic
+	}
 
-		log.Println("Created topic: ", topicName)
+	namespace, _ := topicPkg.SplitName(topicName)
+	topic = topicPkg.CreateTopic(topicName, cfg.Topic.Buffer)
+	topic.Dedup = newDedupWindow(cfg.ForNamespace(namespace))
+	topic.PushTransport = b.PushTransport
+	topic.OnAck = b.recordAck
+	topic.Standby = b.standby
+	topic.OnExpire = b.expiryHandler(cfg, topicName)
+	for _, pattern := range cfg.Topic.PriorityTopics {
+		if topicPkg.MatchPattern(pattern, topicName) {
+			topic.Priority = true
+			topic.StarvationLimit = cfg.Topic.StarvationLimit
+		}
 	}
-	defer b.mu.Unlock()
+	b.Topics[topicName] = topic
+
+	log.Println("Created topic: ", topicName)
+	b.recordTopicCreated(topic, principal)
+
+	for _, p := range b.patterns {
+		if topicPkg.MatchPattern(p.pattern, topicName) {
+			log.Printf("Subscriber[Address: %s] subscribed to %s attached to the new topic %s \n", p.address, p.pattern, topicName)
+			topic.Subscribe(context.Background(), cfg, p.address, p.readOld, p.opts)
+		}
+	}
+
+	return topic
+}
+
+// dedupWindow is how long message ids and producer sequences are remembered.
+func dedupWindow(cfg config.Config) time.Duration {
+	if cfg.Message.DedupWindow <= 0 {
+		return constants.DefaultDedupWindow
+	}
+
+	return time.Duration(cfg.Message.DedupWindow)
+}
 
-	if topic.ShouldEnqueue(msg) {
-		topic.AddMessage(msg)
+// newDedupWindow creates a topic dedup window from the config, falling back to the defaults.
+func newDedupWindow(cfg config.Config) *dedup.Window {
+	return dedup.NewWindow(dedupWindow(cfg), dedupSize(cfg))
+}
+
+// dedupSize is the maximum number of message ids remembered per topic.
+func dedupSize(cfg config.Config) int {
+	if cfg.Message.DedupSize <= 0 {
+		return constants.DefaultDedupSize
+	}
+
+	return cfg.Message.DedupSize
+}
+
+// expiryHandler returns the callback of the topic's expired messages, nil when they are not dead lettered.
+func (b *Broker) expiryHandler(cfg config.Config, topicName string) func(msg *message.Message, topicName string) {
+	if cfg.Message.DeadLetterTopic == "" || topicName == cfg.Message.DeadLetterTopic {
+		return nil
+	}
+
+	return b.deadLetter(cfg)
+}
+
+// deadLetter returns a callback republishing expired messages to the configured dead letter topic.
+func (b *Broker) deadLetter(cfg config.Config) func(msg *message.Message, topicName string) {
+	return func(msg *message.Message, topicName string) {
+		headers := map[string]string{constants.ExpiredTopicHeader: topicName}
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+
+		dead := message.NewMessage(msg.Id, msg.Payload)
+		dead.ContentType = msg.ContentType
+		dead.SetHeaders(headers)
+
+		log.Printf("Dead lettering expired message with id %s from topic %s to %s \n", msg.Id, topicName, cfg.Message.DeadLetterTopic)
+		b.EnqueueRequest(PublishRequest{Topic: cfg.Message.DeadLetterTopic, Message: dead})
 	}
 }
 
@@ -64,6 +306,10 @@ func (b *Broker) ValidateTopics(topics []string) error {
 	defer b.mu.Unlock()
 
 	for _, topic := range topics {
+		if topicPkg.IsPattern(topic) {
+			continue
+		}
+
 		_, ok := b.Topics[topic]
 		if !ok {
 			return fmt.Errorf("topic %s does not exist", topic)
@@ -73,22 +319,157 @@ func (b *Broker) ValidateTopics(topics []string) error {
 	return nil
 }
 
-func (b *Broker) Subscribe(ctx context.Context, cfg config.Config, topicName string, address string, readOld bool) {
-	b.mu.Lock()
-	topic, ok := b.Topics[topicName]
-	if !ok {
-		topic = topicPkg.CreateTopic(topicName, cfg.Topic.Buffer)
-		b.Topics[topicName] = topic
+// Subscribe subscribes the address to the topic or the topics matching the pattern, a cluster fails
+// with ErrNotCommitted when the subscription is not committed.
+func (b *Broker) Subscribe(ctx context.Context, cfg config.Config, topicName string, address string, readOld bool, opts subscriber.Options) error {
+	e := request.ReplicationEntry{
+		Op:      request.ReplicateSubscribe,
+		Topic:   topicName,
+		Address: address,
+		Subscription: &request.ReplicatedSubscription{
+			ReadOld: readOld,
+			Raw:     opts.Raw,
+			Filter:  opts.Filter.String(),
+			Secret:  opts.Secret,
+			Owner:   opts.Owner,
+		},
+	}
+	if b.cluster != nil {
+		return b.cluster.commit(ctx, e).err
+	}
+
+	b.recordChange(e)
+	b.subscribe(ctx, cfg, topicName, address, readOld, opts)
+
+	return nil
+}
 
-		log.Println("Created topic: ", topicName)
+func (b *Broker) subscribe(ctx context.Context, cfg config.Config, topicName string, address string, readOld bool, opts subscriber.Options) {
+	if topicPkg.IsPattern(topicName) {
+		b.subscribePattern(ctx, cfg, topicName, address, readOld, opts)
+
+		return
 	}
+
+	b.mu.Lock()
+	topic := b.getOrCreateTopic(cfg, topicName, opts.Owner)
 	b.mu.Unlock()
 
 	log.Printf("Subscriber[Address: %s] trying to subscribe to the topic %s \n", address, topicName)
-	topic.Subscribe(ctx, cfg, address, readOld)
+	topic.Subscribe(ctx, cfg, address, readOld, opts)
+}
+
+func (b *Broker) subscribePattern(ctx context.Context, cfg config.Config, pattern string, address string, readOld bool, opts subscriber.Options) {
+	log.Printf("Subscriber[Address: %s] trying to subscribe to the topics matching %s \n", address, pattern)
+
+	b.mu.Lock()
+	sub := patternSubscription{pattern: pattern, address: address, readOld: readOld, opts: opts}
+	replaced := false
+	for i, p := range b.patterns {
+		if p.pattern == pattern && p.address == address {
+			b.patterns[i] = sub
+			replaced = true
+		}
+	}
+	if !replaced {
+		b.patterns = append(b.patterns, sub)
+	}
+
+	var matching []*topicPkg.Topic
+	for name, topic := range b.Topics {
+		// reply topics are only delivered to their owner, like the patterns they are created without
+		if topicPkg.MatchPattern(pattern, name) && !IsReplyTopic(name) {
+			matching = append(matching, topic)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, topic := range matching {
+		topic.Subscribe(ctx, cfg, address, readOld, opts)
+	}
+}
+
+func (b *Broker) unsubscribePattern(pattern string, address string) error {
+	b.mu.Lock()
+	found := false
+	for i, p := range b.patterns {
+		if p.pattern == pattern && p.address == address {
+			b.patterns = append(b.patterns[:i], b.patterns[i+1:]...)
+			found = true
+
+			break
+		}
+	}
+
+	var matching []*topicPkg.Topic
+	for name, topic := range b.Topics {
+		if topicPkg.MatchPattern(pattern, name) {
+			matching = append(matching, topic)
+		}
+	}
+	b.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("subscriber %s is not subscribed to %s", address, pattern)
+	}
+
+	log.Printf("Subscriber[Address: %s] trying to unsubscribe from the topics matching %s \n", address, pattern)
+	for _, topic := range matching {
+		if err := topic.Unsubscribe(address); err != nil {
+			log.Printf("failed to unsubscribe from topic %s: %s \n", topic.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// SubscriptionOwner returns the principal which subscribed the address to the topic or pattern, it
+// reports whether the subscription exists.
+func (b *Broker) SubscriptionOwner(topicName string, address string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if topicPkg.IsPattern(topicName) {
+		for _, p := range b.patterns {
+			if p.pattern == topicName && p.address == address {
+				return p.opts.Owner, true
+			}
+		}
+
+		return "", false
+	}
+
+	topic, ok := b.Topics[topicName]
+	if !ok {
+		return "", false
+	}
+
+	return topic.SubscriberOwner(address)
 }
 
 func (b *Broker) Unsubscribe(topicName string, address string) error {
+	e := request.ReplicationEntry{
+		Op:      request.ReplicateUnsubscribe,
+		Topic:   topicName,
+		Address: address,
+	}
+	if b.cluster != nil {
+		return b.cluster.commit(context.Background(), e).err
+	}
+
+	err := b.unsubscribe(topicName, address)
+	if err == nil {
+		b.recordChange(e)
+	}
+
+	return err
+}
+
+func (b *Broker) unsubscribe(topicName string, address string) error {
+	if topicPkg.IsPattern(topicName) {
+		return b.unsubscribePattern(topicName, address)
+	}
+
 	b.mu.Lock()
 	topic, ok := b.Topics[topicName]
 	b.mu.Unlock()
@@ -120,6 +501,8 @@ func (b *Broker) CleanSubscribers(cfg config.Config) {
 		}(topic)
 	}
 	wg.Wait()
+
+	b.pruneSubscriptions()
 }
 
 func (b *Broker) CleanupMessages(cfg config.Config) {
@@ -134,11 +517,42 @@ func (b *Broker) CleanupMessages(cfg config.Config) {
 	var wg sync.WaitGroup
 	for _, topic := range topicsToClean {
 		wg.Add(1)
+		namespace, _ := topicPkg.SplitName(topic.Name)
 		go func(cfg config.Config, t *topicPkg.Topic) {
 			log.Println("Cleaning messages for topic ", t.Name)
 			defer wg.Done()
 			t.CleanupMessages(cfg)
-		}(cfg, topic)
+		}(cfg.ForNamespace(namespace), topic)
 	}
 	wg.Wait()
+
+	b.CleanupProducers(dedupWindow(cfg))
+	b.recomputeStorage()
+}
+
+func (b *Broker) Stats() request.BrokerStats {
+	var topics []*topicPkg.Topic
+
+	b.mu.Lock()
+	for _, topic := range b.Topics {
+		topics = append(topics, topic)
+	}
+	b.mu.Unlock()
+
+	stats := request.BrokerStats{
+		Topics:    make([]request.TopicStats, 0, len(topics)),
+		Scheduled: len(b.ScheduledMessages()),
+		Tenants:   b.tenantStats(),
+	}
+	for _, topic := range topics {
+		stats.Topics = append(stats.Topics, topic.Stats())
+	}
+
+	sort.Slice(stats.Topics, func(i, j int) bool {
+		return stats.Topics[i].Name < stats.Topics[j].Name
+	})
+
+	stats.Namespaces = b.namespaceStats(stats.Topics)
+
+	return stats
 }
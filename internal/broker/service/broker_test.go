@@ -2,13 +2,26 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/NamanBalaji/flux/internal/rafttest"
+	"github.com/NamanBalaji/flux/pkg/audit"
 	"github.com/NamanBalaji/flux/pkg/broker/subscriber"
 	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
 	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
 	"github.com/NamanBalaji/flux/pkg/message"
+	"github.com/NamanBalaji/flux/pkg/request"
 )
 
 func setupBrokerAndConfig() (*Broker, config.Config) {
@@ -17,10 +30,10 @@ func setupBrokerAndConfig() (*Broker, config.Config) {
 			Buffer: 10,
 		},
 		Subscriber: config.Subscriber{
-			InactiveTime: 1,
+			InactiveTime: config.Seconds(1),
 		},
 		Message: config.Message{
-			TTL: 1,
+			TTL: config.Seconds(1),
 		},
 	}
 
@@ -37,12 +50,12 @@ func assert(t *testing.T, condition bool, msg string) {
 func TestPublishMessage(t *testing.T) {
 	broker, cfg := setupBrokerAndConfig()
 	broker.Topics["testTopic"] = topicPkg.CreateTopic("testTopic", 10)
-	msg := message.NewMessage("id", "payload")
-	broker.publishMessage(cfg, "testTopic", msg)
+	msg := message.NewMessage("id", []byte("payload"))
+	broker.publishMessage(cfg, PublishRequest{Topic: "testTopic", Message: msg})
 
 	topic := broker.Topics["testTopic"]
 
-	_, ok := topic.MessageSet["id"]
+	ok := topic.Dedup.Seen("id", time.Now())
 	l := topic.MessageQueue.Len()
 
 	assert(t, ok, "message should be in topic")
@@ -51,13 +64,13 @@ func TestPublishMessage(t *testing.T) {
 
 func TestCreateTopicAndPublishMessage(t *testing.T) {
 	broker, cfg := setupBrokerAndConfig()
-	msg := message.NewMessage("id", "payload")
-	broker.publishMessage(cfg, "testTopic", msg)
+	msg := message.NewMessage("id", []byte("payload"))
+	broker.publishMessage(cfg, PublishRequest{Topic: "testTopic", Message: msg})
 
 	topic, exist := broker.Topics["testTopic"]
 	assert(t, exist, "topic should have been created")
 
-	_, ok := topic.MessageSet["id"]
+	ok := topic.Dedup.Seen("id", time.Now())
 	l := topic.MessageQueue.Len()
 
 	assert(t, ok, "message should be in topic")
@@ -81,7 +94,7 @@ func TestSubscribeAndUnsubscribe(t *testing.T) {
 
 	ctx := context.Background()
 
-	broker.Subscribe(ctx, cfg, "newTopic", "newAddress", false)
+	broker.Subscribe(ctx, cfg, "newTopic", "newAddress", false, subscriber.Options{})
 
 	topic := broker.Topics["newTopic"]
 
@@ -99,6 +112,25 @@ func TestSubscribeAndUnsubscribe(t *testing.T) {
 	assert(t, !subscriber.IsActive, "subscriber not unsubscribed")
 }
 
+func TestSubscriptionOwner(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	ctx := context.Background()
+
+	broker.Subscribe(ctx, cfg, "orders", "address", false, subscriber.Options{Owner: "alice"})
+	broker.Subscribe(ctx, cfg, "payments.*", "address", false, subscriber.Options{Owner: "bob"})
+
+	owner, ok := broker.SubscriptionOwner("orders", "address")
+	assert(t, ok && owner == "alice", "topic subscription should report its owner")
+	owner, ok = broker.SubscriptionOwner("payments.*", "address")
+	assert(t, ok && owner == "bob", "pattern subscription should report its owner")
+	_, ok = broker.SubscriptionOwner("orders", "other")
+	assert(t, !ok, "unknown subscription should not have an owner")
+
+	broker.Unsubscribe("orders", "address")
+	owner, ok = broker.SubscriptionOwner("orders", "address")
+	assert(t, ok && owner == "alice", "unsubscribed subscriber should keep its owner until cleaned up")
+}
+
 func TestCleanupSubscribers(t *testing.T) {
 	broker, cfg := setupBrokerAndConfig()
 	topic := topicPkg.CreateTopic("testTopic", 10)
@@ -125,9 +157,1042 @@ func TestCleanupMessages(t *testing.T) {
 	broker.Topics["testTopic"] = topic
 
 	// Add an old message that should be deleted
-	msg := &message.Message{Id: "oldMsg", Payload: "old", AddedAt: time.Now().Add(-24 * time.Hour)}
+	msg := &message.Message{Id: "oldMsg", Payload: []byte("old"), AddedAt: time.Now().Add(-24 * time.Hour)}
 	topic.MessageQueue.Enqueue(msg)
 
 	broker.CleanupMessages(cfg)
 	assert(t, topic.MessageQueue.Len() == 0, "Old messages should be cleaned up")
 }
+
+func TestSubscribePattern(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	broker.Topics["orders.eu.created"] = topicPkg.CreateTopic("orders.eu.created", 10)
+	broker.Topics["orders.eu.updated"] = topicPkg.CreateTopic("orders.eu.updated", 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker.Subscribe(ctx, cfg, "orders.*.created", "newAddress", false, subscriber.Options{})
+
+	assert(t, len(broker.Topics["orders.eu.created"].Subscribers) == 1, "existing matching topic should have the subscriber")
+	assert(t, len(broker.Topics["orders.eu.updated"].Subscribers) == 0, "existing non matching topic should not have the subscriber")
+
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders.us.created", Message: message.NewMessage("id", []byte("payload"))})
+	broker.publishMessage(cfg, PublishRequest{Topic: "payments.us.created", Message: message.NewMessage("id", []byte("payload"))})
+
+	created := broker.Topics["orders.us.created"]
+	assert(t, len(created.Subscribers) == 1, "new matching topic should have the subscriber attached")
+	assert(t, len(broker.Topics["payments.us.created"].Subscribers) == 0, "new non matching topic should not have the subscriber")
+
+	err := broker.Unsubscribe("orders.*.created", "newAddress")
+	assert(t, err == nil, "unsubscribing from the pattern should not fail")
+
+	created.Subscribers[0].Lock.Lock()
+	active := created.Subscribers[0].IsActive
+	created.Subscribers[0].Lock.Unlock()
+	assert(t, !active, "subscriber should be inactive on matching topics")
+
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders.jp.created", Message: message.NewMessage("id", []byte("payload"))})
+	assert(t, len(broker.Topics["orders.jp.created"].Subscribers) == 0, "pattern should not be attached after unsubscribe")
+
+	err = broker.Unsubscribe("orders.*.created", "newAddress")
+	assert(t, err != nil, "unsubscribing twice from the pattern should fail")
+}
+
+func TestScheduledMessages(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	go broker.runScheduler(config.NewHolder("", cfg))
+
+	now := time.Now()
+	broker.processRequest(cfg, PublishRequest{Topic: "reminders", Message: message.NewMessage("late", []byte("late")), DeliverAt: now.Add(200 * time.Millisecond)})
+	broker.processRequest(cfg, PublishRequest{Topic: "reminders", Message: message.NewMessage("early", []byte("early")), DeliverAt: now.Add(100 * time.Millisecond)})
+	broker.processRequest(cfg, PublishRequest{Topic: "reminders", Message: message.NewMessage("cancelled", []byte("cancelled")), DeliverAt: now.Add(100 * time.Millisecond)})
+	broker.processRequest(cfg, PublishRequest{Topic: "alerts", Message: message.NewMessage("cancelled", []byte("alert")), DeliverAt: now.Add(time.Hour)})
+
+	scheduled := broker.ScheduledMessages()
+	assert(t, len(scheduled) == 4, "messages should be scheduled")
+	assert(t, scheduled[0].Id == "early" && scheduled[2].Id == "late", "scheduled messages should be listed in due time order")
+
+	broker.mu.Lock()
+	_, exists := broker.Topics["reminders"]
+	broker.mu.Unlock()
+	assert(t, !exists, "scheduled messages should not be published yet")
+
+	assert(t, !broker.CancelScheduled("unknown", "cancelled"), "cancelling should only match the message of the topic")
+	assert(t, broker.CancelScheduled("reminders", "cancelled"), "scheduled message should be cancelled")
+	assert(t, !broker.CancelScheduled("reminders", "cancelled"), "cancelled message should not be scheduled anymore")
+
+	time.Sleep(400 * time.Millisecond)
+
+	broker.mu.Lock()
+	topic, exists := broker.Topics["reminders"]
+	broker.mu.Unlock()
+	assert(t, exists, "topic should be created on release")
+	assert(t, topic.MessageQueue.Len() == 2, "due messages should be released into the topic")
+	assert(t, topic.MessageQueue.GetAt(0).Id == "early", "messages should be released in due time order")
+	scheduled = broker.ScheduledMessages()
+	assert(t, len(scheduled) == 1 && scheduled[0].Topic == "alerts", "the message with the same id on another topic should stay scheduled")
+}
+
+func TestDeadLetterExpiredMessages(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	cfg.Message.DeadLetterTopic = "dead"
+	broker.StartRequestPrecessing(config.NewHolder("", cfg))
+	defer close(broker.MessageChan)
+
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("other", []byte("payload"))})
+
+	broker.mu.Lock()
+	topic := broker.Topics["orders"]
+	broker.mu.Unlock()
+	assert(t, topic.OnExpire != nil, "topics should dead letter when configured")
+
+	msg := message.NewMessage("id", []byte("payload"))
+	msg.SetHeaders(map[string]string{"type": "created"})
+	topic.OnExpire(msg, "orders")
+
+	time.Sleep(100 * time.Millisecond)
+
+	broker.mu.Lock()
+	dead, ok := broker.Topics["dead"]
+	broker.mu.Unlock()
+	assert(t, ok, "dead letter topic should be created")
+	assert(t, dead.OnExpire == nil, "dead letter topic should not dead letter to itself")
+	assert(t, dead.MessageQueue.Len() == 1, "expired message should be dead lettered")
+
+	deadMsg := dead.MessageQueue.GetAt(0)
+	assert(t, deadMsg.Headers["x-flux-expired-topic"] == "orders", "dead lettered message should record its topic")
+	assert(t, deadMsg.Headers["type"] == "created", "dead lettered message should keep its headers")
+
+	stats := broker.Stats()
+	assert(t, len(stats.Topics) == 2 && stats.Topics[0].Name == "dead", "stats should list the topics sorted by name")
+}
+
+func TestReplyTopicSubscriptions(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	ctx := context.Background()
+
+	aliceTopic, _ := broker.CreateReplyTopic(cfg, "", time.Minute, "alice")
+	bobTopic, _ := broker.CreateReplyTopic(cfg, "", time.Minute, "bob")
+
+	assert(t, broker.CheckReplySubscription(aliceTopic, "alice") == nil, "the owner should subscribe to its reply topic")
+	assert(t, errors.Is(broker.CheckReplySubscription(bobTopic, "alice"), ErrNotReplyTopicOwner), "alice should not subscribe to bob's reply topic")
+	assert(t, errors.Is(broker.CheckReplySubscription("_reply.*", "alice"), ErrReplyTopicPattern), "patterns should not select the reply topics")
+	assert(t, broker.CheckReplySubscription("_reply.missing", "alice") != nil, "missing reply topics should not be created by a subscribe")
+	assert(t, broker.CheckReplySubscription("orders", "alice") == nil, "other topics should not be restricted")
+
+	// a pattern matching every topic is not attached to the reply topics
+	broker.Subscribe(ctx, cfg, "#", "address", false, subscriber.Options{Owner: "alice"})
+	assert(t, !broker.Topics[bobTopic].HasSubscriber("address"), "patterns should not be attached to reply topics")
+}
+
+func TestRequestReply(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+
+	replyTopic, _ := broker.CreateReplyTopic(cfg, "", time.Minute, "alice")
+	assert(t, IsReplyTopic(replyTopic), "reply topic should use the reply prefix")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		other := message.NewMessage("other", []byte("other"))
+		other.CorrelationId = "other"
+		broker.publishMessage(cfg, PublishRequest{Topic: replyTopic, Message: other})
+
+		reply := message.NewMessage("reply", []byte("pong"))
+		reply.CorrelationId = "correlation"
+		broker.publishMessage(cfg, PublishRequest{Topic: replyTopic, Message: reply})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := broker.AwaitReply(ctx, replyTopic, "correlation", "mallory")
+	assert(t, errors.Is(err, ErrNotReplyTopicOwner), "only the owner should wait on the reply topic")
+
+	reply, err := broker.AwaitReply(ctx, replyTopic, "correlation", "alice")
+	assert(t, err == nil, "reply should be received")
+	assert(t, reply != nil && reply.Id == "reply", "reply should match the correlation id")
+
+	_, err = broker.DeleteReplyTopic(replyTopic, "mallory")
+	assert(t, errors.Is(err, ErrNotReplyTopicOwner), "only the owner should delete the reply topic")
+
+	_, err = broker.DeleteReplyTopic(replyTopic, "alice")
+	assert(t, err == nil, "reply topic should be deleted")
+
+	broker.publishMessage(cfg, PublishRequest{Topic: replyTopic, Message: message.NewMessage("late", []byte("late"))})
+	_, exists := broker.Topics[replyTopic]
+	assert(t, !exists, "late replies should not recreate the reply topic")
+
+	_, err = broker.AwaitReply(ctx, replyTopic, "correlation", "alice")
+	assert(t, err != nil, "waiting on a deleted reply topic should fail")
+}
+
+func TestNamespacedReplyTopic(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+
+	replyTopic, _ := broker.CreateReplyTopic(cfg, "payments", time.Minute, "alice")
+	namespace, name := topicPkg.SplitName(replyTopic)
+	assert(t, namespace == "payments" && strings.HasPrefix(name, constants.ReplyTopicPrefix), "reply topic should be created in the namespace")
+	assert(t, IsReplyTopic(replyTopic), "qualified reply topic should be a reply topic")
+
+	_, err := broker.DeleteReplyTopic(replyTopic, "alice")
+	assert(t, err == nil, "namespaced reply topic should be deleted by its owner")
+
+	result := broker.publishMessage(cfg, PublishRequest{Topic: replyTopic, Message: message.NewMessage("late", []byte("late"))})
+	assert(t, result.Dropped, "late replies to a deleted namespaced reply topic should be dropped")
+}
+
+func TestAwaitReplyTimeout(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	replyTopic, _ := broker.CreateReplyTopic(cfg, "", time.Minute, "alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := broker.AwaitReply(ctx, replyTopic, "correlation", "alice")
+	assert(t, err == context.DeadlineExceeded, "waiting for a reply should time out")
+}
+
+func TestCleanupTemporaryTopics(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	expired, _ := broker.CreateReplyTopic(cfg, "", time.Millisecond, "alice")
+	alive, _ := broker.CreateReplyTopic(cfg, "", time.Hour, "alice")
+
+	time.Sleep(10 * time.Millisecond)
+	broker.CleanupTemporaryTopics()
+
+	_, ok := broker.Topics[expired]
+	assert(t, !ok, "expired temporary topic should be deleted")
+
+	_, ok = broker.Topics[alive]
+	assert(t, ok, "temporary topic should be kept until it expires")
+}
+
+func TestPublishDuplicateResult(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+
+	result := make(chan PublishResult, 1)
+	broker.processRequest(cfg, PublishRequest{Topic: "testTopic", Message: message.NewMessage("id", []byte("payload")), Result: result})
+	assert(t, !(<-result).Duplicate, "first message should not be a duplicate")
+
+	broker.processRequest(cfg, PublishRequest{Topic: "testTopic", Message: message.NewMessage("id", []byte("payload")), Result: result})
+	assert(t, (<-result).Duplicate, "second message with the same id should be a duplicate")
+	assert(t, broker.Topics["testTopic"].MessageQueue.Len() == 1, "duplicate should not be enqueued")
+
+	broker.processRequest(cfg, PublishRequest{Topic: "testTopic", Message: message.NewMessage("later", []byte("payload")), DeliverAt: time.Now().Add(time.Hour), Result: result})
+	assert(t, (<-result).Scheduled, "future message should be scheduled")
+
+	broker.processRequest(cfg, PublishRequest{Topic: "testTopic", Message: message.NewMessage("later", []byte("payload")), DeliverAt: time.Now().Add(time.Hour), Result: result})
+	assert(t, (<-result).Duplicate, "retried scheduled message should be a duplicate")
+
+	broker.processRequest(cfg, PublishRequest{Topic: "testTopic", Message: message.NewMessage("id", []byte("payload")), DeliverAt: time.Now().Add(time.Hour), Result: result})
+	assert(t, (<-result).Duplicate, "scheduled message with a published id should be a duplicate")
+
+	broker.processRequest(cfg, PublishRequest{Topic: "otherTopic", Message: message.NewMessage("later", []byte("payload")), DeliverAt: time.Now().Add(time.Hour), Result: result})
+	assert(t, (<-result).Scheduled, "the same id should be scheduled to another topic")
+	assert(t, len(broker.ScheduledMessages()) == 2, "duplicates should not be scheduled")
+}
+
+func TestDedupWindowFromConfig(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	cfg.Message.DedupSize = 1
+
+	broker.publishMessage(cfg, PublishRequest{Topic: "testTopic", Message: message.NewMessage("1", []byte("payload"))})
+	broker.publishMessage(cfg, PublishRequest{Topic: "testTopic", Message: message.NewMessage("2", []byte("payload"))})
+
+	result := broker.publishMessage(cfg, PublishRequest{Topic: "testTopic", Message: message.NewMessage("1", []byte("payload"))})
+	assert(t, !result.Duplicate, "id evicted from the dedup window should be accepted again")
+}
+
+func TestReconfigure(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("1", []byte("payload"))})
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("2", []byte("payload"))})
+
+	broker.mu.Lock()
+	topic := broker.Topics["orders"]
+	broker.mu.Unlock()
+	assert(t, topic.OnExpire == nil, "topic should not dead letter before the reload")
+
+	cfg.Message.DeadLetterTopic = "dead"
+	cfg.Message.DedupSize = 1
+	broker.Reconfigure(cfg)
+
+	assert(t, topic.OnExpire != nil, "existing topic should dead letter once configured")
+	assert(t, topic.Dedup.Len() == 1, "existing dedup window should take the reloaded size")
+}
+
+func TestProducerSequence(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	result := make(chan PublishResult, 1)
+
+	publish := func(id string, sequence uint64) PublishResult {
+		broker.processRequest(cfg, PublishRequest{
+			Topic:      "testTopic",
+			Message:    message.NewMessage(id, []byte("payload")),
+			ProducerId: "producer",
+			Sequence:   sequence,
+			Result:     result,
+		})
+
+		return <-result
+	}
+	accepted := func(r PublishResult) bool {
+		return !r.Duplicate && !r.Dropped && !r.OutOfSequence && !r.Scheduled
+	}
+
+	unknown := publish("z", 5)
+	assert(t, unknown.OutOfSequence && unknown.ExpectedSequence == 1, "unknown producer should have to start from sequence 1")
+
+	assert(t, accepted(publish("a", 1)), "first sequence of a producer should be accepted")
+	assert(t, accepted(publish("b", 2)), "next sequence should be accepted")
+	assert(t, publish("b-retry", 2).Duplicate, "retried sequence should be a duplicate even with a new id")
+
+	gap := publish("d", 4)
+	assert(t, gap.OutOfSequence && gap.ExpectedSequence == 3, "sequence gap should be rejected with the expected sequence")
+	assert(t, broker.Topics["testTopic"].MessageQueue.Len() == 2, "only in order messages should be published")
+
+	broker.CleanupProducers(0)
+	forgotten := publish("e", 3)
+	assert(t, forgotten.OutOfSequence && forgotten.ExpectedSequence == 1, "forgotten producer should have to start a new session")
+	assert(t, accepted(publish("e", 1)), "forgotten producer should start a new sequence from 1")
+}
+
+func TestTransactionCommit(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	broker.StartRequestPrecessing(config.NewHolder("", cfg))
+	defer close(broker.MessageChan)
+
+	id := broker.BeginTransaction(time.Minute)
+	for _, topic := range []string{"orders", "payments"} {
+		err := broker.AddToTransaction(id, PublishRequest{Topic: topic, Message: message.NewMessage(topic, []byte("payload"))})
+		assert(t, err == nil, "messages should be added to an open transaction")
+	}
+
+	broker.mu.Lock()
+	assert(t, len(broker.Topics) == 0, "transaction messages should not be published before commit")
+	broker.mu.Unlock()
+
+	result, err := broker.CommitTransaction(id)
+	assert(t, err == nil, "open transaction should commit")
+	assert(t, len(result.BatchResults) == 2, "commit should report every message")
+
+	broker.mu.Lock()
+	assert(t, broker.Topics["orders"].MessageQueue.Len() == 1, "committed message should be published to orders")
+	assert(t, broker.Topics["payments"].MessageQueue.Len() == 1, "committed message should be published to payments")
+	broker.mu.Unlock()
+
+	_, err = broker.CommitTransaction(id)
+	assert(t, errors.Is(err, ErrNoTransaction), "committed transaction should be closed")
+}
+
+func TestTransactionRejectedAsAWhole(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	broker.StartRequestPrecessing(config.NewHolder("", cfg))
+	defer close(broker.MessageChan)
+
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("published", []byte("payload"))})
+
+	for _, ids := range [][]string{{"new", "published"}, {"new", "new"}} {
+		id := broker.BeginTransaction(time.Minute)
+		_ = broker.AddToTransaction(id, PublishRequest{Topic: "payments", Message: message.NewMessage("payment", []byte("payload"))})
+		for _, msgId := range ids {
+			_ = broker.AddToTransaction(id, PublishRequest{Topic: "orders", Message: message.NewMessage(msgId, []byte("payload"))})
+		}
+
+		result, err := broker.CommitTransaction(id)
+		assert(t, errors.Is(err, ErrTransactionRejected), "transaction with a duplicate should be rejected")
+		assert(t, result.Rejected && result.BatchResults[2].Duplicate, "the duplicate should be reported")
+
+		broker.mu.Lock()
+		_, exists := broker.Topics["payments"]
+		assert(t, !exists, "no message of a rejected transaction should be published")
+		assert(t, broker.Topics["orders"].MessageQueue.Len() == 1, "no message of a rejected transaction should be published")
+		broker.mu.Unlock()
+	}
+
+	id := broker.BeginTransaction(time.Minute)
+	err := broker.AddToTransaction(id, PublishRequest{Topic: "orders", Message: message.NewMessage("later", []byte("payload")), DeliverAt: time.Now().Add(time.Hour)})
+	assert(t, errors.Is(err, ErrScheduledInTransaction), "scheduled messages should not be added to a transaction")
+}
+
+func TestTransactionAbortAndTimeout(t *testing.T) {
+	broker, _ := setupBrokerAndConfig()
+
+	id := broker.BeginTransaction(time.Minute)
+	_ = broker.AddToTransaction(id, PublishRequest{Topic: "orders", Message: message.NewMessage("id", []byte("payload"))})
+	assert(t, broker.AbortTransaction(id) == nil, "open transaction should abort")
+	assert(t, errors.Is(broker.AddToTransaction(id, PublishRequest{}), ErrNoTransaction), "aborted transaction should be closed")
+
+	id = broker.BeginTransaction(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	_, err := broker.CommitTransaction(id)
+	assert(t, errors.Is(err, ErrNoTransaction), "timed out transaction should be aborted")
+
+	broker.mu.Lock()
+	assert(t, len(broker.Topics) == 0, "aborted transactions should not publish")
+	broker.mu.Unlock()
+}
+
+func TestSubscriptionQuota(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	cfg.Limits.MaxSubscriptions = 2
+
+	err := broker.ReserveSubscriptions(cfg, "tenant", []string{"a", "b"}, "http://sub")
+	assert(t, err == nil, "subscriptions within the quota should be reserved")
+	for _, topic := range []string{"a", "b"} {
+		broker.Subscribe(context.Background(), cfg, topic, "http://sub", false, subscriber.Options{})
+	}
+
+	err = broker.ReserveSubscriptions(cfg, "tenant", []string{"a"}, "http://sub")
+	assert(t, err == nil, "existing subscriptions should not count twice")
+
+	err = broker.ReserveSubscriptions(cfg, "tenant", []string{"c"}, "http://sub")
+	assert(t, errors.Is(err, ErrQuotaExceeded), "subscription over the quota should be rejected")
+
+	err = broker.ReserveSubscriptions(cfg, "other", []string{"c"}, "http://sub")
+	assert(t, err == nil, "quotas should be per tenant")
+
+	broker.ReleaseSubscription("tenant", "a", "http://sub")
+	err = broker.ReserveSubscriptions(cfg, "tenant", []string{"c"}, "http://sub")
+	assert(t, err == nil, "released subscriptions should free the quota")
+
+	// c was never subscribed on the broker so it is pruned
+	broker.pruneSubscriptions()
+	assert(t, len(broker.quotas.subscriptions["tenant"]) == 1, "subscriptions without subscriber should be pruned")
+	assert(t, len(broker.quotas.subscriptions["other"]) == 0, "tenants without subscriptions should be pruned")
+}
+
+func TestStorageQuota(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	cfg.Limits.MaxStorageBytes = 15
+
+	msg := message.NewMessage("id", []byte("0123456789"))
+	msg.Owner = "tenant"
+	broker.publishMessage(cfg, PublishRequest{Topic: "topic", Message: msg})
+
+	assert(t, broker.CheckStorageQuota(cfg, "tenant", "topic", 5) == nil, "publish within the storage quota should be allowed")
+	assert(t, errors.Is(broker.CheckStorageQuota(cfg, "tenant", "topic", 6), ErrQuotaExceeded), "publish over the storage quota should be rejected")
+	assert(t, broker.CheckStorageQuota(cfg, "other", "topic", 15) == nil, "storage should be counted per tenant")
+
+	broker.mu.Lock()
+	broker.Topics["topic"].MessageQueue.DeleteAtIndex(0)
+	broker.mu.Unlock()
+	broker.recomputeStorage()
+	assert(t, broker.CheckStorageQuota(cfg, "tenant", "topic", 15) == nil, "deleted messages should free the storage quota")
+
+	stats := broker.Stats()
+	assert(t, len(stats.Tenants) == 0, "tenants without usage should not be reported")
+}
+
+func TestNamespaceQuotas(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	cfg.Namespaces = []config.Namespace{{Name: "team", MaxSubscriptions: 1, MaxStorageBytes: 10}}
+
+	err := broker.ReserveSubscriptions(cfg, "alice", []string{"team/a"}, "http://alice")
+	assert(t, err == nil, "subscription within the namespace quota should be reserved")
+
+	err = broker.ReserveSubscriptions(cfg, "bob", []string{"team/b"}, "http://bob")
+	assert(t, errors.Is(err, ErrQuotaExceeded), "namespace quota should be shared by its tenants")
+
+	err = broker.ReserveSubscriptions(cfg, "bob", []string{"b"}, "http://bob")
+	assert(t, err == nil, "other namespaces should not be limited")
+
+	broker.publishMessage(cfg, PublishRequest{Topic: "team/a", Message: message.NewMessage("id", []byte("0123456789"))})
+	assert(t, errors.Is(broker.CheckStorageQuota(cfg, "bob", "team/a", 1), ErrQuotaExceeded), "publish over the namespace storage should be rejected")
+	assert(t, broker.CheckStorageQuota(cfg, "bob", "a", 1) == nil, "storage should be counted per namespace")
+
+	stats := broker.Stats()
+	assert(t, len(stats.Namespaces) == 1, "only namespaces with topics or usage should be reported")
+	assert(t, stats.Namespaces[0].Name == "team" && stats.Namespaces[0].StorageBytes == 10, "namespace storage should be reported")
+	assert(t, stats.Namespaces[0].Subscriptions == 1, "namespace subscriptions should be reported")
+}
+
+func TestAuditTopics(t *testing.T) {
+	broker, cfg := setupBrokerAndConfig()
+	auditLog, err := audit.New(config.Audit{File: filepath.Join(t.TempDir(), "audit.log")})
+	assert(t, err == nil, "audit log should be opened")
+	defer auditLog.Close()
+	broker.Audit = auditLog
+
+	msg := message.NewMessage("id", []byte("payload"))
+	msg.Owner = "alice"
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders", Message: msg})
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("other", []byte("payload"))})
+
+	broker.Subscribe(context.Background(), cfg, "payments", "http://sub", false, subscriber.Options{Owner: "bob"})
+	assert(t, len(broker.Subscriptions("http://sub")) == 1, "subscriptions of the address should be listed")
+
+	replyTopic, _ := broker.CreateReplyTopic(cfg, "", time.Millisecond, "alice")
+	time.Sleep(5 * time.Millisecond)
+	broker.CleanupTemporaryTopics()
+
+	created, _ := auditLog.Query(audit.Query{Action: audit.TopicCreate})
+	assert(t, len(created) == 2, "topics should be audited once when created")
+	assert(t, created[0].Target == "orders" && created[0].Principal == "alice", "topic creation should record the publisher")
+	assert(t, created[1].Target == "payments" && created[1].Principal == "bob", "topic creation should record the subscriber")
+
+	deleted, _ := auditLog.Query(audit.Query{Action: audit.TopicDelete})
+	assert(t, len(deleted) == 1 && deleted[0].Target == replyTopic, "expired temporary topics should be audited")
+	assert(t, deleted[0].Principal == audit.SystemPrincipal, "cleanup should be audited as the broker")
+}
+
+func waitUntil(condition func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
+
+func TestReplicationLog(t *testing.T) {
+	leader, cfg := setupBrokerAndConfig()
+	cfg.Replication = config.Replication{Role: "leader", Sync: true, SyncTimeout: config.Seconds(1), LogSize: 10}
+	leader.StartReplication(config.NewHolder("", cfg), request.Credentials{})
+
+	leader.Subscribe(context.Background(), cfg, "orders", "http://localhost:1", false, subscriber.Options{})
+	result := leader.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("a", []byte("a"))})
+	assert(t, result.Offset == 2, "publishes should be recorded after the subscribe")
+
+	res, err := leader.ReplicationLog(context.Background(), "follower", 1, 0, true)
+	assert(t, err == nil && len(res.Entries) == 2 && res.Head == 2, "follower should read the entries from its offset")
+	assert(t, res.Entries[0].Op == request.ReplicateSubscribe && res.Entries[1].Message.Id == "a", "entries should be in order")
+
+	res, _ = leader.ReplicationLog(context.Background(), "follower", 3, 10*time.Millisecond, true)
+	assert(t, len(res.Entries) == 0, "caught up follower should wait and get no entries")
+	assert(t, leader.ReplicationStatus().Followers[0].InSync, "caught up follower should be in sync")
+
+	result = leader.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("b", []byte("b"))})
+	replicated := make(chan error)
+	go func() {
+		replicated <- leader.WaitReplicated(context.Background(), result.Offset)
+	}()
+
+	select {
+	case <-replicated:
+		t.Error("sync publish should wait for the in sync follower")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	leader.ReplicationLog(context.Background(), "follower", result.Offset+1, 0, true)
+	select {
+	case err := <-replicated:
+		assert(t, err == nil, "sync publish should complete once replicated")
+	case <-time.After(time.Second):
+		t.Error("sync publish should complete once replicated")
+	}
+
+	result = leader.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("c", []byte("c"))})
+	start := time.Now()
+	err = leader.WaitReplicated(context.Background(), result.Offset)
+	assert(t, err == nil && time.Since(start) >= time.Second, "sync publish should wait for the sync timeout")
+	assert(t, !leader.ReplicationStatus().Followers[0].InSync, "lagging follower should leave the in sync followers")
+
+	for i := 0; i < 20; i++ {
+		leader.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage(fmt.Sprint(i), []byte("x"))})
+	}
+	_, err = leader.ReplicationLog(context.Background(), "follower", 1, 0, true)
+	assert(t, errors.Is(err, ErrLogTrimmed), "trimmed offsets should not be readable")
+}
+
+func TestReplicationLogRedactsSecrets(t *testing.T) {
+	leader, cfg := setupBrokerAndConfig()
+	cfg.Replication = config.Replication{Role: "leader"}
+	leader.StartReplication(config.NewHolder("", cfg), request.Credentials{})
+
+	leader.Subscribe(context.Background(), cfg, "orders", "http://localhost:1", false, subscriber.Options{Secret: []byte("secret")})
+
+	res, err := leader.ReplicationLog(context.Background(), "admin", 1, 0, false)
+	assert(t, err == nil && len(res.Entries) == 1 && res.Entries[0].Subscription.Secret == nil, "secrets should be redacted")
+
+	res, err = leader.ReplicationLog(context.Background(), "follower", 1, 0, true)
+	assert(t, err == nil && string(res.Entries[0].Subscription.Secret) == "secret", "followers should get the secrets")
+}
+
+func TestFollowerResyncsFromSnapshot(t *testing.T) {
+	leader, cfg := setupBrokerAndConfig()
+	leaderCfg := cfg
+	leaderCfg.Replication = config.Replication{Role: "leader", LogSize: 5}
+	leader.StartReplication(config.NewHolder("", leaderCfg), request.Credentials{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin/snapshot" {
+			json.NewEncoder(w).Encode(leader.Snapshot(true))
+
+			return
+		}
+
+		from, _ := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+		res, err := leader.ReplicationLog(r.Context(), r.URL.Query().Get("follower"), from, 50*time.Millisecond, true)
+		if err != nil {
+			w.WriteHeader(http.StatusGone)
+
+			return
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer server.Close()
+
+	for i := 0; i < 10; i++ {
+		leader.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage(fmt.Sprint(i), []byte("x"))})
+	}
+
+	follower := NewBroker()
+	follower.Topics["stale"] = topicPkg.CreateTopic("stale", 10)
+	followerCfg := cfg
+	followerCfg.Replication = config.Replication{Role: "follower", Leader: server.URL, Id: "f1"}
+	follower.StartReplication(config.NewHolder("", followerCfg), request.Credentials{})
+
+	assert(t, waitUntil(func() bool { return follower.replication.head() == 10 }), "follower should resync from the leader's snapshot")
+
+	leader.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("after", []byte("x"))})
+	assert(t, waitUntil(func() bool { return follower.replication.head() == 11 }), "follower should replicate the changes after the snapshot")
+
+	follower.mu.Lock()
+	orders, stale := follower.Topics["orders"], follower.Topics["stale"]
+	follower.mu.Unlock()
+	assert(t, stale == nil, "state missing on the leader should be dropped")
+	assert(t, orders != nil && orders.MessageQueue.Len() == 11, "follower should hold the snapshot and the later messages")
+}
+
+func TestFollowerReplicatesAndPromotes(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]int)
+	acceptAll := false
+	sub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg request.PollMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+
+		mu.Lock()
+		defer mu.Unlock()
+		received[msg.Id]++
+		if msg.Id != "m1" && !acceptAll {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+	}))
+	defer sub.Close()
+
+	leader, cfg := setupBrokerAndConfig()
+	cfg.Subscriber = config.Subscriber{RetryCount: 1, Timeout: config.Seconds(1), InactiveTime: config.Seconds(60)}
+	leaderCfg := cfg
+	leaderCfg.Replication = config.Replication{Role: "leader"}
+	leader.StartReplication(config.NewHolder("", leaderCfg), request.Credentials{})
+
+	logServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+		res, err := leader.ReplicationLog(r.Context(), r.URL.Query().Get("follower"), from, 50*time.Millisecond, true)
+		if err != nil {
+			w.WriteHeader(http.StatusGone)
+
+			return
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer logServer.Close()
+
+	leader.Subscribe(context.Background(), cfg, "orders", sub.URL, false, subscriber.Options{})
+	leader.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("m1", []byte("1"))})
+	assert(t, waitUntil(func() bool { return leader.replication.head() == 3 }), "delivery of m1 should be recorded")
+	leader.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("m2", []byte("2")), ProducerId: "producer", Sequence: 1})
+
+	follower := NewBroker()
+	followerCfg := cfg
+	followerCfg.Replication = config.Replication{Role: "follower", Leader: logServer.URL, Id: "f1"}
+	follower.StartReplication(config.NewHolder("", followerCfg), request.Credentials{})
+
+	assert(t, waitUntil(func() bool { return follower.replication.head() == 4 }), "follower should replicate the leader's log")
+	follower.mu.Lock()
+	topic := follower.Topics["orders"]
+	follower.mu.Unlock()
+	assert(t, topic != nil && topic.MessageQueue.Len() == 2, "follower should hold the published messages")
+	assert(t, topic.Subscribers[0].MessageQueue.Len() == 1, "delivered messages should be acked on the follower")
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	assert(t, received["m1"] == 1 && received["m2"] == 1, "follower should not deliver before being promoted")
+	acceptAll = true
+	mu.Unlock()
+
+	assert(t, follower.Promote(followerCfg) == nil, "follower should be promoted")
+	assert(t, follower.Role() == "leader", "promoted follower should be the leader")
+	assert(t, errors.Is(follower.Promote(followerCfg), ErrNotFollower), "leader can't be promoted")
+
+	assert(t, waitUntil(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return received["m2"] == 2
+	}), "promoted follower should deliver the messages not acked by the leader")
+	mu.Lock()
+	assert(t, received["m1"] == 1, "promoted follower should not deliver acked messages again")
+	mu.Unlock()
+
+	_, ok := follower.checkSequence(PublishRequest{Topic: "orders", ProducerId: "producer", Sequence: 2})
+	assert(t, ok, "promoted follower should continue the producer sequences of the leader")
+	result := follower.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("m3", []byte("3"))})
+	assert(t, result.Offset > 4, "promoted follower should continue the leader's offsets")
+}
+
+func startTestCluster(t *testing.T, cfg config.Config, transport *rafttest.LocalTransport) map[string]*Broker {
+	dir := t.TempDir()
+	// the logs are compacted often so the nodes go through the snapshots
+	cfg.Cluster = config.Cluster{
+		ElectionTimeout:   config.Duration(50 * time.Millisecond),
+		HeartbeatInterval: config.Duration(10 * time.Millisecond),
+		SnapshotThreshold: 3,
+	}
+	for _, id := range []string{"n1", "n2", "n3"} {
+		cfg.Cluster.Nodes = append(cfg.Cluster.Nodes, config.ClusterNode{Id: id, Address: "http://" + id})
+	}
+
+	brokers := make(map[string]*Broker)
+	for _, n := range cfg.Cluster.Nodes {
+		nodeCfg := cfg
+		nodeCfg.Cluster.NodeId = n.Id
+		nodeCfg.Cluster.DataDir = filepath.Join(dir, n.Id)
+
+		b := NewBroker()
+		if err := b.StartCluster(config.NewHolder("", nodeCfg), transport.For(n.Id)); err != nil {
+			t.Fatal(err)
+		}
+		transport.Register(n.Id, b.cluster.node)
+		brokers[n.Id] = b
+	}
+	t.Cleanup(func() {
+		for _, b := range brokers {
+			b.StopCluster()
+		}
+	})
+
+	return brokers
+}
+
+// publishAndWait publishes through the request pipeline, a cluster answers once the message is committed.
+func publishAndWait(b *Broker, cfg config.Config, topic string, msg *message.Message) PublishResult {
+	result := make(chan PublishResult, 1)
+	b.processRequest(cfg, PublishRequest{Topic: topic, Message: msg, Result: result})
+
+	return <-result
+}
+
+func TestClusterRetriesUncommittedProducerSequence(t *testing.T) {
+	_, cfg := setupBrokerAndConfig()
+	transport := rafttest.NewLocalTransport()
+	brokers := startTestCluster(t, cfg, transport)
+
+	oldLeader, ok := clusterLeader(brokers, "")
+	if !ok {
+		t.Fatal("cluster should elect a leader")
+	}
+	leader := brokers[oldLeader]
+
+	publish := func(b *Broker, id string, sequence uint64) chan PublishResult {
+		result := make(chan PublishResult, 1)
+		b.processRequest(cfg, PublishRequest{
+			Topic:      "orders",
+			Message:    message.NewMessage(id, []byte(id)),
+			ProducerId: "producer",
+			Sequence:   sequence,
+			Result:     result,
+		})
+
+		return result
+	}
+
+	first := <-publish(leader, "a", 1)
+	assert(t, first.Err == nil && !first.Duplicate && !first.OutOfSequence, "first sequence should be committed")
+
+	// the leader is cut off before it can commit the next sequence
+	var others []string
+	for id := range brokers {
+		if id != oldLeader {
+			others = append(others, id)
+		}
+	}
+	transport.Partition([]string{oldLeader}, others)
+	uncommitted := publish(leader, "b", 2)
+
+	newId, ok := clusterLeader(brokers, oldLeader)
+	if !ok {
+		t.Fatal("the majority should elect a new leader")
+	}
+	newLeader := brokers[newId]
+
+	// the producer retries the sequence it got no answer for on the new leader
+	retry := <-publish(newLeader, "b", 2)
+	assert(t, retry.Err == nil && !retry.Duplicate && !retry.OutOfSequence, "the new leader should publish the retried sequence")
+	next := <-publish(newLeader, "c", 3)
+	assert(t, next.Err == nil && !next.OutOfSequence, "the new leader should continue the sequence")
+
+	transport.Heal()
+	assert(t, (<-uncommitted).Err != nil, "the partitioned leader should fail the publish it couldn't commit")
+	assert(t, waitUntil(func() bool {
+		leader.mu.Lock()
+		topic := leader.Topics["orders"]
+		leader.mu.Unlock()
+
+		return topic != nil && topic.MessageQueue.Len() == 3
+	}), "the old leader should hold every committed message once")
+	_, ok = leader.checkSequence(PublishRequest{Topic: "orders", ProducerId: "producer", Sequence: 4})
+	assert(t, ok, "the old leader should rebuild the producer sequence from the committed log")
+}
+
+func clusterLeader(brokers map[string]*Broker, except string) (string, bool) {
+	var leader string
+	ok := waitUntil(func() bool {
+		for id, b := range brokers {
+			if id != except && b.Role() == "leader" {
+				leader = id
+
+				return true
+			}
+		}
+
+		return false
+	})
+
+	return leader, ok
+}
+
+func TestClusterFailsOverAndRebuildsOldLeader(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]int)
+	acceptAll := false
+	sub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg request.PollMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+
+		mu.Lock()
+		defer mu.Unlock()
+		received[msg.Id]++
+		if msg.Id != "m1" && !acceptAll {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer sub.Close()
+
+	_, cfg := setupBrokerAndConfig()
+	cfg.Subscriber = config.Subscriber{RetryCount: 1, Timeout: config.Seconds(1), InactiveTime: config.Seconds(60)}
+	transport := rafttest.NewLocalTransport()
+	brokers := startTestCluster(t, cfg, transport)
+
+	oldLeader, ok := clusterLeader(brokers, "")
+	if !ok {
+		t.Fatal("cluster should elect a leader")
+	}
+	leader := brokers[oldLeader]
+	assert(t, errors.Is(leader.Promote(cfg), ErrClustered), "cluster leaders should not be promoted by hand")
+
+	assert(t, leader.Subscribe(context.Background(), cfg, "orders", sub.URL, false, subscriber.Options{}) == nil, "subscription should be committed")
+	for _, id := range []string{"m1", "m2"} {
+		result := publishAndWait(leader, cfg, "orders", message.NewMessage(id, []byte(id)))
+		assert(t, result.Err == nil && result.Offset > 0, "publishes should be answered once committed by the majority")
+	}
+	assert(t, waitUntil(func() bool {
+		for _, b := range brokers {
+			b.mu.Lock()
+			topic := b.Topics["orders"]
+			b.mu.Unlock()
+			if topic == nil || topic.MessageQueue.Len() != 2 || topic.Subscribers[0].MessageQueue.Len() != 1 {
+				return false
+			}
+		}
+
+		return true
+	}), "every node should hold the messages with the delivery of m1 acked")
+
+	for id, b := range brokers {
+		if id != oldLeader {
+			status := b.ReplicationStatus()
+			assert(t, status.Role == "follower" && status.Leader == "http://"+oldLeader, "followers should report the leader's address")
+		}
+	}
+
+	// the leader is cut off from the other nodes, they elect a new leader which delivers m2
+	var others []string
+	for id := range brokers {
+		if id != oldLeader {
+			others = append(others, id)
+		}
+	}
+	transport.Partition([]string{oldLeader}, others)
+	mu.Lock()
+	acceptAll = true
+	mu.Unlock()
+
+	newId, ok := clusterLeader(brokers, oldLeader)
+	if !ok {
+		t.Fatal("the majority should elect a new leader")
+	}
+	newLeader := brokers[newId]
+	assert(t, waitUntil(func() bool { return leader.Role() == "follower" }), "the partitioned leader should step down")
+
+	assert(t, waitUntil(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return received["m2"] >= 2
+	}), "the new leader should deliver the messages not acked on the old leader")
+	mu.Lock()
+	assert(t, received["m1"] == 1, "the new leader should not deliver acked messages again")
+	mu.Unlock()
+
+	result := publishAndWait(newLeader, cfg, "orders", message.NewMessage("m3", []byte("m3")))
+	assert(t, result.Err == nil, "the new leader should commit with the majority")
+	assert(t, newLeader.cluster.node.Status().SnapshotIndex > 0, "the new leader should compact its log")
+
+	// once healed the old leader rebuilds its state from the log of the new leader
+	transport.Heal()
+	assert(t, waitUntil(func() bool {
+		leader.mu.Lock()
+		topic := leader.Topics["orders"]
+		leader.mu.Unlock()
+
+		return topic != nil && topic.MessageQueue.Len() == 3 && topic.Standby
+	}), "the old leader should replay the committed log on standby topics")
+	assert(t, leader.ReplicationStatus().Leader == "http://"+newId, "the old leader should follow the new one")
+}
+
+func TestSubscriberStateSurvivesRestart(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	sub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg request.PollMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg.Topic+"/"+msg.Id)
+	}))
+	defer sub.Close()
+
+	broker, cfg := setupBrokerAndConfig()
+	cfg.Subscriber = config.Subscriber{RetryCount: 1, Timeout: config.Seconds(1), InactiveTime: config.Seconds(60)}
+	opts := subscriber.Options{Owner: "alice", Secret: []byte("secret")}
+	broker.Subscribe(context.Background(), cfg, "orders", sub.URL, false, opts)
+	broker.Subscribe(context.Background(), cfg, "orders", "http://localhost:1", false, opts)
+	assert(t, broker.Unsubscribe("orders", "http://localhost:1") == nil, "subscriber should be unsubscribed")
+	broker.Subscribe(context.Background(), cfg, "payments.*", sub.URL, false, opts)
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("m1", []byte("1"))})
+	assert(t, waitUntil(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(received) == 1
+	}), "m1 should be delivered")
+
+	file := filepath.Join(t.TempDir(), "state.json")
+	assert(t, waitUntil(func() bool {
+		state := broker.State()
+
+		return len(state.Subscribers) == 2 && state.Subscribers[0].LastAckedId == "m1"
+	}), "the delivery of m1 should be part of the state")
+	assert(t, broker.SaveState(file) == nil, "state should be saved")
+
+	restarted, _ := setupBrokerAndConfig()
+	assert(t, restarted.RestoreState(cfg, file) == nil, "state should be restored")
+	assert(t, restarted.Subscriptions(sub.URL)[0] == "orders", "active subscriber should be restored")
+	assert(t, len(restarted.Subscriptions("http://localhost:1")) == 0, "unsubscribed subscriber should stay inactive")
+	assert(t, len(restarted.quotas.subscriptions["alice"]) == 3, "restored subscriptions should count in the quota")
+
+	state := restarted.State()
+	assert(t, state.Subscribers[0].Delivered == 1 && string(state.Subscribers[0].Subscription.Secret) == "secret", "progress and options should be restored")
+
+	// the restored subscribers receive the new messages without subscribing again
+	restarted.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("m2", []byte("2"))})
+	restarted.publishMessage(cfg, PublishRequest{Topic: "payments.eu", Message: message.NewMessage("p1", []byte("1"))})
+	assert(t, waitUntil(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(received) == 3
+	}), "restored subscriptions should deliver the new messages")
+
+	missing, _ := setupBrokerAndConfig()
+	assert(t, missing.RestoreState(cfg, filepath.Join(t.TempDir(), "missing.json")) == nil, "missing state should restore nothing")
+
+	follower, _ := setupBrokerAndConfig()
+	follower.role = "follower"
+	assert(t, errors.Is(follower.RestoreState(cfg, file), ErrStateReplicated), "followers should not restore a state")
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]int)
+	acceptAll := false
+	sub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg request.PollMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+
+		mu.Lock()
+		defer mu.Unlock()
+		received[msg.Id]++
+		if msg.Id != "m1" && !acceptAll {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer sub.Close()
+
+	broker, cfg := setupBrokerAndConfig()
+	cfg.Subscriber = config.Subscriber{RetryCount: 1, Timeout: config.Seconds(1), InactiveTime: config.Seconds(60)}
+	opts := subscriber.Options{Owner: "alice"}
+	broker.Subscribe(context.Background(), cfg, "orders", sub.URL, false, opts)
+	broker.Subscribe(context.Background(), cfg, "payments.*", sub.URL, false, opts)
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("m1", []byte("1"))})
+	assert(t, waitUntil(func() bool { return broker.State().Subscribers[0].LastAckedId == "m1" }), "m1 should be delivered")
+	broker.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("m2", []byte("2"))})
+	assert(t, waitUntil(func() bool { return len(broker.Subscriptions(sub.URL)) == 1 }), "failed push should deactivate the subscriber")
+
+	seq := PublishRequest{Topic: "orders", ProducerId: "p", Sequence: 1}
+	broker.mu.Lock()
+	_, ok := broker.acceptSequenceLocked(seq)
+	broker.mu.Unlock()
+	assert(t, ok, "first sequence should be accepted")
+	broker.scheduler.add(PublishRequest{Topic: "orders", Message: message.NewMessage("later", []byte("3")), DeliverAt: time.Now().Add(time.Hour)})
+
+	data, err := json.Marshal(broker.Snapshot(true))
+	assert(t, err == nil, "snapshot should be encoded")
+	var snapshot request.Snapshot
+	assert(t, json.Unmarshal(data, &snapshot) == nil, "snapshot should be decoded")
+	assert(t, len(snapshot.Topics) == 1 && len(snapshot.Topics[0].Messages) == 2, "snapshot should hold the messages")
+
+	restored, _ := setupBrokerAndConfig()
+	assert(t, restored.RestoreSnapshot(cfg, snapshot, "admin") == nil, "snapshot should be restored")
+	assert(t, errors.Is(restored.RestoreSnapshot(cfg, snapshot, "admin"), ErrBrokerNotEmpty), "snapshot should not be restored twice")
+
+	assert(t, restored.publishMessage(cfg, PublishRequest{Topic: "orders", Message: message.NewMessage("m1", []byte("1"))}).Duplicate, "dedup window should be restored")
+	_, ok = restored.checkSequence(seq)
+	assert(t, !ok, "producer sequences should be restored")
+	scheduled := restored.ScheduledMessages()
+	assert(t, len(scheduled) == 1 && scheduled[0].Id == "later", "scheduled messages should be restored")
+	assert(t, len(restored.quotas.subscriptions["alice"]) == 2, "subscriptions should count in the quota")
+
+	// the reactivated subscriber receives the message it didn't acknowledge, and only that one
+	mu.Lock()
+	acceptAll = true
+	mu.Unlock()
+	restored.Subscribe(context.Background(), cfg, "orders", sub.URL, false, opts)
+	restored.publishMessage(cfg, PublishRequest{Topic: "payments.eu", Message: message.NewMessage("p1", []byte("1"))})
+	assert(t, waitUntil(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return received["m2"] == 2 && received["p1"] == 1
+	}), "restored subscriptions should deliver")
+	mu.Lock()
+	assert(t, received["m1"] == 1, "acknowledged message should not be delivered again")
+	mu.Unlock()
+
+	follower, _ := setupBrokerAndConfig()
+	follower.role = "follower"
+	assert(t, errors.Is(follower.RestoreSnapshot(cfg, snapshot, "admin"), ErrSnapshotReplicated), "replicated broker should not restore a snapshot")
+	snapshot.Version = 0
+	assert(t, restored.RestoreSnapshot(cfg, snapshot, "admin") != nil, "unknown version should be rejected")
+}
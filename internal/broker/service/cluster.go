@@ -0,0 +1,402 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/raft"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+var (
+	ErrNotClustered = errors.New("broker is not part of a cluster")
+	ErrClustered    = errors.New("the leader of a cluster is elected, it can't be promoted")
+	// ErrNotCommitted is returned when a change could not be committed by a majority of the cluster
+	ErrNotCommitted = errors.New("change was not committed by the cluster")
+)
+
+// cluster replicates the changes of the broker through a raft log, the nodes elect the leader
+// and a new one takes over when it fails. Every node, the leader included, changes its state by
+// applying the committed log, the leader proposes the changes it's asked for and waits for them.
+type cluster struct {
+	id   string
+	node *raft.Node
+	// storage is owned by the cluster, it's closed once the node is stopped
+	storage *raft.Storage
+	// addresses maps the ids of the nodes to the urls of their apis
+	addresses map[string]string
+
+	mu sync.Mutex
+	// pending receive the outcome of the changes proposed by the broker by raft index
+	pending map[uint64]chan applied
+}
+
+// applied is the outcome of a change applied from the raft log.
+type applied struct {
+	result PublishResult
+	// stats are the ones of a deleted topic
+	stats request.TopicStats
+	err   error
+}
+
+// StartCluster starts the raft node of the broker, the broker follows until the node is elected.
+func (b *Broker) StartCluster(cfg *config.Holder, transport raft.Transport) error {
+	c := cfg.Get().Cluster
+	addresses := c.Addresses()
+	if _, ok := addresses[c.NodeId]; !ok {
+		return fmt.Errorf("cluster node id %q is not one of the nodes", c.NodeId)
+	}
+	if cfg.Get().Replication.Role != "" {
+		return errors.New("cluster and replication can't be both enabled")
+	}
+
+	var peers []string
+	for _, n := range c.Nodes {
+		if n.Id != c.NodeId {
+			peers = append(peers, n.Id)
+		}
+	}
+
+	dataDir := c.DataDir
+	if dataDir == "" {
+		dataDir = "raft-" + c.NodeId
+	}
+	electionTimeout := time.Duration(c.ElectionTimeout)
+	if electionTimeout <= 0 {
+		electionTimeout = constants.DefaultElectionTimeout
+	}
+	heartbeat := time.Duration(c.HeartbeatInterval)
+	if heartbeat <= 0 {
+		heartbeat = constants.DefaultHeartbeatInterval
+	}
+	threshold := c.SnapshotThreshold
+	if threshold <= 0 {
+		threshold = constants.DefaultSnapshotThreshold
+	}
+
+	storage, err := raft.NewStorage(dataDir)
+	if err != nil {
+		return err
+	}
+
+	node, err := raft.NewNode(raft.Options{
+		Id:                c.NodeId,
+		Peers:             peers,
+		ElectionTimeout:   electionTimeout,
+		HeartbeatInterval: heartbeat,
+		Storage:           storage,
+		Transport:         transport,
+		Apply: func(e raft.Entry) {
+			b.applyCommitted(cfg.Get(), e)
+		},
+		// the snapshots of the broker hold the state of the applied log, the scheduled messages of
+		// the leader in them are not restored
+		Snapshot: func() ([]byte, error) {
+			return json.Marshal(b.Snapshot(true))
+		},
+		SnapshotThreshold: uint64(threshold),
+		Restore: func(data []byte) {
+			b.restoreCommitted(cfg.Get(), data)
+		},
+		OnRoleChange: func(role raft.Role) {
+			b.clusterRoleChanged(cfg.Get(), role)
+		},
+	})
+	if err != nil {
+		storage.Close()
+
+		return err
+	}
+
+	b.mu.Lock()
+	b.role = constants.RoleFollower
+	b.standby = true
+	b.mu.Unlock()
+
+	b.cluster = &cluster{
+		id:        c.NodeId,
+		node:      node,
+		storage:   storage,
+		addresses: addresses,
+		pending:   make(map[uint64]chan applied),
+	}
+	node.Start()
+
+	return nil
+}
+
+// StopCluster stops the raft node, the broker no longer takes part in the cluster.
+func (b *Broker) StopCluster() {
+	if b.cluster == nil {
+		return
+	}
+
+	b.cluster.node.Stop()
+	b.cluster.storage.Close()
+}
+
+// append appends the change to the raft log of the leader and returns its index.
+func (c *cluster) append(e request.ReplicationEntry) (uint64, error) {
+	command, err := json.Marshal(e)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode %s change of %s: %w", e.Op, e.Topic, err)
+	}
+
+	index, err := c.node.Propose(command)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrNotCommitted, err)
+	}
+
+	return index, nil
+}
+
+// propose appends the change to the raft log, the returned channel receives its outcome once it's applied.
+func (c *cluster) propose(e request.ReplicationEntry) (uint64, chan applied, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the change can't be applied before it's pending, applyCommitted waits for c.mu
+	index, err := c.append(e)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	done := make(chan applied, 1)
+	c.pending[index] = done
+
+	return index, done, nil
+}
+
+// wait waits until the change proposed at the index is committed and applied. It fails when the
+// change was replaced by the one of another leader or isn't committed in time.
+func (c *cluster) wait(ctx context.Context, index uint64, done chan applied) applied {
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, index)
+		c.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, constants.ClusterCommitTimeout)
+	defer cancel()
+
+	if err := c.node.WaitCommitted(ctx, index); err != nil {
+		return applied{err: fmt.Errorf("%w: %s", ErrNotCommitted, err)}
+	}
+
+	select {
+	case a := <-done:
+		return a
+	case <-ctx.Done():
+		return applied{err: fmt.Errorf("%w: %s", ErrNotCommitted, ctx.Err())}
+	}
+}
+
+// commit proposes the change and waits until it's applied.
+func (c *cluster) commit(ctx context.Context, e request.ReplicationEntry) applied {
+	index, done, err := c.propose(e)
+	if err != nil {
+		return applied{err: err}
+	}
+
+	return c.wait(ctx, index, done)
+}
+
+// resolve hands the outcome of the change applied at the index to the broker which proposed it.
+func (c *cluster) resolve(index uint64, a applied) {
+	c.mu.Lock()
+	done, ok := c.pending[index]
+	c.mu.Unlock()
+
+	if ok {
+		// a change applied again after the broker stopped leading was received already
+		select {
+		case done <- a:
+		default:
+		}
+	}
+}
+
+// batchEntry turns the batch into one change so a cluster commits all of its messages or none.
+func batchEntry(batch []PublishRequest) request.ReplicationEntry {
+	e := request.ReplicationEntry{Op: request.ReplicateBatch, Batch: make([]request.ReplicationEntry, len(batch))}
+	for i, req := range batch {
+		e.Batch[i] = publishEntry(req)
+	}
+
+	return e
+}
+
+// proposeRequest proposes the change of the publish request, the request is answered once the
+// change is applied or failed.
+func (b *Broker) proposeRequest(req PublishRequest, e request.ReplicationEntry) {
+	index, done, err := b.cluster.propose(e)
+	if err != nil {
+		req.respond(PublishResult{Err: err})
+
+		return
+	}
+
+	go func() {
+		a := b.cluster.wait(context.Background(), index, done)
+		if a.err != nil {
+			a.result.Err = a.err
+		}
+		req.respond(a.result)
+	}()
+}
+
+// leaderAddress returns the api url of the elected leader, empty while there is none or the
+// broker itself is still taking over.
+func (c *cluster) leaderAddress() string {
+	leader := c.node.Leader()
+	if leader == c.id {
+		return ""
+	}
+
+	return strings.TrimSuffix(c.addresses[leader], "/")
+}
+
+// recordChange replicates the change of the broker and returns its offset, 0 when it's not replicated.
+// The changes of a cluster are proposed before they are made, they are not recorded.
+func (b *Broker) recordChange(e request.ReplicationEntry) uint64 {
+	if b.cluster != nil {
+		return 0
+	}
+
+	return b.replication.record(e)
+}
+
+// applyCommitted applies a committed change, the offset of a change is its raft index.
+func (b *Broker) applyCommitted(cfg config.Config, e raft.Entry) {
+	var change request.ReplicationEntry
+	if err := json.Unmarshal(e.Command, &change); err != nil {
+		log.Printf("invalid change at raft index %d [ERROR]: %s", e.Index, err)
+
+		return
+	}
+	change.Offset = e.Index
+
+	a := b.apply(cfg, change)
+	if r := a.result; !r.Duplicate && !r.Dropped && !r.Rejected {
+		a.result.Offset = e.Index
+	}
+	b.cluster.resolve(e.Index, a)
+}
+
+// restoreCommitted replaces the state of the broker with a snapshot of the raft log.
+func (b *Broker) restoreCommitted(cfg config.Config, data []byte) {
+	var snapshot request.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		// the changes after the snapshot can't be applied without it
+		log.Fatalf("invalid snapshot of the raft log [ERROR]: %s", err)
+	}
+
+	b.replaceState(cfg, snapshot)
+
+	log.Printf("Restored %d topics from the snapshot of the raft log \n", len(snapshot.Topics))
+}
+
+// clusterRoleChanged resumes the delivery once the broker leads. A broker which stops leading drops
+// its state, which may hold uncommitted changes, and rebuilds it from the committed log.
+func (b *Broker) clusterRoleChanged(cfg config.Config, role raft.Role) {
+	if role == raft.Leader {
+		b.mu.Lock()
+		b.role = constants.RoleLeader
+		b.standby = false
+		topics := make([]*topicPkg.Topic, 0, len(b.Topics))
+		for _, topic := range b.Topics {
+			topics = append(topics, topic)
+		}
+		b.mu.Unlock()
+
+		for _, topic := range topics {
+			topic.Resume(cfg)
+		}
+
+		log.Printf("Broker %s is leading the cluster \n", b.cluster.id)
+
+		return
+	}
+
+	b.mu.Lock()
+	b.role = constants.RoleFollower
+	b.standby = true
+	b.resetLocked()
+	b.mu.Unlock()
+
+	if dropped := b.scheduler.clear(); dropped > 0 {
+		log.Printf("Dropped %d scheduled messages which are not replicated \n", dropped)
+	}
+
+	log.Printf("Broker %s stopped leading the cluster, rebuilding its state from the log \n", b.cluster.id)
+}
+
+// ClusterStatus returns the raft state of the broker.
+func (b *Broker) ClusterStatus() (raft.Status, error) {
+	if b.cluster == nil {
+		return raft.Status{}, ErrNotClustered
+	}
+
+	return b.cluster.node.Status(), nil
+}
+
+// RaftVote handles the vote request of a candidate.
+func (b *Broker) RaftVote(req raft.VoteRequest) (raft.VoteResponse, error) {
+	if b.cluster == nil {
+		return raft.VoteResponse{}, ErrNotClustered
+	}
+
+	return b.cluster.node.HandleRequestVote(req), nil
+}
+
+// RaftAppend handles the entries, or heartbeat, of the leader.
+func (b *Broker) RaftAppend(req raft.AppendRequest) (raft.AppendResponse, error) {
+	if b.cluster == nil {
+		return raft.AppendResponse{}, ErrNotClustered
+	}
+
+	return b.cluster.node.HandleAppendEntries(req), nil
+}
+
+// RaftInstallSnapshot handles the snapshot of the leader.
+func (b *Broker) RaftInstallSnapshot(req raft.SnapshotRequest) (raft.SnapshotResponse, error) {
+	if b.cluster == nil {
+		return raft.SnapshotResponse{}, ErrNotClustered
+	}
+
+	return b.cluster.node.HandleInstallSnapshot(req), nil
+}
+
+// replicationStatus reports the other nodes of a leader as its followers, their offset is the
+// last index they stored.
+func (c *cluster) replicationStatus(role string) request.ReplicationStatus {
+	raftStatus := c.node.Status()
+	status := request.ReplicationStatus{
+		Role:   role,
+		Head:   raftStatus.CommitIndex,
+		Leader: c.leaderAddress(),
+	}
+
+	if role == constants.RoleLeader {
+		status.Followers = make([]request.FollowerStatus, 0, len(raftStatus.Peers))
+		for _, p := range raftStatus.Peers {
+			status.Followers = append(status.Followers, request.FollowerStatus{
+				Id:       p.Id,
+				Offset:   p.MatchIndex,
+				InSync:   p.MatchIndex >= raftStatus.CommitIndex,
+				LastSeen: p.LastContact,
+			})
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/audit"
+	"github.com/NamanBalaji/flux/pkg/broker/subscriber"
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/filter"
+	"github.com/NamanBalaji/flux/pkg/message"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+// StartReplication makes a leader record its changes for the followers, and a follower replicate
+// the changes of the leader until it is promoted.
+func (b *Broker) StartReplication(cfg *config.Holder, credentials request.Credentials) {
+	replication := cfg.Get().Replication
+	role := replication.Role
+	if role == "" {
+		return
+	}
+
+	size := replication.LogSize
+	if size <= 0 {
+		size = constants.DefaultReplicationLogSize
+	}
+	syncTimeout := time.Duration(replication.SyncTimeout)
+	if syncTimeout <= 0 {
+		syncTimeout = constants.DefaultSyncTimeout
+	}
+
+	b.replication.mu.Lock()
+	b.replication.enabled = true
+	b.replication.following = role == constants.RoleFollower
+	b.replication.size = size
+	b.replication.sync = replication.Sync
+	b.replication.syncTimeout = syncTimeout
+	b.replication.mu.Unlock()
+
+	b.mu.Lock()
+	b.role = role
+	if role == constants.RoleFollower {
+		b.leader = strings.TrimSuffix(replication.Leader, "/")
+		b.standby = true
+
+		ctx, cancel := context.WithCancel(context.Background())
+		b.stopFollowing = cancel
+		b.followDone = make(chan struct{})
+		go b.follow(ctx, cfg, credentials, b.followDone)
+	}
+	b.mu.Unlock()
+
+	log.Printf("Started replication as %s \n", role)
+}
+
+// follow fetches and applies the changes of the leader until the context is done.
+func (b *Broker) follow(ctx context.Context, cfg *config.Holder, credentials request.Credentials, done chan struct{}) {
+	defer close(done)
+
+	id := cfg.Get().Replication.Id
+	if id == "" {
+		id = fmt.Sprintf("follower-%d", cfg.Get().Api.Port)
+	}
+
+	for ctx.Err() == nil {
+		err := b.fetchAndApply(ctx, cfg.Get(), credentials, id)
+		if errors.Is(err, ErrLogTrimmed) {
+			log.Printf("The leader %s trimmed the changes the follower is missing, resyncing from a snapshot \n", b.leader)
+			err = b.resync(ctx, cfg.Get(), credentials)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("failed to replicate from the leader %s [ERROR]: %s", b.leader, err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(constants.ReplicationRetryInterval):
+			}
+		}
+	}
+
+	log.Printf("Stopped replicating from the leader %s \n", b.leader)
+}
+
+func (b *Broker) fetchAndApply(ctx context.Context, cfg config.Config, credentials request.Credentials, id string) error {
+	from := b.replication.head() + 1
+	logUrl := fmt.Sprintf("%s/admin/replication/log?follower=%s&from=%d", b.leader, url.QueryEscape(id), from)
+
+	body, status, err := request.SendAuthenticatedRequest(ctx, http.MethodGet, logUrl, nil, credentials)
+	if err != nil {
+		return err
+	}
+	if closer, ok := body.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	if status == http.StatusGone {
+		return fmt.Errorf("%w: offset %d", ErrLogTrimmed, from)
+	}
+	if status != http.StatusOK {
+		message, _ := io.ReadAll(body)
+
+		return fmt.Errorf("leader responded with %d: %s", status, message)
+	}
+
+	var res request.ReplicationLogResponse
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		return fmt.Errorf("invalid replication log: %w", err)
+	}
+
+	for _, e := range res.Entries {
+		if expected := b.replication.head() + 1; e.Offset != expected {
+			return fmt.Errorf("expected offset %d from the leader, got %d", expected, e.Offset)
+		}
+
+		b.apply(cfg, e)
+		b.replication.mirror(e)
+	}
+
+	return nil
+}
+
+// resync replaces the state of the follower with a snapshot of the leader and replicates the changes
+// made after it. Scheduled messages are not replicated so they are not loaded.
+func (b *Broker) resync(ctx context.Context, cfg config.Config, credentials request.Credentials) error {
+	body, status, err := request.SendAuthenticatedRequest(ctx, http.MethodGet, b.leader+"/admin/snapshot", nil, credentials)
+	if err != nil {
+		return err
+	}
+	if closer, ok := body.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	if status != http.StatusOK {
+		message, _ := io.ReadAll(body)
+
+		return fmt.Errorf("leader responded to the snapshot request with %d: %s", status, message)
+	}
+
+	var snapshot request.Snapshot
+	if err := json.NewDecoder(body).Decode(&snapshot); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if snapshot.Version != constants.SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected %d", snapshot.Version, constants.SnapshotVersion)
+	}
+
+	b.replaceState(cfg, snapshot)
+	b.replication.reset(snapshot.Offset)
+
+	log.Printf("Resynced %d topics from the leader's snapshot at offset %d \n", len(snapshot.Topics), snapshot.Offset)
+
+	return nil
+}
+
+// replaceState replaces the topics, wildcard subscriptions and producer sequences of the broker with
+// the ones of the snapshot.
+func (b *Broker) replaceState(cfg config.Config, snapshot request.Snapshot) {
+	b.processing.Lock()
+	b.mu.Lock()
+	b.resetLocked()
+	b.loadSnapshotLocked(cfg, snapshot, audit.SystemPrincipal)
+	b.mu.Unlock()
+	b.processing.Unlock()
+
+	b.recomputeStorage()
+}
+
+// resetLocked drops the topics and wildcard subscriptions of the broker, the caller must hold b.mu.
+func (b *Broker) resetLocked() {
+	for _, topic := range b.Topics {
+		topic.Close()
+	}
+	b.Topics = topicPkg.CreateTopics()
+	b.patterns = nil
+	b.producers = make(map[string]map[string]*producerState)
+	b.quotas.storage = make(map[string]int64)
+	b.quotas.namespaceStorage = make(map[string]int64)
+}
+
+// apply replays a change of the leader, the topics of a follower are on standby so nothing is
+// delivered. The leader of a cluster makes its own changes through it too.
+func (b *Broker) apply(cfg config.Config, e request.ReplicationEntry) applied {
+	switch e.Op {
+	case request.ReplicatePublish:
+		if e.Message != nil {
+			return applied{result: b.publishMessage(cfg, PublishRequest{
+				Topic:      e.Topic,
+				Message:    message.FromReplicated(e.Message),
+				ProducerId: e.ProducerId,
+				Sequence:   e.Sequence,
+			})}
+		}
+	case request.ReplicateBatch:
+		batch := make([]PublishRequest, 0, len(e.Batch))
+		for _, p := range e.Batch {
+			if p.Message != nil {
+				batch = append(batch, PublishRequest{Topic: p.Topic, Message: message.FromReplicated(p.Message)})
+			}
+		}
+
+		return applied{result: b.publishBatch(cfg, batch)}
+	case request.ReplicateAck:
+		b.mu.Lock()
+		topic, ok := b.Topics[e.Topic]
+		b.mu.Unlock()
+
+		if ok {
+			topic.AckDelivered(e.Address, e.MessageId)
+		}
+	case request.ReplicateSubscribe:
+		var opts subscriber.Options
+		readOld := false
+		if s := e.Subscription; s != nil {
+			f, err := filter.Parse(s.Filter)
+			if err != nil {
+				log.Printf("invalid replicated filter of subscriber[Address: %s] [ERROR]: %s", e.Address, err)
+			}
+			opts = subscriber.Options{Raw: s.Raw, Filter: f, Secret: s.Secret, Owner: s.Owner}
+			readOld = s.ReadOld
+		}
+
+		b.subscribe(context.Background(), cfg, e.Topic, e.Address, readOld, opts)
+	case request.ReplicateUnsubscribe:
+		if err := b.unsubscribe(e.Topic, e.Address); err != nil {
+			log.Printf("failed to replicate unsubscribe of subscriber[Address: %s] from %s: %s", e.Address, e.Topic, err)
+
+			return applied{err: err}
+		}
+	case request.ReplicateCreateReplyTopic:
+		if e.ExpiresAt != nil {
+			b.createReplyTopic(cfg, e.Topic, *e.ExpiresAt, e.Owner)
+		}
+	case request.ReplicateDeleteTopic:
+		stats, err := b.deleteTopic(e.Topic)
+
+		return applied{stats: stats, err: err}
+	default:
+		log.Printf("unknown replication op %s at offset %d", e.Op, e.Offset)
+	}
+
+	return applied{}
+}
+
+// Promote turns the follower into the leader: it stops replicating, starts delivering to the
+// subscribers and records its own changes, continuing the offsets of the old leader.
+func (b *Broker) Promote(cfg config.Config) error {
+	if b.cluster != nil {
+		return ErrClustered
+	}
+
+	b.mu.Lock()
+	if b.role != constants.RoleFollower {
+		b.mu.Unlock()
+
+		return ErrNotFollower
+	}
+	stop, done := b.stopFollowing, b.followDone
+	b.mu.Unlock()
+
+	stop()
+	<-done
+
+	b.mu.Lock()
+	b.role = constants.RoleLeader
+	b.leader = ""
+	b.standby = false
+	topics := make([]*topicPkg.Topic, 0, len(b.Topics))
+	for _, topic := range b.Topics {
+		topics = append(topics, topic)
+	}
+	b.mu.Unlock()
+
+	b.replication.mu.Lock()
+	b.replication.following = false
+	b.replication.mu.Unlock()
+
+	for _, topic := range topics {
+		topic.Resume(cfg)
+	}
+
+	log.Printf("Promoted to leader at offset %d \n", b.replication.head())
+
+	return nil
+}
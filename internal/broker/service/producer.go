@@ -0,0 +1,95 @@
+package service
+
+import (
+	"log"
+	"time"
+)
+
+// producerState is the last sequence number accepted from a producer on a topic.
+type producerState struct {
+	sequence uint64
+	lastSeen time.Time
+}
+
+// checkSequence validates the producer sequence of the request before it is published, an unknown
+// producer, new or forgotten after idling, has to start a new session from sequence 1 so a late retry
+// of a forgotten sequence isn't published again. The sequence is only accepted once the message is
+// applied, see acceptSequenceLocked. It returns a non empty result when the request must not be published.
+func (b *Broker) checkSequence(req PublishRequest) (PublishResult, bool) {
+	if req.ProducerId == "" {
+		return PublishResult{}, true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.producers[req.Topic][req.ProducerId]
+	if !ok {
+		if req.Sequence != 1 {
+			log.Printf("Unknown producer %s sent sequence %d to topic %s, expected 1 \n", req.ProducerId, req.Sequence, req.Topic)
+
+			return PublishResult{OutOfSequence: true, ExpectedSequence: 1}, false
+		}
+
+		return PublishResult{}, true
+	}
+
+	state.lastSeen = time.Now()
+
+	switch {
+	case req.Sequence <= state.sequence:
+		log.Printf("Producer %s sent duplicate sequence %d to topic %s \n", req.ProducerId, req.Sequence, req.Topic)
+
+		return PublishResult{Duplicate: true}, false
+	case req.Sequence > state.sequence+1:
+		log.Printf("Producer %s sent sequence %d to topic %s, expected %d \n", req.ProducerId, req.Sequence, req.Topic, state.sequence+1)
+
+		return PublishResult{OutOfSequence: true, ExpectedSequence: state.sequence + 1}, false
+	}
+
+	return PublishResult{}, true
+}
+
+// acceptSequenceLocked records the producer sequence of the request as its message is applied, on the
+// leader and on the brokers replicating it. checkSequence validated it already, only a sequence applied
+// meanwhile, e.g. proposed again by a retry, is reported as a duplicate. The caller must hold b.mu.
+func (b *Broker) acceptSequenceLocked(req PublishRequest) (PublishResult, bool) {
+	if req.ProducerId == "" {
+		return PublishResult{}, true
+	}
+
+	producers, ok := b.producers[req.Topic]
+	if !ok {
+		producers = make(map[string]*producerState)
+		b.producers[req.Topic] = producers
+	}
+
+	if state, ok := producers[req.ProducerId]; ok && req.Sequence <= state.sequence {
+		log.Printf("Producer %s sequence %d to topic %s was already applied \n", req.ProducerId, req.Sequence, req.Topic)
+
+		return PublishResult{Duplicate: true}, false
+	}
+	producers[req.ProducerId] = &producerState{sequence: req.Sequence, lastSeen: time.Now()}
+
+	return PublishResult{}, true
+}
+
+// CleanupProducers forgets the producers which have not published for longer than maxIdle.
+func (b *Broker) CleanupProducers(maxIdle time.Duration) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for topic, producers := range b.producers {
+		for id, state := range producers {
+			if now.Sub(state.lastSeen) >= maxIdle {
+				delete(producers, id)
+			}
+		}
+
+		if len(producers) == 0 {
+			delete(b.producers, topic)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// quotas tracks the resources used by each tenant, the tenant being the authenticated principal,
+// and by each namespace.
+type quotas struct {
+	// subscriptions holds the qualified topic and address of every subscription registered by a tenant
+	subscriptions map[string]map[subscriptionKey]bool
+	// storage is the payload size of the messages stored per tenant
+	storage map[string]int64
+	// namespaceStorage is the payload size of the messages stored per namespace
+	namespaceStorage map[string]int64
+}
+
+type subscriptionKey struct {
+	topic   string
+	address string
+}
+
+// ReserveSubscriptions records the tenant's subscriptions of the address to the qualified topics,
+// it fails without recording any of them if the tenant or a namespace would exceed its quota.
+func (b *Broker) ReserveSubscriptions(cfg config.Config, tenant string, topics []string, address string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs := b.quotas.subscriptions[tenant]
+	added := 0
+	addedPerNamespace := make(map[string]int)
+	for _, topic := range topics {
+		if !subs[subscriptionKey{topic, address}] {
+			namespace, _ := topicPkg.SplitName(topic)
+			added++
+			addedPerNamespace[namespace]++
+		}
+	}
+
+	if max := cfg.Limits.MaxSubscriptions; max > 0 && len(subs)+added > max {
+		return fmt.Errorf("%w: tenant %s is limited to %d subscriptions", ErrQuotaExceeded, tenant, max)
+	}
+
+	for namespace, n := range addedPerNamespace {
+		max := cfg.Namespace(namespace).MaxSubscriptions
+		if max > 0 && b.namespaceSubscriptions(namespace)+n > max {
+			return fmt.Errorf("%w: namespace %s is limited to %d subscriptions", ErrQuotaExceeded, namespace, max)
+		}
+	}
+
+	if subs == nil {
+		subs = make(map[subscriptionKey]bool)
+		b.quotas.subscriptions[tenant] = subs
+	}
+	for _, topic := range topics {
+		subs[subscriptionKey{topic, address}] = true
+	}
+
+	return nil
+}
+
+// ReleaseSubscription forgets the subscription of the address to the qualified topic.
+func (b *Broker) ReleaseSubscription(tenant string, topic string, address string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.quotas.subscriptions[tenant], subscriptionKey{topic, address})
+}
+
+// CheckStorageQuota fails if storing size more bytes to the qualified topic would exceed the
+// tenant's or the topic namespace's max storage.
+func (b *Broker) CheckStorageQuota(cfg config.Config, tenant string, topic string, size int) error {
+	namespace, _ := topicPkg.SplitName(topic)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if max := cfg.Limits.MaxStorageBytes; max > 0 && b.quotas.storage[tenant]+int64(size) > max {
+		return fmt.Errorf("%w: tenant %s is limited to %d stored bytes", ErrQuotaExceeded, tenant, max)
+	}
+
+	if max := cfg.Namespace(namespace).MaxStorageBytes; max > 0 && b.quotas.namespaceStorage[namespace]+int64(size) > max {
+		return fmt.Errorf("%w: namespace %s is limited to %d stored bytes", ErrQuotaExceeded, namespace, max)
+	}
+
+	return nil
+}
+
+// namespaceSubscriptions counts the subscriptions to the topics of the namespace, the caller must hold b.mu.
+func (b *Broker) namespaceSubscriptions(namespace string) int {
+	count := 0
+	for _, subs := range b.quotas.subscriptions {
+		for key := range subs {
+			if ns, _ := topicPkg.SplitName(key.topic); ns == namespace {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+// pruneSubscriptions forgets the subscriptions whose subscriber has been cleaned up.
+func (b *Broker) pruneSubscriptions() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for tenant, subs := range b.quotas.subscriptions {
+		for key := range subs {
+			if !b.hasSubscription(key) {
+				delete(subs, key)
+			}
+		}
+
+		if len(subs) == 0 {
+			delete(b.quotas.subscriptions, tenant)
+		}
+	}
+}
+
+// hasSubscription reports whether the subscription still exists, the caller must hold b.mu.
+func (b *Broker) hasSubscription(key subscriptionKey) bool {
+	if topicPkg.IsPattern(key.topic) {
+		for _, p := range b.patterns {
+			if p.pattern == key.topic && p.address == key.address {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	topic, ok := b.Topics[key.topic]
+
+	return ok && topic.HasSubscriber(key.address)
+}
+
+// recomputeStorage counts the payload size of the messages still stored per tenant and namespace.
+func (b *Broker) recomputeStorage() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	storage := make(map[string]int64)
+	namespaceStorage := make(map[string]int64)
+	for name, topic := range b.Topics {
+		namespace, _ := topicPkg.SplitName(name)
+		topicStorage := make(map[string]int64)
+		topic.StoredBytes(topicStorage)
+
+		for tenant, size := range topicStorage {
+			storage[tenant] += size
+			namespaceStorage[namespace] += size
+		}
+	}
+	b.quotas.storage = storage
+	b.quotas.namespaceStorage = namespaceStorage
+}
+
+func (b *Broker) tenantStats() []request.TenantStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tenants := make(map[string]*request.TenantStats)
+	get := func(name string) *request.TenantStats {
+		if _, ok := tenants[name]; !ok {
+			tenants[name] = &request.TenantStats{Name: name}
+		}
+
+		return tenants[name]
+	}
+
+	for tenant, subs := range b.quotas.subscriptions {
+		get(tenant).Subscriptions = len(subs)
+	}
+	for tenant, size := range b.quotas.storage {
+		get(tenant).StorageBytes = size
+	}
+
+	stats := make([]request.TenantStats, 0, len(tenants))
+	for _, t := range tenants {
+		stats = append(stats, *t)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+
+	return stats
+}
+
+// namespaceStats aggregates the topic stats and the quota usage per namespace.
+func (b *Broker) namespaceStats(topics []request.TopicStats) []request.NamespaceStats {
+	namespaces := make(map[string]*request.NamespaceStats)
+	get := func(name string) *request.NamespaceStats {
+		if _, ok := namespaces[name]; !ok {
+			namespaces[name] = &request.NamespaceStats{Name: name}
+		}
+
+		return namespaces[name]
+	}
+
+	for _, t := range topics {
+		namespace, _ := topicPkg.SplitName(t.Name)
+		ns := get(namespace)
+		ns.Topics++
+		ns.Messages += t.Messages
+		ns.Subscribers += t.Subscribers
+	}
+
+	b.mu.Lock()
+	for namespace, size := range b.quotas.namespaceStorage {
+		get(namespace).StorageBytes = size
+	}
+	for namespace, ns := range namespaces {
+		ns.Subscriptions = b.namespaceSubscriptions(namespace)
+	}
+	b.mu.Unlock()
+
+	stats := make([]request.NamespaceStats, 0, len(namespaces))
+	for _, ns := range namespaces {
+		stats = append(stats, *ns)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+
+	return stats
+}
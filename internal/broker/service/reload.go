@@ -0,0 +1,30 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
+	"github.com/NamanBalaji/flux/pkg/config"
+)
+
+// Reconfigure applies a reloaded config to the existing topics: their subscribers push with the new
+// subscriber settings, their dedup windows take the new bounds and their expired messages go to the
+// new dead letter topic. The buffer and priority of a topic are kept until it is created again.
+func (b *Broker) Reconfigure(cfg config.Config) {
+	b.mu.Lock()
+	topics := make([]*topicPkg.Topic, 0, len(b.Topics))
+	for _, topic := range b.Topics {
+		topics = append(topics, topic)
+	}
+	b.mu.Unlock()
+
+	now := time.Now()
+	for _, topic := range topics {
+		namespace, _ := topicPkg.SplitName(topic.Name)
+		topic.Dedup.Resize(dedupWindow(cfg.ForNamespace(namespace)), dedupSize(cfg), now)
+		topic.Reconfigure(cfg.Subscriber, b.expiryHandler(cfg, topic.Name))
+	}
+
+	log.Printf("Applied the reloaded config to %d topics \n", len(topics))
+}
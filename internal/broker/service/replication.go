@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/message"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+var (
+	ErrLogTrimmed  = errors.New("replication log no longer holds the requested offset")
+	ErrNotFollower = errors.New("broker is not a follower")
+	ErrNotLeader   = errors.New("broker is not the leader")
+)
+
+// maxReplicationBatch bounds the number of entries returned by one fetch.
+const maxReplicationBatch = 1000
+
+// replicationLog holds the recent changes of the broker for its followers to replicate.
+type replicationLog struct {
+	mu sync.Mutex
+	// enabled records the changes, it's only set on brokers taking part in replication
+	enabled bool
+	// following mirrors the leader's entries instead of recording the broker's own changes
+	following   bool
+	size        int
+	sync        bool
+	syncTimeout time.Duration
+	entries     []request.ReplicationEntry
+	// next is the offset of the next entry
+	next      uint64
+	followers map[string]*followerState
+	// changed is closed and replaced every time an entry is appended or a follower progresses
+	changed chan struct{}
+}
+
+type followerState struct {
+	// offset is the last entry the follower has replicated
+	offset   uint64
+	inSync   bool
+	lastSeen time.Time
+}
+
+func newReplicationLog() *replicationLog {
+	return &replicationLog{
+		next:      1,
+		followers: make(map[string]*followerState),
+		changed:   make(chan struct{}),
+	}
+}
+
+// record appends the change with the next offset, it returns 0 when the broker doesn't record its changes.
+func (l *replicationLog) record(e request.ReplicationEntry) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.enabled || l.following {
+		return 0
+	}
+
+	e.Offset = l.next
+	l.appendLocked(e)
+
+	return e.Offset
+}
+
+// mirror appends an entry of the leader keeping its offset.
+func (l *replicationLog) mirror(e request.ReplicationEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.appendLocked(e)
+}
+
+func (l *replicationLog) appendLocked(e request.ReplicationEntry) {
+	l.entries = append(l.entries, e)
+	// trim by batches so appends don't copy the whole log every time
+	if len(l.entries) > l.size+l.size/10 {
+		l.entries = append([]request.ReplicationEntry(nil), l.entries[len(l.entries)-l.size:]...)
+	}
+	l.next = e.Offset + 1
+	l.notifyLocked()
+}
+
+func (l *replicationLog) notifyLocked() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// reset drops the entries, the next entry mirrored is the one after the offset.
+func (l *replicationLog) reset(offset uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = nil
+	l.next = offset + 1
+	l.notifyLocked()
+}
+
+func (l *replicationLog) head() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.next - 1
+}
+
+// read returns the entries from the offset on for the follower, reading acknowledges every entry
+// before the offset. The follower is in sync once it has read up to the head.
+func (l *replicationLog) read(follower string, from uint64, now time.Time) ([]request.ReplicationEntry, uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, ok := l.followers[follower]
+	if !ok {
+		state = &followerState{}
+		l.followers[follower] = state
+	}
+	state.lastSeen = now
+	if from > 0 && from-1 > state.offset {
+		state.offset = from - 1
+		l.notifyLocked()
+	}
+	if !state.inSync && from >= l.next {
+		log.Printf("Follower %s caught up with the replication log at offset %d \n", follower, l.next-1)
+		state.inSync = true
+	}
+
+	if from >= l.next {
+		return nil, l.next - 1, nil
+	}
+
+	if len(l.entries) == 0 || from < l.entries[0].Offset {
+		return nil, l.next - 1, fmt.Errorf("%w: offset %d", ErrLogTrimmed, from)
+	}
+
+	start := int(from - l.entries[0].Offset)
+	end := min(len(l.entries), start+maxReplicationBatch)
+
+	return append([]request.ReplicationEntry(nil), l.entries[start:end]...), l.next - 1, nil
+}
+
+// waitForEntries blocks until there are entries from the offset on, the timeout elapses or the context is done.
+func (l *replicationLog) waitForEntries(ctx context.Context, from uint64, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		l.mu.Lock()
+		if l.next > from {
+			l.mu.Unlock()
+
+			return
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-changed:
+		}
+	}
+}
+
+// waitReplicated blocks in sync mode until every in sync follower has replicated the offset.
+// Followers that don't within the sync timeout are no longer in sync until they catch up again.
+func (l *replicationLog) waitReplicated(ctx context.Context, offset uint64) error {
+	l.mu.Lock()
+	if !l.sync || offset == 0 {
+		l.mu.Unlock()
+
+		return nil
+	}
+	timer := time.NewTimer(l.syncTimeout)
+	l.mu.Unlock()
+	defer timer.Stop()
+
+	for {
+		l.mu.Lock()
+		var pending []string
+		for id, state := range l.followers {
+			if state.inSync && state.offset < offset {
+				pending = append(pending, id)
+			}
+		}
+		if len(pending) == 0 {
+			l.mu.Unlock()
+
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-timer.C:
+			l.mu.Lock()
+			for _, id := range pending {
+				if state := l.followers[id]; state.offset < offset {
+					log.Printf("Follower %s did not replicate offset %d in time, it is no longer in sync \n", id, offset)
+					state.inSync = false
+				}
+			}
+			l.mu.Unlock()
+
+			return nil
+		}
+	}
+}
+
+func (l *replicationLog) followerStatus() []request.FollowerStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	followers := make([]request.FollowerStatus, 0, len(l.followers))
+	for id, state := range l.followers {
+		followers = append(followers, request.FollowerStatus{
+			Id:       id,
+			Offset:   state.offset,
+			InSync:   state.inSync,
+			LastSeen: state.lastSeen,
+		})
+	}
+	sort.Slice(followers, func(i, j int) bool {
+		return followers[i].Id < followers[j].Id
+	})
+
+	return followers
+}
+
+// ReplicationLog returns the changes from the offset on for the follower, waiting up to wait for new changes.
+// The push secrets of the subscriptions are only returned withSecrets.
+func (b *Broker) ReplicationLog(ctx context.Context, follower string, from uint64, wait time.Duration, withSecrets bool) (request.ReplicationLogResponse, error) {
+	if role := b.Role(); role != constants.RoleLeader {
+		return request.ReplicationLogResponse{}, ErrNotLeader
+	}
+
+	b.replication.waitForEntries(ctx, from, wait)
+
+	entries, head, err := b.replication.read(follower, from, time.Now())
+	if err != nil {
+		return request.ReplicationLogResponse{}, err
+	}
+
+	if entries == nil {
+		entries = []request.ReplicationEntry{}
+	}
+
+	if !withSecrets {
+		for i, e := range entries {
+			if e.Subscription != nil && e.Subscription.Secret != nil {
+				subscription := *e.Subscription
+				subscription.Secret = nil
+				entries[i].Subscription = &subscription
+			}
+		}
+	}
+
+	return request.ReplicationLogResponse{Entries: entries, Head: head}, nil
+}
+
+// WaitReplicated waits in sync mode until the in sync followers have replicated the published offset,
+// a cluster answers the publishes once they are committed already.
+func (b *Broker) WaitReplicated(ctx context.Context, offset uint64) error {
+	if b.cluster != nil {
+		return nil
+	}
+
+	return b.replication.waitReplicated(ctx, offset)
+}
+
+// Role returns the replication role of the broker, empty when replication is disabled.
+func (b *Broker) Role() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.role
+}
+
+// ReplicationStatus returns the role, the offset of the last change and the followers of a leader.
+func (b *Broker) ReplicationStatus() request.ReplicationStatus {
+	b.mu.Lock()
+	status := request.ReplicationStatus{Role: b.role, Leader: b.leader}
+	b.mu.Unlock()
+
+	if b.cluster != nil {
+		return b.cluster.replicationStatus(status.Role)
+	}
+
+	status.Head = b.replication.head()
+	if status.Role == constants.RoleLeader {
+		status.Followers = b.replication.followerStatus()
+	}
+
+	return status
+}
+
+func publishEntry(req PublishRequest) request.ReplicationEntry {
+	return request.ReplicationEntry{
+		Op:         request.ReplicatePublish,
+		Topic:      req.Topic,
+		Message:    req.Message.Replicated(),
+		ProducerId: req.ProducerId,
+		Sequence:   req.Sequence,
+	}
+}
+
+// recordAck replicates that the subscriber no longer has to deliver the message. The message has
+// been delivered already so a cluster proposes the ack without waiting for it, the leader applies
+// it a second time once committed which leaves the acked message as it is.
+func (b *Broker) recordAck(msg *message.Message, topicName string, addr string) {
+	e := request.ReplicationEntry{
+		Op:        request.ReplicateAck,
+		Topic:     topicName,
+		Address:   addr,
+		MessageId: msg.Id,
+	}
+	if b.cluster != nil {
+		if _, err := b.cluster.append(e); err != nil {
+			log.Printf("failed to replicate the ack of message %s by subscriber[Address: %s] [ERROR]: %s", msg.Id, addr, err)
+		}
+
+		return
+	}
+
+	b.recordChange(e)
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/NamanBalaji/flux/pkg/audit"
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/message"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+var (
+	// ErrNotReplyTopicOwner is returned when a principal uses a reply topic another principal created.
+	ErrNotReplyTopicOwner = errors.New("reply topic belongs to another principal")
+	// ErrReplyTopicPattern is returned when a pattern subscription selects the reply topics.
+	ErrReplyTopicPattern = errors.New("reply topics can't be subscribed to with a pattern")
+)
+
+// IsReplyTopic reports whether the topic name, qualified or not, belongs to the temporary reply topics.
+func IsReplyTopic(name string) bool {
+	_, name = topicPkg.SplitName(name)
+
+	return strings.HasPrefix(name, constants.ReplyTopicPrefix)
+}
+
+// CreateReplyTopic creates a temporary topic for request/reply in the namespace, owned by the
+// principal, which is deleted after ttl, a zero ttl uses the configured default. It returns the
+// qualified name of the topic, a cluster fails with ErrNotCommitted when the topic is not committed.
+func (b *Broker) CreateReplyTopic(cfg config.Config, namespace string, ttl time.Duration, owner string) (string, error) {
+	if ttl <= 0 {
+		ttl = time.Duration(cfg.Topic.TemporaryTTL)
+	}
+	if ttl <= 0 {
+		ttl = constants.DefaultTemporaryTopicTTL
+	}
+
+	name := topicPkg.QualifiedName(namespace, constants.ReplyTopicPrefix+uuid.New().String())
+	expiresAt := time.Now().Add(ttl)
+	if b.cluster != nil {
+		e := request.ReplicationEntry{
+			Op:        request.ReplicateCreateReplyTopic,
+			Topic:     name,
+			ExpiresAt: &expiresAt,
+			Owner:     owner,
+		}
+
+		return name, b.cluster.commit(context.Background(), e).err
+	}
+
+	b.createReplyTopic(cfg, name, expiresAt, owner)
+
+	return name, nil
+}
+
+func (b *Broker) createReplyTopic(cfg config.Config, name string, expiresAt time.Time, owner string) {
+	namespace, _ := topicPkg.SplitName(name)
+	topic := topicPkg.CreateTopic(name, cfg.Topic.Buffer)
+	topic.Dedup = newDedupWindow(cfg.ForNamespace(namespace))
+	topic.PushTransport = b.PushTransport
+	topic.OnAck = b.recordAck
+	topic.Temporary = true
+	topic.ExpiresAt = expiresAt
+	topic.Owner = owner
+
+	b.mu.Lock()
+	topic.Standby = b.standby
+	b.Topics[name] = topic
+	b.recordChange(request.ReplicationEntry{
+		Op:        request.ReplicateCreateReplyTopic,
+		Topic:     name,
+		ExpiresAt: &expiresAt,
+		Owner:     owner,
+	})
+	b.mu.Unlock()
+
+	log.Printf("Created reply topic %s expiring at %s \n", name, topic.ExpiresAt)
+}
+
+// DeleteTopic removes the topic and stops the delivery to its subscribers, it returns the stats
+// of the topic when it was deleted.
+func (b *Broker) DeleteTopic(topicName string) (request.TopicStats, error) {
+	if b.cluster != nil {
+		a := b.cluster.commit(context.Background(), request.ReplicationEntry{Op: request.ReplicateDeleteTopic, Topic: topicName})
+
+		return a.stats, a.err
+	}
+
+	return b.deleteTopic(topicName)
+}
+
+func (b *Broker) deleteTopic(topicName string) (request.TopicStats, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	topic, ok := b.Topics[topicName]
+	if !ok {
+		return request.TopicStats{}, fmt.Errorf("no topic with the name %s exist", topicName)
+	}
+
+	stats := topic.Stats()
+	delete(b.Topics, topicName)
+	topic.Close()
+	b.recordChange(request.ReplicationEntry{Op: request.ReplicateDeleteTopic, Topic: topicName})
+
+	log.Println("Deleted topic: ", topicName)
+
+	return stats, nil
+}
+
+// DeleteReplyTopic deletes the reply topic on behalf of the principal, only its owner may delete it.
+func (b *Broker) DeleteReplyTopic(topicName string, principal string) (request.TopicStats, error) {
+	if _, err := b.replyTopic(topicName, principal); err != nil {
+		return request.TopicStats{}, err
+	}
+
+	return b.DeleteTopic(topicName)
+}
+
+// AwaitReply waits for a message with the correlation id on the reply topic, only the owner of the
+// reply topic may wait on it.
+func (b *Broker) AwaitReply(ctx context.Context, topicName string, correlationId string, principal string) (*message.Message, error) {
+	topic, err := b.replyTopic(topicName, principal)
+	if err != nil {
+		return nil, err
+	}
+
+	return topic.WaitForMessage(ctx, func(msg *message.Message) bool {
+		return msg.CorrelationId == correlationId
+	})
+}
+
+// replyTopic returns the reply topic if the principal owns it.
+func (b *Broker) replyTopic(topicName string, principal string) (*topicPkg.Topic, error) {
+	b.mu.Lock()
+	topic, ok := b.Topics[topicName]
+	b.mu.Unlock()
+
+	if !ok || !topic.Temporary {
+		return nil, fmt.Errorf("no reply topic with the name %s exist", topicName)
+	}
+
+	if topic.Owner != principal {
+		return nil, fmt.Errorf("%w: %s", ErrNotReplyTopicOwner, topicName)
+	}
+
+	return topic, nil
+}
+
+// CheckReplySubscription fails when the principal can't subscribe to the topic or pattern as it addresses
+// the reply topics: patterns can't select them and a reply topic can only be subscribed to by its owner
+// once it's created, subscribing to a missing one would create a topic without owner.
+func (b *Broker) CheckReplySubscription(topicName string, principal string) error {
+	if !IsReplyTopic(topicName) {
+		return nil
+	}
+
+	if topicPkg.IsPattern(topicName) {
+		return fmt.Errorf("%w: %s", ErrReplyTopicPattern, topicName)
+	}
+
+	_, err := b.replyTopic(topicName, principal)
+
+	return err
+}
+
+// CleanupTemporaryTopics deletes the temporary topics that have expired, in a cluster the leader
+// deletes them for every node.
+func (b *Broker) CleanupTemporaryTopics() {
+	now := time.Now()
+
+	b.mu.Lock()
+	var expired []string
+	for name, topic := range b.Topics {
+		if topic.Temporary && now.After(topic.ExpiresAt) {
+			expired = append(expired, name)
+		}
+	}
+	clustered := b.cluster != nil
+	leading := b.role == constants.RoleLeader
+	b.mu.Unlock()
+
+	if clustered && !leading {
+		return
+	}
+
+	for _, name := range expired {
+		stats, err := b.DeleteTopic(name)
+		if err != nil {
+			log.Printf("failed to delete expired temporary topic %s [ERROR]: %s", name, err)
+
+			continue
+		}
+
+		log.Println("Deleted expired temporary topic: ", name)
+		b.recordAudit(audit.Event{
+			Action:    audit.TopicDelete,
+			Principal: audit.SystemPrincipal,
+			Target:    name,
+			Before:    stats,
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"container/heap"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+// scheduledRequest is a publish request held back until its delivery time, seq keeps
+// requests with the same delivery time in publish order.
+type scheduledRequest struct {
+	req PublishRequest
+	seq uint64
+}
+
+type scheduledHeap []scheduledRequest
+
+func (h scheduledHeap) Len() int { return len(h) }
+
+func (h scheduledHeap) Less(i, j int) bool {
+	if h[i].req.DeliverAt.Equal(h[j].req.DeliverAt) {
+		return h[i].seq < h[j].seq
+	}
+
+	return h[i].req.DeliverAt.Before(h[j].req.DeliverAt)
+}
+
+func (h scheduledHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *scheduledHeap) Push(x any) { *h = append(*h, x.(scheduledRequest)) }
+
+func (h *scheduledHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+
+	return item
+}
+
+// scheduler holds delayed messages outside the topics, so they are untouched by the cleanup
+// cycles, and releases them in due time order.
+type scheduler struct {
+	mu       sync.Mutex
+	requests scheduledHeap
+	seq      uint64
+	wake     chan struct{}
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		wake: make(chan struct{}, 1),
+	}
+}
+
+func (s *scheduler) add(req PublishRequest) {
+	s.mu.Lock()
+	s.seq++
+	heap.Push(&s.requests, scheduledRequest{req: req, seq: s.seq})
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// popDue removes and returns the requests due at the given time in delivery order.
+func (s *scheduler) popDue(now time.Time) []PublishRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []PublishRequest
+	for s.requests.Len() > 0 && !s.requests[0].req.DeliverAt.After(now) {
+		due = append(due, heap.Pop(&s.requests).(scheduledRequest).req)
+	}
+
+	return due
+}
+
+func (s *scheduler) next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.requests.Len() == 0 {
+		return time.Time{}, false
+	}
+
+	return s.requests[0].req.DeliverAt, true
+}
+
+// has reports whether a message with the id is scheduled to the topic.
+func (s *scheduler) has(topic string, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.requests {
+		if r.req.Topic == topic && r.req.Message.Id == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cancel removes the message with the id scheduled to the topic, message ids are only unique per topic.
+func (s *scheduler) cancel(topic string, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, r := range s.requests {
+		if r.req.Topic == topic && r.req.Message.Id == id {
+			heap.Remove(&s.requests, i)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// clear drops every scheduled request and returns how many were dropped.
+func (s *scheduler) clear() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := s.requests.Len()
+	s.requests = nil
+
+	return dropped
+}
+
+func (s *scheduler) list() []request.ScheduledMessage {
+	s.mu.Lock()
+	sorted := make(scheduledHeap, len(s.requests))
+	copy(sorted, s.requests)
+	s.mu.Unlock()
+
+	sort.Sort(sorted)
+
+	messages := make([]request.ScheduledMessage, len(sorted))
+	for i, r := range sorted {
+		messages[i] = request.ScheduledMessage{
+			Id:        r.req.Message.Id,
+			Topic:     r.req.Topic,
+			DeliverAt: r.req.DeliverAt,
+		}
+	}
+
+	return messages
+}
+
+// entries returns the scheduled messages in due time order.
+func (s *scheduler) entries() []request.ScheduledEntry {
+	s.mu.Lock()
+	sorted := make(scheduledHeap, len(s.requests))
+	copy(sorted, s.requests)
+	s.mu.Unlock()
+
+	sort.Sort(sorted)
+
+	entries := make([]request.ScheduledEntry, len(sorted))
+	for i, r := range sorted {
+		entries[i] = request.ScheduledEntry{
+			Topic:     r.req.Topic,
+			DeliverAt: r.req.DeliverAt,
+			Message:   *r.req.Message.Replicated(),
+		}
+	}
+
+	return entries
+}
+
+// scheduleLocked holds the request until its delivery time, the message id is checked against the
+// topic dedup window and the messages already scheduled to the topic so a retried scheduled publish
+// is reported as a duplicate instead of being delivered twice. The caller must hold b.mu.
+func (b *Broker) scheduleLocked(req PublishRequest) PublishResult {
+	if topic, ok := b.Topics[req.Topic]; ok && !topic.ShouldEnqueue(req.Message) {
+		return PublishResult{Duplicate: true}
+	}
+
+	if b.scheduler.has(req.Topic, req.Message.Id) {
+		log.Printf("Message with id %s is already scheduled to topic %s \n", req.Message.Id, req.Topic)
+
+		return PublishResult{Duplicate: true}
+	}
+
+	log.Printf("Scheduled message with id %s to topic %s for %s \n", req.Message.Id, req.Topic, req.DeliverAt)
+	b.scheduler.add(req)
+
+	return PublishResult{Scheduled: true}
+}
+
+// runScheduler publishes scheduled messages as they become due.
+func (b *Broker) runScheduler(cfg *config.Holder) {
+	for {
+		for _, req := range b.scheduler.popDue(time.Now()) {
+			log.Printf("Releasing scheduled message with id %s to topic %s \n", req.Message.Id, req.Topic)
+			// the message ttl starts counting when the message is delivered
+			req.Message.AddedAt = time.Now()
+			// the publisher got its answer when the message was scheduled
+			req.Result = nil
+			b.processing.Lock()
+			b.publish(cfg.Get(), req)
+			b.processing.Unlock()
+		}
+
+		wait := time.Hour
+		if next, ok := b.scheduler.next(); ok {
+			wait = time.Until(next)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-b.scheduler.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// ScheduledMessages lists the messages waiting for their delivery time in due time order.
+func (b *Broker) ScheduledMessages() []request.ScheduledMessage {
+	return b.scheduler.list()
+}
+
+// CancelScheduled drops the message with the id scheduled to the topic before it is delivered.
+func (b *Broker) CancelScheduled(topic string, id string) bool {
+	return b.scheduler.cancel(topic, id)
+}
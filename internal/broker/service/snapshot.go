@@ -0,0 +1,153 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/broker/subscriber"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/message"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+var (
+	ErrBrokerNotEmpty     = errors.New("snapshots are only restored into a broker without topics")
+	ErrSnapshotReplicated = errors.New("snapshots can't be restored into a replicated broker")
+)
+
+// Snapshot captures the topics, messages, dedup windows, subscriptions with their progress,
+// scheduled messages and producer sequences of the broker. The publishes are fenced while it's
+// taken so no publish is half applied. The push secrets of the subscriptions are only returned withSecrets.
+func (b *Broker) Snapshot(withSecrets bool) request.Snapshot {
+	b.processing.Lock()
+	defer b.processing.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := request.Snapshot{
+		Version:   constants.SnapshotVersion,
+		CreatedAt: time.Now(),
+		Topics:    make([]request.TopicSnapshot, 0, len(b.Topics)),
+		Patterns:  b.patternStates(),
+		Scheduled: b.scheduler.entries(),
+		Producers: make([]request.ProducerState, 0),
+		// read before the topics, the acks recorded meanwhile are replayed again which is harmless
+		Offset: b.replication.head(),
+	}
+	for _, topic := range b.Topics {
+		snapshot.Topics = append(snapshot.Topics, topic.Snapshot())
+	}
+	sort.Slice(snapshot.Topics, func(i, j int) bool {
+		return snapshot.Topics[i].Name < snapshot.Topics[j].Name
+	})
+
+	for topic, producers := range b.producers {
+		for id, state := range producers {
+			snapshot.Producers = append(snapshot.Producers, request.ProducerState{
+				Topic:      topic,
+				ProducerId: id,
+				Sequence:   state.sequence,
+				LastSeen:   state.lastSeen,
+			})
+		}
+	}
+	sort.Slice(snapshot.Producers, func(i, j int) bool {
+		if snapshot.Producers[i].Topic != snapshot.Producers[j].Topic {
+			return snapshot.Producers[i].Topic < snapshot.Producers[j].Topic
+		}
+
+		return snapshot.Producers[i].ProducerId < snapshot.Producers[j].ProducerId
+	})
+
+	if !withSecrets {
+		for i := range snapshot.Topics {
+			for j := range snapshot.Topics[i].Subscribers {
+				snapshot.Topics[i].Subscribers[j].Subscription.Secret = nil
+			}
+		}
+		for i := range snapshot.Patterns {
+			snapshot.Patterns[i].Subscription.Secret = nil
+		}
+	}
+
+	return snapshot
+}
+
+// RestoreSnapshot loads the snapshot into the broker on behalf of the principal, the active
+// subscribers start delivering the messages they had still to receive. The broker must hold no
+// topic, and must not be replicated as the snapshot is not replicated to the other brokers.
+func (b *Broker) RestoreSnapshot(cfg config.Config, snapshot request.Snapshot, principal string) error {
+	if snapshot.Version != constants.SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected %d", snapshot.Version, constants.SnapshotVersion)
+	}
+
+	b.processing.Lock()
+	defer b.processing.Unlock()
+
+	b.mu.Lock()
+	if b.cluster != nil || b.role != "" {
+		b.mu.Unlock()
+
+		return ErrSnapshotReplicated
+	}
+	if len(b.Topics) > 0 || len(b.patterns) > 0 {
+		b.mu.Unlock()
+
+		return ErrBrokerNotEmpty
+	}
+
+	b.loadSnapshotLocked(cfg, snapshot, principal)
+	b.mu.Unlock()
+
+	for _, s := range snapshot.Scheduled {
+		b.scheduler.add(PublishRequest{
+			Topic:     s.Topic,
+			Message:   message.FromReplicated(&s.Message),
+			DeliverAt: s.DeliverAt,
+		})
+	}
+
+	b.recomputeStorage()
+
+	log.Printf("Restored a snapshot of %d topics taken at %s \n", len(snapshot.Topics), snapshot.CreatedAt)
+
+	return nil
+}
+
+// loadSnapshotLocked creates the topics, the wildcard subscriptions and the producer sequences of the
+// snapshot, the caller must hold b.mu.
+func (b *Broker) loadSnapshotLocked(cfg config.Config, snapshot request.Snapshot, principal string) {
+	for _, ts := range snapshot.Topics {
+		topic := b.getOrCreateTopic(cfg, ts.Name, principal)
+		topic.Load(cfg, ts, func(s request.SubscriberState) subscriber.Options {
+			return restoredOptions(s.Address, s.Subscription)
+		})
+		for _, s := range ts.Subscribers {
+			b.reserveRestored(s.Subscription.Owner, ts.Name, s.Address)
+		}
+	}
+
+	for _, p := range snapshot.Patterns {
+		b.patterns = append(b.patterns, patternSubscription{
+			pattern: p.Pattern,
+			address: p.Address,
+			readOld: p.Subscription.ReadOld,
+			opts:    restoredOptions(p.Address, p.Subscription),
+		})
+		b.reserveRestored(p.Subscription.Owner, p.Pattern, p.Address)
+	}
+
+	for _, p := range snapshot.Producers {
+		producers, ok := b.producers[p.Topic]
+		if !ok {
+			producers = make(map[string]*producerState)
+			b.producers[p.Topic] = producers
+		}
+		producers[p.ProducerId] = &producerState{sequence: p.Sequence, lastSeen: p.LastSeen}
+	}
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/broker/subscriber"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/filter"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+var ErrStateReplicated = errors.New("the subscribers of a cluster node or a follower are replicated from the leader")
+
+// SaveState writes the subscriptions of the broker and their progress to the file, temporary
+// topics are left out as they don't outlive a restart.
+func (b *Broker) SaveState(file string) error {
+	state := b.State()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write subscriber state: %w", err)
+	}
+
+	return os.Rename(tmp, file)
+}
+
+// State returns the subscriptions of the broker and their progress.
+func (b *Broker) State() request.BrokerState {
+	b.mu.Lock()
+	state := request.BrokerState{
+		SavedAt:     time.Now(),
+		Subscribers: make([]request.SubscriberState, 0),
+		Patterns:    b.patternStates(),
+	}
+	for _, topic := range b.Topics {
+		if !topic.Temporary {
+			state.Subscribers = append(state.Subscribers, topic.SubscriberStates()...)
+		}
+	}
+	b.mu.Unlock()
+
+	sort.Slice(state.Subscribers, func(i, j int) bool {
+		if state.Subscribers[i].Topic != state.Subscribers[j].Topic {
+			return state.Subscribers[i].Topic < state.Subscribers[j].Topic
+		}
+
+		return state.Subscribers[i].Address < state.Subscribers[j].Address
+	})
+
+	return state
+}
+
+// patternStates returns the wildcard subscriptions, the caller must hold b.mu.
+func (b *Broker) patternStates() []request.PatternState {
+	states := make([]request.PatternState, 0, len(b.patterns))
+	for _, p := range b.patterns {
+		states = append(states, request.PatternState{
+			Pattern:      p.pattern,
+			Address:      p.address,
+			Subscription: replicatedSubscription(p.readOld, p.opts),
+		})
+	}
+
+	return states
+}
+
+// RestoreState restores the subscriptions saved to the file, the active subscribers resume their
+// delivery. A missing file restores nothing.
+func (b *Broker) RestoreState(cfg config.Config, file string) error {
+	b.mu.Lock()
+	replicated := b.cluster != nil || b.role == constants.RoleFollower
+	b.mu.Unlock()
+	if replicated {
+		return ErrStateReplicated
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read subscriber state %s: %w", file, err)
+	}
+
+	var state request.BrokerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid subscriber state %s: %w", file, err)
+	}
+
+	b.restoreState(cfg, state)
+
+	log.Printf("Restored %d subscribers and %d wildcard subscriptions saved at %s \n", len(state.Subscribers), len(state.Patterns), state.SavedAt)
+
+	return nil
+}
+
+func (b *Broker) restoreState(cfg config.Config, state request.BrokerState) {
+	// the subscribers are restored before the patterns so the topics they create don't attach them again
+	for _, s := range state.Subscribers {
+		opts := restoredOptions(s.Address, s.Subscription)
+
+		b.mu.Lock()
+		topic := b.getOrCreateTopic(cfg, s.Topic, opts.Owner)
+		b.reserveRestored(opts.Owner, s.Topic, s.Address)
+		b.mu.Unlock()
+
+		topic.Restore(cfg, s, opts)
+
+		b.recordChange(request.ReplicationEntry{
+			Op:           request.ReplicateSubscribe,
+			Topic:        s.Topic,
+			Address:      s.Address,
+			Subscription: &s.Subscription,
+		})
+		if !s.Active {
+			b.recordChange(request.ReplicationEntry{
+				Op:      request.ReplicateUnsubscribe,
+				Topic:   s.Topic,
+				Address: s.Address,
+			})
+		}
+	}
+
+	for _, p := range state.Patterns {
+		opts := restoredOptions(p.Address, p.Subscription)
+
+		b.mu.Lock()
+		b.patterns = append(b.patterns, patternSubscription{
+			pattern: p.Pattern,
+			address: p.Address,
+			readOld: p.Subscription.ReadOld,
+			opts:    opts,
+		})
+		b.reserveRestored(opts.Owner, p.Pattern, p.Address)
+		b.mu.Unlock()
+
+		b.recordChange(request.ReplicationEntry{
+			Op:           request.ReplicateSubscribe,
+			Topic:        p.Pattern,
+			Address:      p.Address,
+			Subscription: &p.Subscription,
+		})
+	}
+}
+
+// reserveRestored counts the restored subscription in the tenant's quota, the caller must hold b.mu.
+func (b *Broker) reserveRestored(tenant string, topic string, address string) {
+	subs := b.quotas.subscriptions[tenant]
+	if subs == nil {
+		subs = make(map[subscriptionKey]bool)
+		b.quotas.subscriptions[tenant] = subs
+	}
+	subs[subscriptionKey{topic, address}] = true
+}
+
+func replicatedSubscription(readOld bool, opts subscriber.Options) request.ReplicatedSubscription {
+	return request.ReplicatedSubscription{
+		ReadOld: readOld,
+		Raw:     opts.Raw,
+		Filter:  opts.Filter.String(),
+		Secret:  opts.Secret,
+		Owner:   opts.Owner,
+	}
+}
+
+func restoredOptions(address string, s request.ReplicatedSubscription) subscriber.Options {
+	f, err := filter.Parse(s.Filter)
+	if err != nil {
+		log.Printf("invalid saved filter of subscriber[Address: %s] [ERROR]: %s", address, err)
+	}
+
+	return subscriber.Options{Raw: s.Raw, Filter: f, Secret: s.Secret, Owner: s.Owner}
+}
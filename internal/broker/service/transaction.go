@@ -0,0 +1,184 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+)
+
+var (
+	ErrNoTransaction = errors.New("transaction does not exist")
+	// ErrScheduledInTransaction rejects scheduled messages as a transaction is published at once
+	ErrScheduledInTransaction = errors.New("scheduled messages can't be published in a transaction")
+	// ErrTransactionRejected is returned when none of the messages were published because one couldn't be
+	ErrTransactionRejected = errors.New("transaction rejected")
+)
+
+// transaction buffers publish requests which only become visible to subscribers on commit.
+type transaction struct {
+	id       string
+	requests []PublishRequest
+	timer    *time.Timer
+}
+
+type transactions struct {
+	mu   sync.Mutex
+	open map[string]*transaction
+}
+
+// BeginTransaction opens a transaction which is aborted if not committed within timeout.
+func (b *Broker) BeginTransaction(timeout time.Duration) string {
+	tx := &transaction{id: uuid.New().String()}
+
+	b.transactions.mu.Lock()
+	b.transactions.open[tx.id] = tx
+	tx.timer = time.AfterFunc(timeout, func() {
+		if b.removeTransaction(tx.id) != nil {
+			log.Printf("Transaction %s timed out and has been aborted \n", tx.id)
+		}
+	})
+	b.transactions.mu.Unlock()
+
+	log.Printf("Transaction %s started \n", tx.id)
+
+	return tx.id
+}
+
+// AddToTransaction buffers the publish request until the transaction is committed.
+func (b *Broker) AddToTransaction(id string, req PublishRequest) error {
+	if !req.DeliverAt.IsZero() {
+		return ErrScheduledInTransaction
+	}
+
+	b.transactions.mu.Lock()
+	defer b.transactions.mu.Unlock()
+
+	tx, ok := b.transactions.open[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoTransaction, id)
+	}
+
+	tx.requests = append(tx.requests, req)
+
+	return nil
+}
+
+// CommitTransaction publishes every message of the transaction together, or none of them when
+// one is a duplicate or addressed to a deleted reply topic. The result holds the outcome of each
+// message in publish order.
+func (b *Broker) CommitTransaction(id string) (PublishResult, error) {
+	tx := b.removeTransaction(id)
+	if tx == nil {
+		return PublishResult{}, fmt.Errorf("%w: %s", ErrNoTransaction, id)
+	}
+
+	if len(tx.requests) == 0 {
+		return PublishResult{}, nil
+	}
+
+	result := make(chan PublishResult, 1)
+	b.EnqueueRequest(PublishRequest{Batch: tx.requests, Result: result})
+
+	r := <-result
+	if r.Err != nil {
+		return r, r.Err
+	}
+	if r.Rejected {
+		for i, br := range r.BatchResults {
+			req := tx.requests[i]
+			switch {
+			case br.Duplicate:
+				return r, fmt.Errorf("%w: message with id %s is a duplicate on topic %s", ErrTransactionRejected, req.Message.Id, req.Topic)
+			case br.Dropped:
+				return r, fmt.Errorf("%w: reply topic %s does not exist anymore", ErrTransactionRejected, req.Topic)
+			}
+		}
+	}
+
+	log.Printf("Transaction %s committed with %d messages \n", id, len(tx.requests))
+
+	return r, nil
+}
+
+// AbortTransaction discards every message of the transaction.
+func (b *Broker) AbortTransaction(id string) error {
+	if b.removeTransaction(id) == nil {
+		return fmt.Errorf("%w: %s", ErrNoTransaction, id)
+	}
+
+	log.Printf("Transaction %s aborted \n", id)
+
+	return nil
+}
+
+func (b *Broker) removeTransaction(id string) *transaction {
+	b.transactions.mu.Lock()
+	defer b.transactions.mu.Unlock()
+
+	tx, ok := b.transactions.open[id]
+	if !ok {
+		return nil
+	}
+
+	tx.timer.Stop()
+	delete(b.transactions.open, id)
+
+	return tx
+}
+
+// publishBatch publishes the requests while holding the broker lock so no other publish
+// is interleaved with them. The batch is checked first and nothing is published when one of
+// its messages would be skipped, the result is then Rejected with the failing messages flagged.
+func (b *Broker) publishBatch(cfg config.Config, batch []PublishRequest) PublishResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if results, ok := b.checkBatchLocked(batch); !ok {
+		log.Printf("Rejected a batch of %d messages \n", len(batch))
+
+		return PublishResult{Rejected: true, BatchResults: results}
+	}
+
+	results := make([]PublishResult, len(batch))
+	for i, req := range batch {
+		results[i] = b.publishLocked(cfg, req)
+	}
+
+	result := PublishResult{BatchResults: results}
+	for _, r := range results {
+		result.Offset = max(result.Offset, r.Offset)
+	}
+
+	return result
+}
+
+// checkBatchLocked flags the messages of the batch publishLocked would skip: duplicates, within
+// the dedup window or the batch itself, and replies to deleted reply topics. The caller must hold b.mu.
+func (b *Broker) checkBatchLocked(batch []PublishRequest) ([]PublishResult, bool) {
+	results := make([]PublishResult, len(batch))
+	seen := make(map[string]map[string]bool)
+	ok := true
+	for i, req := range batch {
+		topic, exists := b.Topics[req.Topic]
+		switch {
+		case !exists && IsReplyTopic(req.Topic):
+			results[i] = PublishResult{Dropped: true}
+		case exists && !topic.ShouldEnqueue(req.Message), seen[req.Topic][req.Message.Id]:
+			results[i] = PublishResult{Duplicate: true}
+		}
+
+		if seen[req.Topic] == nil {
+			seen[req.Topic] = make(map[string]bool)
+		}
+		seen[req.Topic][req.Message.Id] = true
+		ok = ok && !results[i].Duplicate && !results[i].Dropped
+	}
+
+	return results, ok
+}
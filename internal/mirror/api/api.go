@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/internal/mirror/handler"
+	"github.com/NamanBalaji/flux/pkg/mirror"
+)
+
+func SetupRouter(m *mirror.Mirror) *gin.Engine {
+	r := gin.Default()
+
+	r.GET("/ping", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "alive",
+		})
+	})
+
+	r.POST("/poll", handler.PollMessage(m))
+	r.GET("/stats", handler.StatsHandler(m))
+
+	return r
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/NamanBalaji/flux/pkg/mirror"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+// PollMessage mirrors the message pushed by the source, the push is only acknowledged once the
+// destination has the message so the source pushes it again otherwise.
+func PollMessage(m *mirror.Mirror) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Printf("invalid request body [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, err)
+
+			return
+		}
+
+		if err := m.Verify(c.Request.Header, jsonData); err != nil {
+			log.Printf("rejected delivery [ERROR]: %s", err)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		var body request.PollMessage
+		if err := json.Unmarshal(jsonData, &body); err != nil {
+			log.Printf("invalid body format [ERROR]: %s", err)
+			c.JSON(http.StatusBadRequest, err)
+
+			return
+		}
+
+		if err := m.Mirror(body); err != nil {
+			log.Printf("failed to mirror message %s [ERROR]: %s", body.Id, err)
+			status := http.StatusBadGateway
+			if errors.Is(err, mirror.ErrNotMirrored) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{
+				"message": err.Error(),
+			})
+
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "message mirrored successfully",
+		})
+	}
+}
+
+// StatsHandler reports the progress and lag of the mirrored topics.
+func StatsHandler(m *mirror.Mirror) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, m.Stats())
+	}
+}
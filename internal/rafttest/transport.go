@@ -0,0 +1,122 @@
+// Package rafttest connects raft nodes in memory to test clusters.
+package rafttest
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/NamanBalaji/flux/pkg/raft"
+)
+
+var ErrUnreachable = errors.New("peer is unreachable")
+
+// LocalTransport connects the nodes of one process. Nodes can be disconnected to simulate crashes
+// and network partitions.
+type LocalTransport struct {
+	mu    sync.Mutex
+	nodes map[string]*raft.Node
+	// cut holds the directed links that drop requests, from -> to
+	cut map[[2]string]bool
+}
+
+func NewLocalTransport() *LocalTransport {
+	return &LocalTransport{
+		nodes: make(map[string]*raft.Node),
+		cut:   make(map[[2]string]bool),
+	}
+}
+
+// Register makes the node reachable under its id, replacing a previous node with the same id.
+func (t *LocalTransport) Register(id string, n *raft.Node) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nodes[id] = n
+}
+
+// Partition cuts the links between the two groups of nodes in both directions.
+func (t *LocalTransport) Partition(group []string, others []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, a := range group {
+		for _, b := range others {
+			t.cut[[2]string{a, b}] = true
+			t.cut[[2]string{b, a}] = true
+		}
+	}
+}
+
+// Heal restores every link.
+func (t *LocalTransport) Heal() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cut = make(map[[2]string]bool)
+}
+
+// For returns the transport used by the node with the id.
+func (t *LocalTransport) For(id string) raft.Transport {
+	return localClient{id: id, transport: t}
+}
+
+func (t *LocalTransport) target(from string, to string) (*raft.Node, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, ok := t.nodes[to]
+	if !ok || t.cut[[2]string{from, to}] {
+		return nil, ErrUnreachable
+	}
+
+	return n, nil
+}
+
+type localClient struct {
+	id        string
+	transport *LocalTransport
+}
+
+func (c localClient) RequestVote(ctx context.Context, peer string, req raft.VoteRequest) (raft.VoteResponse, error) {
+	n, err := c.transport.target(c.id, peer)
+	if err != nil {
+		return raft.VoteResponse{}, err
+	}
+	res := n.HandleRequestVote(req)
+
+	// the response is lost too when the link back was cut in the meantime
+	if _, err := c.transport.target(peer, c.id); err != nil {
+		return raft.VoteResponse{}, err
+	}
+
+	return res, ctx.Err()
+}
+
+func (c localClient) AppendEntries(ctx context.Context, peer string, req raft.AppendRequest) (raft.AppendResponse, error) {
+	n, err := c.transport.target(c.id, peer)
+	if err != nil {
+		return raft.AppendResponse{}, err
+	}
+	res := n.HandleAppendEntries(req)
+
+	if _, err := c.transport.target(peer, c.id); err != nil {
+		return raft.AppendResponse{}, err
+	}
+
+	return res, ctx.Err()
+}
+
+func (c localClient) InstallSnapshot(ctx context.Context, peer string, req raft.SnapshotRequest) (raft.SnapshotResponse, error) {
+	n, err := c.transport.target(c.id, peer)
+	if err != nil {
+		return raft.SnapshotResponse{}, err
+	}
+	res := n.HandleInstallSnapshot(req)
+
+	if _, err := c.transport.target(peer, c.id); err != nil {
+		return raft.SnapshotResponse{}, err
+	}
+
+	return res, ctx.Err()
+}
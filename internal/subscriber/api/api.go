@@ -7,9 +7,10 @@ import (
 
 	"github.com/NamanBalaji/flux/internal/subscriber/handler"
 	"github.com/NamanBalaji/flux/pkg/request"
+	"github.com/NamanBalaji/flux/pkg/signature"
 )
 
-func SetupRouter(messageChan chan request.PollMessage) *gin.Engine {
+func SetupRouter(messageChan chan request.PollMessage, verifier *signature.Verifier) *gin.Engine {
 	r := gin.Default()
 
 	r.GET("/ping", func(c *gin.Context) {
@@ -18,7 +19,7 @@ func SetupRouter(messageChan chan request.PollMessage) *gin.Engine {
 		})
 	})
 
-	r.POST("/poll", handler.PollMessage(messageChan))
+	r.POST("/poll", handler.PollMessage(messageChan, verifier))
 
 	return r
 }
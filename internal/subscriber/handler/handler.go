@@ -6,13 +6,18 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/NamanBalaji/flux/pkg/constants"
 	"github.com/NamanBalaji/flux/pkg/request"
+	"github.com/NamanBalaji/flux/pkg/signature"
 )
 
-func PollMessage(messageChan chan request.PollMessage) gin.HandlerFunc {
+// PollMessage receives the messages pushed by the broker, deliveries which are not signed
+// with the subscription secret are rejected when a verifier is given.
+func PollMessage(messageChan chan request.PollMessage, verifier *signature.Verifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		jsonData, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -22,13 +27,38 @@ func PollMessage(messageChan chan request.PollMessage) gin.HandlerFunc {
 			return
 		}
 
+		if verifier != nil {
+			if err := verifier.Verify(c.Request.Header, jsonData, time.Now()); err != nil {
+				log.Printf("rejected delivery [ERROR]: %s", err)
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"message": err.Error(),
+				})
+
+				return
+			}
+		}
+
 		var body request.PollMessage
-		err = json.Unmarshal(jsonData, &body)
-		if err != nil {
-			log.Printf("invalid body format [ERROR]: %s", err)
-			c.JSON(http.StatusBadRequest, err)
+		if id := c.GetHeader(constants.IdHeader); id != "" {
+			// raw delivery, the payload is the body and the metadata is in the headers
+			body = request.PollMessage{
+				Id:            id,
+				Payload:       jsonData,
+				ContentType:   c.ContentType(),
+				Topic:         c.GetHeader(constants.TopicHeader),
+				Headers:       request.HeadersFromHTTP(c.Request.Header),
+				ReplyTo:       c.GetHeader(constants.ReplyToHeader),
+				CorrelationId: c.GetHeader(constants.CorrelationHeader),
+			}
+			body.PublishedAt, _ = time.Parse(time.RFC3339Nano, c.GetHeader(constants.PublishedAtHeader))
+		} else {
+			err = json.Unmarshal(jsonData, &body)
+			if err != nil {
+				log.Printf("invalid body format [ERROR]: %s", err)
+				c.JSON(http.StatusBadRequest, err)
 
-			return
+				return
+			}
 		}
 
 		messageChan <- body
@@ -0,0 +1,190 @@
+package acl
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+
+	topicPkg "github.com/NamanBalaji/flux/pkg/broker/topic"
+	"github.com/NamanBalaji/flux/pkg/config"
+)
+
+type Action string
+
+const (
+	Publish   Action = "publish"
+	Subscribe Action = "subscribe"
+	// AnySubject in a rule matches every caller, including unauthenticated ones
+	AnySubject = "*"
+)
+
+// Rule allows a subject to perform the actions on the topics of a namespace, topics can be wildcard patterns.
+type Rule struct {
+	Id string `json:"id"`
+	// Namespace of the topics, the default namespace when empty
+	Namespace string   `json:"namespace,omitempty"`
+	Subject   string   `json:"subject"`
+	Topics    []string `json:"topics"`
+	Actions   []Action `json:"actions"`
+}
+
+// List holds the access rules, everything not allowed by a rule is denied.
+type List struct {
+	mu     sync.RWMutex
+	admins map[string]bool
+	rules  []Rule
+}
+
+// NewList builds the access list from the config, it returns nil when ACLs are disabled.
+func NewList(cfg config.ACL) (*List, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	l := &List{admins: make(map[string]bool)}
+	for _, admin := range cfg.Admins {
+		l.admins[admin] = true
+	}
+
+	for _, r := range cfg.Rules {
+		actions := make([]Action, 0, len(r.Actions))
+		for _, a := range r.Actions {
+			actions = append(actions, Action(a))
+		}
+
+		if _, err := l.Add(Rule{Namespace: r.Namespace, Subject: r.Subject, Topics: r.Topics, Actions: actions}); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// Allowed reports whether the subject may perform the action on the qualified topic name. A nil list allows everything.
+func (l *List) Allowed(subject string, action Action, topic string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, r := range l.rules {
+		if r.allows(subject, action, topic) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsAdmin reports whether the subject may use the admin endpoints. A nil list allows everything.
+func (l *List) IsAdmin(subject string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.admins[subject] || l.admins[AnySubject]
+}
+
+// Add validates the rule and adds it with a new id.
+func (l *List) Add(r Rule) (Rule, error) {
+	if err := r.validate(); err != nil {
+		return Rule{}, err
+	}
+	r.Id = uuid.New().String()
+
+	l.mu.Lock()
+	l.rules = append(l.rules, r)
+	l.mu.Unlock()
+
+	return r, nil
+}
+
+// Remove deletes the rule with the id, it returns the removed rule and whether it existed.
+func (l *List) Remove(id string) (Rule, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, r := range l.rules {
+		if r.Id == id {
+			l.rules = append(l.rules[:i], l.rules[i+1:]...)
+
+			return r, true
+		}
+	}
+
+	return Rule{}, false
+}
+
+func (l *List) Rules() []Rule {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	rules := make([]Rule, len(l.rules))
+	copy(rules, l.rules)
+
+	return rules
+}
+
+func (r Rule) validate() error {
+	if r.Subject == "" {
+		return fmt.Errorf("acl rule must have a subject")
+	}
+
+	if len(r.Topics) == 0 || len(r.Actions) == 0 {
+		return fmt.Errorf("acl rule for %s must have topics and actions", r.Subject)
+	}
+
+	if r.Namespace != "" {
+		if err := topicPkg.ValidateNamespace(r.Namespace); err != nil {
+			return fmt.Errorf("acl rule for %s: %w", r.Subject, err)
+		}
+	}
+
+	for _, topic := range r.Topics {
+		if err := topicPkg.ValidatePattern(topic); err != nil {
+			return fmt.Errorf("acl rule for %s: %w", r.Subject, err)
+		}
+	}
+
+	for _, a := range r.Actions {
+		if a != Publish && a != Subscribe {
+			return fmt.Errorf("acl rule for %s has unknown action %q", r.Subject, a)
+		}
+	}
+
+	return nil
+}
+
+// allows checks the topic against the rule's topics, a subscription to a pattern is only
+// allowed if the rule covers every topic the pattern matches.
+func (r Rule) allows(subject string, action Action, topic string) bool {
+	if r.Subject != AnySubject && r.Subject != subject {
+		return false
+	}
+
+	allowed := false
+	for _, a := range r.Actions {
+		if a == action {
+			allowed = true
+
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+
+	for _, pattern := range r.Topics {
+		if topicPkg.CoversPattern(topicPkg.QualifiedName(r.Namespace, pattern), topic) {
+			return true
+		}
+	}
+
+	return false
+}
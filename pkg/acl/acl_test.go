@@ -0,0 +1,110 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+)
+
+func TestAllowed(t *testing.T) {
+	l, err := NewList(config.ACL{
+		Enabled: true,
+		Rules: []config.ACLRule{
+			{Subject: "orders-service", Topics: []string{"orders.#"}, Actions: []string{"publish"}},
+			{Subject: "billing", Topics: []string{"orders.*.created"}, Actions: []string{"subscribe"}},
+			{Subject: "*", Topics: []string{"public"}, Actions: []string{"publish", "subscribe"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		subject string
+		action  Action
+		topic   string
+		allowed bool
+	}{
+		{"orders-service", Publish, "orders.eu.created", true},
+		{"orders-service", Subscribe, "orders.eu.created", false},
+		{"orders-service", Publish, "payments", false},
+		{"billing", Subscribe, "orders.eu.created", true},
+		{"billing", Subscribe, "orders.*.created", true},
+		{"billing", Subscribe, "orders.#", false},
+		{"billing", Publish, "orders.eu.created", false},
+		{"anyone", Publish, "public", true},
+		{"", Subscribe, "public", true},
+		{"anyone", Publish, "orders.eu", false},
+	}
+
+	for _, tt := range tests {
+		if l.Allowed(tt.subject, tt.action, tt.topic) != tt.allowed {
+			t.Errorf("Allowed(%q, %s, %q) expected %v", tt.subject, tt.action, tt.topic, tt.allowed)
+		}
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	l, _ := NewList(config.ACL{Enabled: true, Admins: []string{"root"}})
+
+	if l.Allowed("app", Publish, "events") {
+		t.Error("Empty list should deny everything")
+	}
+
+	rule, err := l.Add(Rule{Subject: "app", Topics: []string{"events"}, Actions: []Action{Publish}})
+	if err != nil || rule.Id == "" {
+		t.Fatalf("Valid rule should be added with an id, got %v", err)
+	}
+
+	if !l.Allowed("app", Publish, "events") {
+		t.Error("Added rule should be enforced")
+	}
+
+	if removed, ok := l.Remove(rule.Id); !ok || removed.Id != rule.Id || l.Allowed("app", Publish, "events") || len(l.Rules()) != 0 {
+		t.Error("Removed rule should no longer be enforced")
+	}
+
+	if _, err := l.Add(Rule{Subject: "app", Topics: []string{"events"}, Actions: []Action{"delete"}}); err == nil {
+		t.Error("Rule with an unknown action should be rejected")
+	}
+
+	if _, err := l.Add(Rule{Subject: "app", Topics: []string{"events.#.created"}, Actions: []Action{Publish}}); err == nil {
+		t.Error("Rule with an invalid pattern should be rejected")
+	}
+
+	if !l.IsAdmin("root") || l.IsAdmin("app") {
+		t.Error("Only configured subjects should be admins")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	l, err := NewList(config.ACL{})
+	if l != nil || err != nil {
+		t.Fatal("Disabled acls should have no list")
+	}
+
+	if !l.Allowed("anyone", Publish, "topic") || !l.IsAdmin("anyone") {
+		t.Error("Nil list should allow everything")
+	}
+}
+
+func TestNamespacedRules(t *testing.T) {
+	l, err := NewList(config.ACL{
+		Enabled: true,
+		Rules: []config.ACLRule{
+			{Namespace: "payments", Subject: "*", Topics: []string{"#"}, Actions: []string{"publish"}},
+			{Subject: "*", Topics: []string{"orders"}, Actions: []string{"publish"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !l.Allowed("app", Publish, "payments/orders.eu") || !l.Allowed("app", Publish, "orders") {
+		t.Error("Rules should allow the topics of their namespace")
+	}
+
+	if l.Allowed("app", Publish, "billing/orders") || l.Allowed("app", Publish, "invoices") {
+		t.Error("Rules should not allow topics of other namespaces")
+	}
+}
@@ -0,0 +1,341 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+)
+
+type Action string
+
+const (
+	Subscribe       Action = "subscribe"
+	Unsubscribe     Action = "unsubscribe"
+	TopicCreate     Action = "topic.create"
+	TopicDelete     Action = "topic.delete"
+	ConfigReload    Action = "config.reload"
+	ACLAdd          Action = "acl.add"
+	ACLRemove       Action = "acl.remove"
+	ScheduledCancel Action = "scheduled.cancel"
+	Promote         Action = "replication.promote"
+	SnapshotRestore Action = "snapshot.restore"
+	// SystemPrincipal records the changes made by the broker itself, e.g. the cleanup of expired topics
+	SystemPrincipal = "system"
+)
+
+// Event is one entry of the audit trail, Before and After are the state of the Target around the change.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Action    Action    `json:"action"`
+	Principal string    `json:"principal"`
+	IP        string    `json:"ip,omitempty"`
+	Target    string    `json:"target"`
+	Before    any       `json:"before,omitempty"`
+	After     any       `json:"after,omitempty"`
+}
+
+// Query selects events of the audit trail, zero fields match every event.
+type Query struct {
+	Action    Action
+	Principal string
+	Target    string
+	Since     time.Time
+	Until     time.Time
+	// Limit keeps the most recent events, 0 uses constants.DefaultAuditQueryLimit
+	Limit int
+}
+
+func (q Query) matches(e Event) bool {
+	switch {
+	case q.Action != "" && e.Action != q.Action:
+		return false
+	case q.Principal != "" && e.Principal != q.Principal:
+		return false
+	case q.Target != "" && e.Target != q.Target:
+		return false
+	case !q.Since.IsZero() && e.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && e.Time.After(q.Until):
+		return false
+	}
+
+	return true
+}
+
+// Log appends events as JSON lines to a file, the file is rotated to file.1, file.2... when it
+// reaches the max size.
+type Log struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	// now is overridden in tests
+	now func() time.Time
+
+	// queue holds the events of RecordAsync until the writer goroutine appends them, writing counts
+	// the ones it is appending, queued signals both changes
+	queueMu sync.Mutex
+	queued  *sync.Cond
+	queue   []Event
+	writing int
+	closed  bool
+	done    chan struct{}
+}
+
+// New opens the audit log, it returns nil when no file is configured. A nil Log records nothing.
+func New(cfg config.Audit) (*Log, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+
+	l := &Log{
+		path:       cfg.File,
+		maxSize:    cfg.MaxSizeBytes,
+		maxBackups: cfg.MaxBackups,
+		now:        time.Now,
+		done:       make(chan struct{}),
+	}
+	l.queued = sync.NewCond(&l.queueMu)
+	if l.maxBackups <= 0 {
+		l.maxBackups = constants.DefaultAuditBackups
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	go l.write()
+
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", l.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log %s: %w", l.path, err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+
+	return nil
+}
+
+// Record appends the event, its time is set when missing.
+func (l *Log) Record(e Event) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = l.now().UTC()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	line = append(line, '\n')
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+
+	return nil
+}
+
+// RecordAsync queues the event to be appended by a background goroutine so callers holding locks
+// don't wait for the file, its time is set when queued. Write failures are logged.
+func (l *Log) RecordAsync(e Event) {
+	if l == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = l.now().UTC()
+	}
+
+	l.queueMu.Lock()
+	l.queue = append(l.queue, e)
+	l.queued.Broadcast()
+	l.queueMu.Unlock()
+}
+
+// write appends the queued events until the log is closed and the queue drained.
+func (l *Log) write() {
+	defer close(l.done)
+
+	l.queueMu.Lock()
+	for {
+		for len(l.queue) == 0 && !l.closed {
+			l.queued.Wait()
+		}
+		if len(l.queue) == 0 {
+			l.queueMu.Unlock()
+
+			return
+		}
+
+		events := l.queue
+		l.queue = nil
+		l.writing = len(events)
+		l.queueMu.Unlock()
+
+		for _, e := range events {
+			if err := l.Record(e); err != nil {
+				log.Printf("failed to record audit event %s on %s [ERROR]: %s", e.Action, e.Target, err)
+			}
+		}
+
+		l.queueMu.Lock()
+		l.writing = 0
+		l.queued.Broadcast()
+	}
+}
+
+// flush waits until the events queued so far are appended.
+func (l *Log) flush() {
+	l.queueMu.Lock()
+	defer l.queueMu.Unlock()
+
+	for len(l.queue) > 0 || l.writing > 0 {
+		l.queued.Wait()
+	}
+}
+
+// rotate shifts the backups, dropping the oldest one, and starts a new file, the caller must hold l.mu.
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log %s: %w", l.path, err)
+	}
+
+	os.Remove(l.backup(l.maxBackups))
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(l.backup(i), l.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate audit log %s: %w", l.path, err)
+		}
+	}
+
+	if err := os.Rename(l.path, l.backup(1)); err != nil {
+		return fmt.Errorf("failed to rotate audit log %s: %w", l.path, err)
+	}
+
+	return l.open()
+}
+
+func (l *Log) backup(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+// Query returns the matching events oldest first, reading the rotated files too.
+func (l *Log) Query(q Query) ([]Event, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = constants.DefaultAuditQueryLimit
+	}
+
+	// the queued events are part of the trail already
+	l.flush()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := make([]Event, 0)
+	for i := l.maxBackups; i >= 0; i-- {
+		path := l.path
+		if i > 0 {
+			path = l.backup(i)
+		}
+
+		matched, err := readEvents(path, q)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, matched...)
+	}
+
+	if len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+
+	return events, nil
+}
+
+func readEvents(path string, q Query) ([]Event, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// a partially written line is skipped instead of hiding the rest of the trail
+			continue
+		}
+
+		if q.matches(e) {
+			events = append(events, e)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+
+	return events, nil
+}
+
+// Close appends the queued events and closes the audit log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.queueMu.Lock()
+	l.closed = true
+	l.queued.Broadcast()
+	l.queueMu.Unlock()
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
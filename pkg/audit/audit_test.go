@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+)
+
+func TestRecordAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(config.Audit{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return start }
+	events := []Event{
+		{Action: Subscribe, Principal: "alice", IP: "10.0.0.1", Target: "http://sub", After: []string{"orders"}},
+		{Action: Unsubscribe, Principal: "bob", Target: "http://sub", Before: []string{"orders"}},
+		{Action: TopicDelete, Principal: "alice", Target: "orders", Time: start.Add(time.Hour)},
+	}
+	for _, e := range events {
+		if err := l.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := l.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Action != Subscribe || all[2].Action != TopicDelete {
+		t.Fatalf("Expected the 3 events oldest first, got %+v", all)
+	}
+	if !all[0].Time.Equal(start) || all[0].IP != "10.0.0.1" {
+		t.Errorf("Expected the time and ip to be recorded, got %+v", all[0])
+	}
+
+	alice, _ := l.Query(Query{Principal: "alice"})
+	if len(alice) != 2 {
+		t.Errorf("Expected 2 events of alice, got %d", len(alice))
+	}
+
+	deletes, _ := l.Query(Query{Action: TopicDelete, Target: "orders"})
+	if len(deletes) != 1 {
+		t.Errorf("Expected 1 topic deletion, got %d", len(deletes))
+	}
+
+	recent, _ := l.Query(Query{Since: start.Add(time.Minute)})
+	if len(recent) != 1 || recent[0].Action != TopicDelete {
+		t.Errorf("Expected only the events since the time, got %+v", recent)
+	}
+
+	last, _ := l.Query(Query{Limit: 1})
+	if len(last) != 1 || last[0].Action != TopicDelete {
+		t.Errorf("Expected the most recent event, got %+v", last)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(config.Audit{File: path, MaxSizeBytes: 200, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := l.Record(Event{Action: Subscribe, Principal: "alice", Target: "http://sub"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("Expected %s to be rotated at 200 bytes, got %d", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected only 2 backups to be kept")
+	}
+
+	events, err := l.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 || len(events) >= 10 {
+		t.Errorf("Expected the events of the kept files only, got %d", len(events))
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(config.Audit{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(Event{Action: ACLAdd, Principal: "admin", Target: "rule"})
+	l.Close()
+
+	l, err = New(config.Audit{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Record(Event{Action: ACLRemove, Principal: "admin", Target: "rule"})
+
+	events, _ := l.Query(Query{})
+	if len(events) != 2 {
+		t.Errorf("Expected the trail to be appended to after a restart, got %d events", len(events))
+	}
+}
+
+func TestRecordAsync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(config.Audit{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"orders", "payments", "refunds"} {
+		l.RecordAsync(Event{Action: TopicCreate, Principal: "alice", Target: target})
+	}
+
+	events, _ := l.Query(Query{})
+	if len(events) != 3 || events[0].Target != "orders" || events[2].Target != "refunds" {
+		t.Fatalf("Expected the queued events in order, got %+v", events)
+	}
+	if events[0].Time.IsZero() {
+		t.Error("Expected the time to be set when the event is queued")
+	}
+
+	l.RecordAsync(Event{Action: TopicDelete, Principal: "alice", Target: "orders"})
+	l.Close()
+
+	l, err = New(config.Audit{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	events, _ = l.Query(Query{})
+	if len(events) != 4 {
+		t.Errorf("Expected the queued events to be written on close, got %d events", len(events))
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	l, err := New(config.Audit{})
+	if err != nil || l != nil {
+		t.Fatal("Expected no audit log without a file")
+	}
+
+	if l.Record(Event{Action: Subscribe}) != nil {
+		t.Error("Expected a nil log to record nothing")
+	}
+	l.RecordAsync(Event{Action: Subscribe})
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+)
+
+var (
+	// ErrNoCredentials is returned when the request carries no credentials for the authenticator
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned when the request credentials are rejected
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Subject string
+	// Method is how the caller authenticated, MethodAPIKey or MethodJWT
+	Method string
+	// Claims are the claims of the JWT the caller authenticated with
+	Claims *Claims
+}
+
+type Authenticator interface {
+	// Authenticate returns the caller of the request, or ErrNoCredentials if the request
+	// doesn't carry the kind of credentials the authenticator checks.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Chain tries each authenticator in order until one finds credentials in the request.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Identity, error) {
+	for _, a := range c {
+		identity, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+
+		return identity, err
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// New builds the authenticators enabled in the config, it returns nil when authentication is disabled.
+func New(cfg config.Auth) (Authenticator, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	var chain Chain
+	if len(cfg.APIKeys) > 0 {
+		keys, err := NewAPIKeyAuthenticator(cfg.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+
+	if cfg.JWT.KeyFile != "" {
+		jwt, err := NewJWTAuthenticator(cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, jwt)
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("authentication is enabled but neither api keys nor a jwt key file are configured")
+	}
+
+	return chain, nil
+}
+
+// APIKeyAuthenticator authenticates requests with a static key sent in the X-Flux-Api-Key header.
+type APIKeyAuthenticator struct {
+	// keys maps the sha256 of each key to its subject so the keys are not compared byte by byte
+	keys map[[sha256.Size]byte]string
+}
+
+func NewAPIKeyAuthenticator(keys []config.APIKey) (*APIKeyAuthenticator, error) {
+	a := &APIKeyAuthenticator{keys: make(map[[sha256.Size]byte]string, len(keys))}
+	for i, k := range keys {
+		if k.Key == "" || k.Subject == "" {
+			return nil, fmt.Errorf("api key %d must have a key and a subject", i)
+		}
+		a.keys[sha256.Sum256([]byte(k.Key))] = k.Subject
+	}
+
+	return a, nil
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	key := r.Header.Get(constants.APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	subject, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown api key", ErrInvalidCredentials)
+	}
+
+	return &Identity{Subject: subject, Method: MethodAPIKey}, nil
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+)
+
+func newRequest(header string, value string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, "/publish", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+
+	return r
+}
+
+func writeKeyFile(t *testing.T, key string) string {
+	path := filepath.Join(t.TempDir(), "jwt.key")
+	if err := os.WriteFile(path, []byte(key+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	a, err := NewAPIKeyAuthenticator([]config.APIKey{{Key: "secret", Subject: "orders-service"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := a.Authenticate(newRequest(constants.APIKeyHeader, "secret"))
+	if err != nil || identity.Subject != "orders-service" || identity.Method != MethodAPIKey {
+		t.Errorf("Known api key should authenticate its subject, got %v %v", identity, err)
+	}
+
+	if _, err := a.Authenticate(newRequest(constants.APIKeyHeader, "wrong")); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Unknown api key should be rejected, got %v", err)
+	}
+
+	if _, err := a.Authenticate(newRequest("", "")); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Request without api key should have no credentials, got %v", err)
+	}
+
+	if _, err := NewAPIKeyAuthenticator([]config.APIKey{{Key: "secret"}}); err == nil {
+		t.Error("Api key without subject should be rejected")
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	a, err := NewJWTAuthenticator(config.JWT{KeyFile: writeKeyFile(t, "jwt-secret"), Issuer: "issuer", Audience: "flux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	valid := Claims{Subject: "billing", Issuer: "issuer", Audience: Audience{"flux"}, ExpiresAt: now.Add(time.Minute).Unix()}
+	token, _ := NewToken([]byte("jwt-secret"), valid)
+
+	identity, err := a.Authenticate(newRequest("Authorization", "Bearer "+token))
+	if err != nil || identity.Subject != "billing" || identity.Method != MethodJWT {
+		t.Errorf("Valid token should authenticate its subject, got %v %v", identity, err)
+	}
+
+	invalid := map[string]Claims{
+		"expired":        {Subject: "billing", Issuer: "issuer", Audience: Audience{"flux"}, ExpiresAt: now.Unix()},
+		"not yet valid":  {Subject: "billing", Issuer: "issuer", Audience: Audience{"flux"}, NotBefore: now.Add(time.Minute).Unix()},
+		"wrong issuer":   {Subject: "billing", Issuer: "other", Audience: Audience{"flux"}},
+		"wrong audience": {Subject: "billing", Issuer: "issuer", Audience: Audience{"other"}},
+		"no subject":     {Issuer: "issuer", Audience: Audience{"flux"}},
+	}
+	for name, claims := range invalid {
+		token, _ := NewToken([]byte("jwt-secret"), claims)
+		if _, err := a.Authenticate(newRequest("Authorization", "Bearer "+token)); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Token %s should be rejected, got %v", name, err)
+		}
+	}
+
+	forged, _ := NewToken([]byte("other-secret"), valid)
+	if _, err := a.Authenticate(newRequest("Authorization", "Bearer "+forged)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Token signed with another key should be rejected, got %v", err)
+	}
+
+	// eyJhbGciOiJub25lIn0 is {"alg":"none"}
+	unsigned := "eyJhbGciOiJub25lIn0.eyJzdWIiOiJiaWxsaW5nIn0."
+	if _, err := a.Authenticate(newRequest("Authorization", "Bearer "+unsigned)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Unsigned token should be rejected, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	a, err := New(config.Auth{})
+	if a != nil || err != nil {
+		t.Error("Disabled authentication should have no authenticator")
+	}
+
+	if _, err := New(config.Auth{Enabled: true}); err == nil {
+		t.Error("Enabled authentication without credentials should be rejected")
+	}
+
+	a, err = New(config.Auth{
+		Enabled: true,
+		APIKeys: []config.APIKey{{Key: "secret", Subject: "orders-service"}},
+		JWT:     config.JWT{KeyFile: writeKeyFile(t, "jwt-secret")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := NewToken([]byte("jwt-secret"), Claims{Subject: "billing"})
+	identity, err := a.Authenticate(newRequest("Authorization", "Bearer "+token))
+	if err != nil || identity.Subject != "billing" {
+		t.Errorf("Chain should fall through to the jwt authenticator, got %v %v", identity, err)
+	}
+
+	if _, err := a.Authenticate(newRequest("", "")); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Request without credentials should be rejected, got %v", err)
+	}
+}
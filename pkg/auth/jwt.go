@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+)
+
+var algorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// Claims are the registered JWT claims checked by the broker.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+// Audience is the aud claim which is either a single string or a list of strings.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("invalid aud claim: %w", err)
+	}
+	*a = list
+
+	return nil
+}
+
+func (a Audience) contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+
+	return false
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// JWTAuthenticator authenticates requests with HMAC signed JWTs sent as bearer tokens.
+type JWTAuthenticator struct {
+	key      []byte
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewJWTAuthenticator reads the HMAC key from the configured key file, surrounding whitespace is ignored.
+func NewJWTAuthenticator(cfg config.JWT) (*JWTAuthenticator, error) {
+	key, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading jwt key file: %w", err)
+	}
+
+	key = bytes.TrimSpace(key)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("jwt key file %s is empty", cfg.KeyFile)
+	}
+
+	return &JWTAuthenticator{
+		key:      key,
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		now:      time.Now,
+	}, nil
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := a.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{Subject: claims.Subject, Method: MethodJWT, Claims: claims}, nil
+}
+
+// Verify checks the token signature and claims and returns its claims.
+func (a *JWTAuthenticator) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+
+	// the algorithm must be one of the HMAC ones, in particular "none" is rejected
+	newHash, ok := algorithms[h.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidCredentials, h.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidCredentials)
+	}
+
+	if !hmac.Equal(signature, sign(newHash, a.key, parts[0]+"."+parts[1])) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrInvalidCredentials)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := a.now().Unix()
+	switch {
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing sub claim", ErrInvalidCredentials)
+	case claims.ExpiresAt != 0 && now >= claims.ExpiresAt:
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidCredentials)
+	case claims.NotBefore != 0 && now < claims.NotBefore:
+		return nil, fmt.Errorf("%w: token not valid yet", ErrInvalidCredentials)
+	case a.issuer != "" && claims.Issuer != a.issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidCredentials, claims.Issuer)
+	case a.audience != "" && !claims.Audience.contains(a.audience):
+		return nil, fmt.Errorf("%w: token not issued for audience %q", ErrInvalidCredentials, a.audience)
+	}
+
+	return &claims, nil
+}
+
+// NewToken signs the claims with the key using HS256.
+func NewToken(key []byte, claims Claims) (string, error) {
+	h, err := json.Marshal(header{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sign(sha256.New, key, unsigned)), nil
+}
+
+func sign(newHash func() hash.Hash, key []byte, unsigned string) []byte {
+	mac := hmac.New(newHash, key)
+	mac.Write([]byte(unsigned))
+
+	return mac.Sum(nil)
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+
+	return nil
+}
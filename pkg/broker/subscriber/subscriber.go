@@ -8,12 +8,16 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/filter"
 	"github.com/NamanBalaji/flux/pkg/message"
 	"github.com/NamanBalaji/flux/pkg/queue"
 	"github.com/NamanBalaji/flux/pkg/request"
+	"github.com/NamanBalaji/flux/pkg/signature"
 )
 
 type Subscriber struct {
@@ -23,25 +27,67 @@ type Subscriber struct {
 	IsActive     bool
 	CancelFunc   context.CancelFunc
 	LastActive   time.Time
+	Options      Options
+	// OnExpire is called with messages dropped from the queue because their TTL expired
+	OnExpire func(msg *message.Message, topicName string)
+	// OnAck is called with the messages removed from the queue, delivered or expired
+	OnAck func(msg *message.Message, topicName string, addr string)
+	// Expired counts the messages dropped because their TTL expired
+	Expired atomic.Uint64
+	// Transport sends the pushes, e.g. with the broker's client certificate, nil uses the default transport
+	Transport http.RoundTripper
+	// LastAckedId is the id of the last message removed from the queue, Delivered counts them and
+	// LastAckedAt is when that message was added to the topic
+	LastAckedId string
+	Delivered   uint64
+	LastAckedAt time.Time
+	// settings replace the subscriber settings HandleQueue was started with once the config is reloaded
+	settings *config.Subscriber
+}
+
+// Options are the delivery settings chosen by the subscriber when subscribing.
+type Options struct {
+	// Raw pushes the payload as the request body and the message metadata as HTTP headers
+	Raw bool
+	// Filter restricts the messages delivered to the subscriber, nil accepts every message
+	Filter *filter.Filter
+	// Secret signs the pushed messages, they are not signed when empty
+	Secret []byte
+	// Owner is the principal which registered the subscription
+	Owner string
 }
 
 type MessageResponse struct {
 	Id      string `json:"id"`
-	Payload string `json:"payload"`
+	Payload []byte `json:"payload"`
 	Topic   string `json:"topic"`
 }
 
 func NewSubscriber(addr string) *Subscriber {
+	return NewSubscriberWithOptions(addr, Options{})
+}
+
+func NewSubscriberWithOptions(addr string, opts Options) *Subscriber {
 	msgQueue := queue.NewQueue()
 
 	return &Subscriber{
 		Addr:         addr,
+		Options:      opts,
 		MessageQueue: msgQueue,
 		IsActive:     true,
 		LastActive:   time.Now(),
 	}
 }
 
+// Accepts reports whether the message passes the subscriber's filter.
+func (s *Subscriber) Accepts(msg *message.Message) bool {
+	s.Lock.Lock()
+	f := s.Options.Filter
+	s.Lock.Unlock()
+
+	return f.Match(msg)
+}
+
 func (s *Subscriber) AddMessage(msg *message.Message) {
 	s.MessageQueue.Enqueue(msg)
 	log.Printf("added messgae with id %s to subscriber[address: %s] queue \n", msg.Id, s.Addr)
@@ -66,14 +112,29 @@ func (s *Subscriber) HandleQueue(ctx context.Context, cfg config.Config, topicNa
 			s.Lock.Unlock()
 
 			msg := s.MessageQueue.Peek()
+			if msg.Expired(time.Now()) {
+				s.expire(msg, topicName)
+
+				continue
+			}
+
+			// the settings of a reloaded config apply from the message about to be pushed
+			s.Lock.Lock()
+			if s.settings != nil {
+				cfg.Subscriber = *s.settings
+			}
+			s.Lock.Unlock()
+
 			err := s.pushMessage(ctx, cfg, msg, topicName)
 			if err == nil {
 				s.Lock.Lock()
 				s.LastActive = time.Now()
 				s.Lock.Unlock()
 
-				msg = s.MessageQueue.Dequeue()
+				// remove the pushed message, a higher priority one may have been enqueued since the peek
+				s.MessageQueue.Remove(msg)
 				msg.Ack(s.Addr)
+				s.acked(msg, topicName)
 			} else {
 				s.Lock.Lock()
 				s.IsActive = false
@@ -85,32 +146,141 @@ func (s *Subscriber) HandleQueue(ctx context.Context, cfg config.Config, topicNa
 	}
 }
 
-func (s *Subscriber) pushMessage(ctx context.Context, cfg config.Config, msg *message.Message, topicName string) error {
+// expire drops the expired message at the head of the queue instead of delivering it.
+func (s *Subscriber) expire(msg *message.Message, topicName string) {
+	s.MessageQueue.Remove(msg)
+	msg.Ack(s.Addr)
+	s.acked(msg, topicName)
+	s.Expired.Add(1)
+
+	log.Printf("Message with id %s expired before delivery to subscriber[Address: %s] subscribed to topic %s \n", msg.Id, s.Addr, topicName)
+
+	s.Lock.Lock()
+	onExpire := s.OnExpire
+	s.Lock.Unlock()
+
+	if onExpire != nil {
+		onExpire(msg, topicName)
+	}
+}
+
+// Reconfigure replaces the push settings and the expiry callback of the subscriber after a config
+// reload, the delivery picks them up with the next message.
+func (s *Subscriber) Reconfigure(settings config.Subscriber, onExpire func(msg *message.Message, topicName string)) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	s.settings = &settings
+	s.OnExpire = onExpire
+}
+
+func (s *Subscriber) acked(msg *message.Message, topicName string) {
+	s.Lock.Lock()
+	s.LastAckedId = msg.Id
+	s.LastAckedAt = msg.AddedAt
+	s.Delivered++
+	s.Lock.Unlock()
+
+	if s.OnAck != nil {
+		s.OnAck(msg, topicName, s.Addr)
+	}
+}
+
+// AckById removes the message with the id from the queue as if it was delivered, it reports
+// whether the message was queued.
+func (s *Subscriber) AckById(id string) bool {
+	for i := 0; i < s.MessageQueue.Len(); i++ {
+		msg := s.MessageQueue.GetAt(i)
+		if msg != nil && msg.Id == id && s.MessageQueue.Remove(msg) {
+			msg.Ack(s.Addr)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Subscriber) buildPushRequest(cfg config.Config, msg *message.Message, topicName string) ([]byte, http.Header, error) {
+	s.Lock.Lock()
+	raw := s.Options.Raw
+	s.Lock.Unlock()
+
+	header := http.Header{}
+	if raw || cfg.Subscriber.HeadersAsHTTP {
+		for k, v := range msg.Headers {
+			header.Set(constants.HeaderPrefix+k, v)
+		}
+	}
+
+	if raw {
+		contentType := msg.ContentType
+		if contentType == "" {
+			contentType = constants.OctetStream
+		}
+		header.Set("Content-Type", contentType)
+		header.Set(constants.IdHeader, msg.Id)
+		header.Set(constants.TopicHeader, topicName)
+		header.Set(constants.PublishedAtHeader, msg.AddedAt.UTC().Format(time.RFC3339Nano))
+		if msg.ReplyTo != "" {
+			header.Set(constants.ReplyToHeader, msg.ReplyTo)
+		}
+		if msg.CorrelationId != "" {
+			header.Set(constants.CorrelationHeader, msg.CorrelationId)
+		}
+
+		return msg.Payload, header, nil
+	}
+
 	res := request.PollMessage{
-		Id:      msg.Id,
-		Payload: msg.Payload,
-		Topic:   topicName,
+		Id:            msg.Id,
+		Payload:       msg.Payload,
+		ContentType:   msg.ContentType,
+		Topic:         topicName,
+		Headers:       msg.Headers,
+		ReplyTo:       msg.ReplyTo,
+		CorrelationId: msg.CorrelationId,
+		PublishedAt:   msg.AddedAt,
 	}
 
 	jsonBody, err := json.Marshal(res)
 	if err != nil {
-		return fmt.Errorf("error marshaling message: %v", err)
+		return nil, nil, fmt.Errorf("error marshaling message: %v", err)
+	}
+	header.Set("Content-Type", "application/json")
+
+	return jsonBody, header, nil
+}
+
+func (s *Subscriber) pushMessage(ctx context.Context, cfg config.Config, msg *message.Message, topicName string) error {
+	body, header, err := s.buildPushRequest(cfg, msg, topicName)
+	if err != nil {
+		return err
 	}
 
 	client := &http.Client{
-		Timeout: time.Duration(cfg.Subscriber.Timeout) * time.Second,
+		Timeout:   time.Duration(cfg.Subscriber.Timeout),
+		Transport: s.Transport,
 	}
 
+	s.Lock.Lock()
+	secret := s.Options.Secret
+	s.Lock.Unlock()
+
 	for i := 0; i < cfg.Subscriber.RetryCount; i++ {
 		if err := ctx.Err(); err != nil {
 			return fmt.Errorf("context canceled: %v", err)
 		}
 
-		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/poll", s.Addr), bytes.NewBuffer(jsonBody))
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/poll", s.Addr), bytes.NewBuffer(body))
 		if err != nil {
 			return fmt.Errorf("error creating request: %v", err)
 		}
-		req.Header.Set("Content-Type", "application/json")
+		req.Header = header.Clone()
+		if len(secret) > 0 {
+			// every attempt is signed again so retries are not rejected as replays
+			signature.SetHeaders(req.Header, secret, time.Now(), body)
+		}
 
 		log.Printf("Sending message with id %s to subscriber[Address: %s] subscribed to topic %s \n", msg.Id, s.Addr, topicName)
 		resp, err := client.Do(req)
@@ -126,7 +296,7 @@ func (s *Subscriber) pushMessage(ctx context.Context, cfg config.Config, msg *me
 			select {
 			case <-ctx.Done():
 				return fmt.Errorf("context canceled: %v", ctx.Err())
-			case <-time.After(time.Duration(cfg.Subscriber.Timeout) * time.Second):
+			case <-time.After(time.Duration(cfg.Subscriber.Timeout)):
 				log.Printf("retrying sending message request to the subscriber[Address: %s] subscribed to topic %s \n", s.Addr, topicName)
 			}
 		}
@@ -1,8 +1,10 @@
 package subscriber
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -11,6 +13,7 @@ import (
 	"github.com/NamanBalaji/flux/pkg/config"
 	"github.com/NamanBalaji/flux/pkg/message"
 	"github.com/NamanBalaji/flux/pkg/request"
+	"github.com/NamanBalaji/flux/pkg/signature"
 )
 
 func setupMockServer() *httptest.Server {
@@ -32,7 +35,7 @@ func setupMockServer() *httptest.Server {
 
 func TestAddMessage(t *testing.T) {
 	sub := NewSubscriber("http://example.com")
-	msg := &message.Message{Id: "1", Payload: "data"}
+	msg := &message.Message{Id: "1", Payload: []byte("data")}
 	sub.AddMessage(msg)
 
 	if sub.MessageQueue.Len() != 1 {
@@ -45,7 +48,7 @@ func TestHandleQueue(t *testing.T) {
 	defer server.Close()
 
 	cfg := config.Config{
-		Subscriber: config.Subscriber{Timeout: 1, RetryCount: 1},
+		Subscriber: config.Subscriber{Timeout: config.Seconds(1), RetryCount: 1},
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -53,7 +56,7 @@ func TestHandleQueue(t *testing.T) {
 
 	sub := NewSubscriber(server.URL)
 	sub.IsActive = true
-	msg := message.NewMessage("1", "data")
+	msg := message.NewMessage("1", []byte("data"))
 	sub.AddMessage(msg)
 	msg.AddSubscriber(server.URL)
 
@@ -78,7 +81,7 @@ func TestHandleQueue_PushFail(t *testing.T) {
 	defer server.Close()
 
 	cfg := config.Config{
-		Subscriber: config.Subscriber{Timeout: 1, RetryCount: 1},
+		Subscriber: config.Subscriber{Timeout: config.Seconds(1), RetryCount: 1},
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -86,7 +89,7 @@ func TestHandleQueue_PushFail(t *testing.T) {
 
 	sub := NewSubscriber(server.URL)
 	sub.IsActive = true
-	msg := message.NewMessage("fail", "data")
+	msg := message.NewMessage("fail", []byte("data"))
 	sub.AddMessage(msg)
 	msg.AddSubscriber(server.URL)
 
@@ -114,12 +117,12 @@ func TestPushMessageFailure(t *testing.T) {
 	defer cancel()
 
 	cfg := config.Config{
-		Subscriber: config.Subscriber{Timeout: 50, RetryCount: 2},
+		Subscriber: config.Subscriber{Timeout: config.Seconds(50), RetryCount: 2},
 	}
 
 	sub := NewSubscriber(server.URL)
 	sub.IsActive = true
-	msg := message.NewMessage("fail", "data")
+	msg := message.NewMessage("fail", []byte("data"))
 	sub.AddMessage(msg)
 	msg.AddSubscriber(server.URL)
 
@@ -128,3 +131,206 @@ func TestPushMessageFailure(t *testing.T) {
 		t.Errorf("Expected error from pushMessage, got nil")
 	}
 }
+
+func TestPushMessageHeaders(t *testing.T) {
+	var reqBody request.PollMessage
+	var httpHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpHeader = r.Header.Get("X-Flux-Header-Correlation-Id")
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+			http.Error(w, "Invalid request", 400)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.Config{
+		Subscriber: config.Subscriber{Timeout: config.Seconds(1), RetryCount: 1, HeadersAsHTTP: true},
+	}
+
+	sub := NewSubscriber(server.URL)
+	msg := message.NewMessage("1", []byte("data"))
+	msg.SetHeaders(map[string]string{"correlation-id": "abc"})
+
+	err := sub.pushMessage(context.Background(), cfg, msg, "test-topic")
+	if err != nil {
+		t.Fatalf("Expected no error from pushMessage, got %s", err)
+	}
+
+	if reqBody.Headers["correlation-id"] != "abc" {
+		t.Errorf("Expected header in body, got %v", reqBody.Headers)
+	}
+
+	if httpHeader != "abc" {
+		t.Errorf("Expected header to be sent as http header, got %s", httpHeader)
+	}
+}
+
+func TestPushMessageRaw(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.Config{
+		Subscriber: config.Subscriber{Timeout: config.Seconds(1), RetryCount: 1},
+	}
+
+	sub := NewSubscriberWithOptions(server.URL, Options{Raw: true})
+	msg := message.NewMessage("1", []byte{0x00, 0x01, 0xff})
+	msg.ContentType = "application/x-protobuf"
+	msg.SetHeaders(map[string]string{"schema": "v2"})
+
+	err := sub.pushMessage(context.Background(), cfg, msg, "test-topic")
+	if err != nil {
+		t.Fatalf("Expected no error from pushMessage, got %s", err)
+	}
+
+	if !bytes.Equal(body, msg.Payload) {
+		t.Errorf("Expected raw payload as body, got %v", body)
+	}
+
+	if header.Get("Content-Type") != "application/x-protobuf" || header.Get("X-Flux-Id") != "1" || header.Get("X-Flux-Topic") != "test-topic" {
+		t.Errorf("Expected message metadata in http headers, got %v", header)
+	}
+
+	if header.Get("X-Flux-Header-Schema") != "v2" {
+		t.Errorf("Expected message headers as http headers, got %v", header)
+	}
+}
+
+func TestPushMessageSigned(t *testing.T) {
+	verifier := signature.NewVerifier([]byte("secret"), time.Minute)
+	var verifyErr error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verifyErr = verifier.Verify(r.Header, body, time.Now())
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.Config{
+		Subscriber: config.Subscriber{Timeout: config.Seconds(1), RetryCount: 1},
+	}
+
+	sub := NewSubscriberWithOptions(server.URL, Options{Secret: []byte("secret")})
+	msg := message.NewMessage("1", []byte("data"))
+
+	for i := 0; i < 2; i++ {
+		err := sub.pushMessage(context.Background(), cfg, msg, "test-topic")
+		if err != nil {
+			t.Fatalf("Expected no error from pushMessage, got %s", err)
+		}
+
+		if verifyErr != nil {
+			t.Errorf("Expected push %d to be signed with the subscription secret, got %s", i, verifyErr)
+		}
+	}
+}
+
+func TestHandleQueue_SkipExpired(t *testing.T) {
+	pushed := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody request.PollMessage
+		_ = json.NewDecoder(r.Body).Decode(&reqBody)
+		pushed <- reqBody.Id
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.Config{
+		Subscriber: config.Subscriber{Timeout: config.Seconds(1), RetryCount: 1},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	expiredChan := make(chan string, 1)
+	sub := NewSubscriber(server.URL)
+	sub.OnExpire = func(msg *message.Message, topicName string) {
+		expiredChan <- msg.Id
+	}
+
+	expired := message.NewMessage("expired", []byte("data"))
+	expired.TTL = time.Millisecond
+	expired.AddedAt = time.Now().Add(-time.Second)
+	expired.AddSubscriber(server.URL)
+	fresh := message.NewMessage("fresh", []byte("data"))
+	fresh.AddSubscriber(server.URL)
+
+	sub.AddMessage(expired)
+	sub.AddMessage(fresh)
+
+	go sub.HandleQueue(ctx, cfg, "test-topic")
+
+	select {
+	case id := <-pushed:
+		if id != "fresh" {
+			t.Errorf("Expected only the fresh message to be pushed, got %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Fresh message was not pushed")
+	}
+
+	if id := <-expiredChan; id != "expired" {
+		t.Errorf("Expected OnExpire to be called with the expired message, got %s", id)
+	}
+
+	if sub.Expired.Load() != 1 {
+		t.Errorf("Expected 1 expired message, got %d", sub.Expired.Load())
+	}
+
+	if !expired.Delivered[server.URL] {
+		t.Error("Expired message should not wait for the subscriber ack")
+	}
+}
+
+func TestHandleQueue_Reconfigure(t *testing.T) {
+	headers := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Get("X-Flux-Header-Type")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.Config{
+		Subscriber: config.Subscriber{Timeout: config.Seconds(1), RetryCount: 1},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub := NewSubscriber(server.URL)
+	go sub.HandleQueue(ctx, cfg, "test-topic")
+
+	expired := false
+	sub.Reconfigure(config.Subscriber{Timeout: config.Seconds(1), RetryCount: 1, HeadersAsHTTP: true}, func(msg *message.Message, topicName string) {
+		expired = true
+	})
+
+	msg := message.NewMessage("1", []byte("data"))
+	msg.SetHeaders(map[string]string{"Type": "created"})
+	sub.AddMessage(msg)
+
+	select {
+	case header := <-headers:
+		if header != "created" {
+			t.Errorf("Expected the reloaded settings to send the headers as HTTP headers, got %q", header)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the message to be pushed")
+	}
+
+	sub.Lock.Lock()
+	onExpire := sub.OnExpire
+	sub.Lock.Unlock()
+	onExpire(msg, "test-topic")
+	if !expired {
+		t.Error("Expected the reloaded expiry callback to be used")
+	}
+}
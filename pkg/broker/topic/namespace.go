@@ -0,0 +1,45 @@
+package topic
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultNamespace holds the topics addressed without a namespace, their names are not qualified
+	DefaultNamespace = "default"
+	// NamespaceSeparator separates the namespace from the topic name in qualified names, e.g. payments/orders.created
+	NamespaceSeparator = "/"
+)
+
+// QualifiedName returns the name identifying the topic across namespaces.
+func QualifiedName(namespace string, name string) string {
+	if namespace == "" || namespace == DefaultNamespace {
+		return name
+	}
+
+	return namespace + NamespaceSeparator + name
+}
+
+// SplitName returns the namespace and the name of the topic within the namespace.
+func SplitName(qualified string) (string, string) {
+	namespace, name, ok := strings.Cut(qualified, NamespaceSeparator)
+	if !ok {
+		return DefaultNamespace, qualified
+	}
+
+	return namespace, name
+}
+
+// ValidateNamespace checks the namespace can be used in qualified names and api paths.
+func ValidateNamespace(namespace string) error {
+	if namespace == "" {
+		return fmt.Errorf("namespace name can not be empty")
+	}
+
+	if strings.ContainsAny(namespace, NamespaceSeparator+Separator+SingleLevelWildcard+MultiLevelWildcard) {
+		return fmt.Errorf("namespace %s can not contain %s, %s, %s or %s", namespace, NamespaceSeparator, Separator, SingleLevelWildcard, MultiLevelWildcard)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package topic
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Separator splits hierarchical topic names into levels, e.g. orders.eu.created
+	Separator = "."
+	// SingleLevelWildcard matches exactly one level of a topic name
+	SingleLevelWildcard = "*"
+	// MultiLevelWildcard matches zero or more trailing levels of a topic name
+	MultiLevelWildcard = "#"
+)
+
+// IsPattern reports whether the topic name contains wildcards.
+func IsPattern(name string) bool {
+	_, name = SplitName(name)
+	for _, level := range strings.Split(name, Separator) {
+		if level == SingleLevelWildcard || level == MultiLevelWildcard {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchPattern reports whether the topic name matches the pattern, orders.*.created matches
+// orders.eu.created and orders.# matches orders, orders.eu and orders.eu.created. Qualified
+// names only match patterns of the same namespace.
+func MatchPattern(pattern string, name string) bool {
+	patternNamespace, pattern := SplitName(pattern)
+	namespace, name := SplitName(name)
+	if patternNamespace != namespace {
+		return false
+	}
+
+	patternLevels := strings.Split(pattern, Separator)
+	nameLevels := strings.Split(name, Separator)
+
+	for i, level := range patternLevels {
+		if level == MultiLevelWildcard {
+			return i == len(patternLevels)-1
+		}
+
+		if i >= len(nameLevels) {
+			return false
+		}
+
+		if level != SingleLevelWildcard && level != nameLevels[i] {
+			return false
+		}
+	}
+
+	return len(patternLevels) == len(nameLevels)
+}
+
+// ValidatePattern checks that the multi level wildcard is only used as the last level of a
+// topic name within a namespace.
+func ValidatePattern(pattern string) error {
+	if strings.Contains(pattern, NamespaceSeparator) {
+		return fmt.Errorf("topic %s can not contain %s", pattern, NamespaceSeparator)
+	}
+
+	levels := strings.Split(pattern, Separator)
+	for i, level := range levels {
+		if level == "" {
+			return fmt.Errorf("topic %s has an empty level", pattern)
+		}
+
+		if level == MultiLevelWildcard && i != len(levels)-1 {
+			return fmt.Errorf("topic %s can only use %s as the last level", pattern, MultiLevelWildcard)
+		}
+	}
+
+	return nil
+}
+
+// ValidateName checks that a topic name messages are published to has no wildcard characters,
+// a literal orders.* topic would be matched by patterns in confusing ways.
+func ValidateName(name string) error {
+	if strings.ContainsAny(name, SingleLevelWildcard+MultiLevelWildcard) {
+		return fmt.Errorf("topic %s can not contain %s or %s", name, SingleLevelWildcard, MultiLevelWildcard)
+	}
+
+	return nil
+}
+
+// CoversPattern reports whether every topic matched by sub is also matched by pattern, orders.#
+// covers orders.*.created but orders.* doesn't cover orders.#. For plain topic names it is MatchPattern.
+func CoversPattern(pattern string, sub string) bool {
+	patternNamespace, pattern := SplitName(pattern)
+	namespace, sub := SplitName(sub)
+	if patternNamespace != namespace {
+		return false
+	}
+
+	patternLevels := strings.Split(pattern, Separator)
+	subLevels := strings.Split(sub, Separator)
+
+	for i, level := range patternLevels {
+		if level == MultiLevelWildcard {
+			return i == len(patternLevels)-1
+		}
+
+		if i >= len(subLevels) {
+			return false
+		}
+
+		switch {
+		case subLevels[i] == MultiLevelWildcard:
+			return false
+		case level == SingleLevelWildcard:
+			continue
+		case level != subLevels[i]:
+			return false
+		}
+	}
+
+	return len(patternLevels) == len(subLevels)
+}
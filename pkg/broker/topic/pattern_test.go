@@ -0,0 +1,129 @@
+package topic
+
+import "testing"
+
+func TestIsPattern(t *testing.T) {
+	if IsPattern("orders.eu.created") {
+		t.Error("Plain topic name should not be a pattern")
+	}
+
+	if !IsPattern("orders.*.created") || !IsPattern("orders.#") {
+		t.Error("Names with wildcards should be patterns")
+	}
+
+	if IsPattern("orders.eu*") {
+		t.Error("Wildcards only count as a full level")
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"orders.*.created", "orders.eu.created", true},
+		{"orders.*.created", "orders.eu.updated", false},
+		{"orders.*.created", "orders.created", false},
+		{"orders.*.created", "orders.eu.west.created", false},
+		{"orders.#", "orders", true},
+		{"orders.#", "orders.eu", true},
+		{"orders.#", "orders.eu.created", true},
+		{"orders.#", "payments.eu", false},
+		{"#", "anything.at.all", true},
+		{"*.created", "orders.created", true},
+		{"orders.#.created", "orders.eu.created", false},
+		{"orders.eu", "orders.eu", true},
+	}
+
+	for _, tt := range tests {
+		if MatchPattern(tt.pattern, tt.name) != tt.match {
+			t.Errorf("MatchPattern(%q, %q) expected %v", tt.pattern, tt.name, tt.match)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	if err := ValidatePattern("orders.*.created"); err != nil {
+		t.Errorf("Expected valid pattern, got %s", err)
+	}
+
+	if err := ValidatePattern("orders.#.created"); err == nil {
+		t.Error("Multi level wildcard in the middle should be invalid")
+	}
+
+	if err := ValidatePattern("orders..created"); err == nil {
+		t.Error("Empty level should be invalid")
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"orders.eu.created", "payments/orders"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("Expected %s to be valid, got %s", name, err)
+		}
+	}
+
+	for _, name := range []string{"orders.*", "orders.#", "orders*", "payments/#orders"} {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("Expected %s to be invalid", name)
+		}
+	}
+}
+
+func TestCoversPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		sub     string
+		covers  bool
+	}{
+		{"orders.#", "orders.*.created", true},
+		{"orders.#", "orders.#", true},
+		{"orders.*", "orders.#", false},
+		{"orders.*.created", "orders.*.created", true},
+		{"orders.eu.created", "orders.*.created", false},
+		{"#", "#", true},
+		{"orders.*", "orders.eu", true},
+		{"orders.*", "payments.eu", false},
+	}
+
+	for _, tt := range tests {
+		if CoversPattern(tt.pattern, tt.sub) != tt.covers {
+			t.Errorf("CoversPattern(%q, %q) expected %v", tt.pattern, tt.sub, tt.covers)
+		}
+	}
+}
+
+func TestNamespacedPatterns(t *testing.T) {
+	if !MatchPattern("payments/orders.#", "payments/orders.eu") {
+		t.Error("Pattern should match topics of its namespace")
+	}
+
+	if MatchPattern("orders.#", "payments/orders.eu") || MatchPattern("payments/orders.#", "orders.eu") {
+		t.Error("Pattern should not match topics of other namespaces")
+	}
+
+	if !IsPattern("payments/#") || IsPattern("payments/orders") {
+		t.Error("Wildcards should be detected within the namespace")
+	}
+
+	if CoversPattern("billing/#", "payments/orders") || !CoversPattern("payments/#", "payments/orders.*") {
+		t.Error("Patterns should only cover patterns of their namespace")
+	}
+
+	if ValidatePattern("payments/orders") == nil {
+		t.Error("Topic names can not contain the namespace separator")
+	}
+
+	if QualifiedName(DefaultNamespace, "orders") != "orders" || QualifiedName("payments", "orders") != "payments/orders" {
+		t.Error("Only topics outside the default namespace should be qualified")
+	}
+
+	if ns, name := SplitName("payments/orders"); ns != "payments" || name != "orders" {
+		t.Errorf("Expected payments and orders, got %s and %s", ns, name)
+	}
+
+	if ValidateNamespace("pay.ments") == nil || ValidateNamespace("payments") != nil {
+		t.Error("Namespaces can not contain separators or wildcards")
+	}
+}
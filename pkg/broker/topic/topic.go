@@ -4,13 +4,18 @@ import (
 	"context"
 	"errors"
 	"log"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/NamanBalaji/flux/pkg/broker/subscriber"
 	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/dedup"
 	"github.com/NamanBalaji/flux/pkg/message"
 	"github.com/NamanBalaji/flux/pkg/queue"
+	"github.com/NamanBalaji/flux/pkg/request"
 )
 
 type Topic struct {
@@ -18,8 +23,32 @@ type Topic struct {
 	Name         string
 	MessageChan  chan *message.Message
 	MessageQueue *queue.Queue
-	MessageSet   map[string]struct{}
-	Subscribers  []*subscriber.Subscriber
+	// Dedup remembers the recently published message ids to drop duplicates
+	Dedup       *dedup.Window
+	Subscribers []*subscriber.Subscriber
+	// OnExpire is called once per message that expired before delivery, whichever subscriber dropped it first
+	OnExpire func(msg *message.Message, topicName string)
+	// OnAck is handed to the subscribers and called with the messages they no longer have to deliver
+	OnAck func(msg *message.Message, topicName string, addr string)
+	// Standby topics, on followers, keep the subscriber queues without delivering until Resume is called
+	Standby bool
+	// PushTransport is handed to the subscribers to push the messages
+	PushTransport http.RoundTripper
+	// expired counts the messages that expired before delivery, expiredIds are the ones still held
+	// by the topic so a message dropped by several subscribers is only counted and dead lettered once
+	expired    uint64
+	expiredIds map[string]bool
+	// Priority makes the subscriber queues deliver higher priority messages first
+	Priority bool
+	// StarvationLimit bounds how many times a lower priority message can be overtaken
+	StarvationLimit int
+	// Temporary topics, like reply topics, are deleted by the broker once ExpiresAt has passed, Owner
+	// is the principal that created the reply topic and the only one allowed to use it
+	Temporary bool
+	ExpiresAt time.Time
+	Owner     string
+	// added is closed and replaced every time a message is added to wake up WaitForMessage
+	added chan struct{}
 }
 
 type Topics map[string]*Topic
@@ -34,7 +63,9 @@ func CreateTopic(name string, bufferSize int) *Topic {
 		Name:         name,
 		MessageChan:  make(chan *message.Message, bufferSize),
 		MessageQueue: msgQueue,
-		MessageSet:   make(map[string]struct{}),
+		Dedup:        dedup.NewWindow(constants.DefaultDedupWindow, constants.DefaultDedupSize),
+		expiredIds:   make(map[string]bool),
+		added:        make(chan struct{}),
 	}
 
 	go topic.ManageTopic()
@@ -45,13 +76,58 @@ func CreateTopic(name string, bufferSize int) *Topic {
 func (t *Topic) AddMessage(msg *message.Message) {
 	t.MessageChan <- msg
 	t.lock.Lock()
-	t.MessageSet[msg.Id] = struct{}{}
+	t.Dedup.Add(msg.Id, time.Now())
 	t.MessageQueue.Enqueue(msg)
+	close(t.added)
+	t.added = make(chan struct{})
 	t.lock.Unlock()
 
 	log.Printf("Published message with id %s to topic: %s \n", msg.Id, t.Name)
 }
 
+// WaitForMessage blocks until the topic holds a message satisfying match or the context is done.
+func (t *Topic) WaitForMessage(ctx context.Context, match func(msg *message.Message) bool) (*message.Message, error) {
+	for {
+		t.lock.Lock()
+		added := t.added
+		totalMessages := t.MessageQueue.Len()
+		for i := 0; i < totalMessages; i++ {
+			if msg := t.MessageQueue.GetAt(i); match(msg) {
+				t.lock.Unlock()
+
+				return msg, nil
+			}
+		}
+		t.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-added:
+		}
+	}
+}
+
+// Close stops the delivery to every subscriber of the topic, the topic can't be used afterwards.
+func (t *Topic) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, sub := range t.Subscribers {
+		sub.Lock.Lock()
+		sub.IsActive = false
+		if sub.CancelFunc != nil {
+			sub.CancelFunc()
+		}
+		sub.Lock.Unlock()
+	}
+
+	t.Subscribers = nil
+	close(t.MessageChan)
+
+	log.Printf("Topic %s has been closed \n", t.Name)
+}
+
 func (t *Topic) ManageTopic() {
 	for msg := range t.MessageChan {
 		t.deliverMessageToSubscribers(msg)
@@ -62,9 +138,8 @@ func (t *Topic) ShouldEnqueue(msg *message.Message) bool {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	if _, ok := t.MessageSet[msg.Id]; ok {
+	if t.Dedup.Seen(msg.Id, time.Now()) {
 		log.Printf("Topic %s already has a message with id %s \n", t.Name, msg.Id)
-		t.lock.Unlock()
 
 		return false
 	}
@@ -83,12 +158,17 @@ func (t *Topic) deliverMessageToSubscribers(msg *message.Message) {
 	t.lock.Unlock()
 
 	for _, sub := range subsCopy {
+		// on followers the leader's ack can be applied before the message reaches the queue
+		if !sub.Accepts(msg) || msg.Acked(sub.Addr) {
+			continue
+		}
+
 		sub.AddMessage(msg)
 		msg.AddSubscriber(sub.Addr)
 	}
 }
 
-func (t *Topic) Subscribe(ctx context.Context, cfg config.Config, address string, readOld bool) {
+func (t *Topic) Subscribe(ctx context.Context, cfg config.Config, address string, readOld bool, opts subscriber.Options) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
@@ -100,6 +180,7 @@ func (t *Topic) Subscribe(ctx context.Context, cfg config.Config, address string
 				log.Printf("Subscriber[Address: %s] already exists, reactivating \n", address)
 
 				sub.IsActive = true
+				sub.Options = opts
 				sub.CancelFunc()
 
 				newCtx, cancel := context.WithCancel(ctx)
@@ -107,7 +188,9 @@ func (t *Topic) Subscribe(ctx context.Context, cfg config.Config, address string
 
 				sub.Lock.Unlock()
 
-				go sub.HandleQueue(newCtx, cfg, t.Name)
+				if !t.Standby {
+					go sub.HandleQueue(newCtx, cfg, t.Name)
+				}
 
 				return
 			}
@@ -120,7 +203,7 @@ func (t *Topic) Subscribe(ctx context.Context, cfg config.Config, address string
 
 	// Creating new subscriber if it does not exist
 	newCtx, cancel := context.WithCancel(ctx)
-	sub := subscriber.NewSubscriber(address)
+	sub := t.newSubscriber(address, opts)
 	sub.CancelFunc = cancel
 
 	if readOld {
@@ -128,6 +211,10 @@ func (t *Topic) Subscribe(ctx context.Context, cfg config.Config, address string
 		totalMessages := t.MessageQueue.Len()
 		for i := 0; i < totalMessages; i++ {
 			msg := t.MessageQueue.GetAt(i)
+			if !sub.Accepts(msg) || msg.Expired(time.Now()) {
+				continue
+			}
+
 			sub.AddMessage(msg)
 			msg.AddSubscriber(sub.Addr)
 		}
@@ -135,18 +222,283 @@ func (t *Topic) Subscribe(ctx context.Context, cfg config.Config, address string
 
 	t.Subscribers = append(t.Subscribers, sub)
 
-	go sub.HandleQueue(newCtx, cfg, t.Name)
+	if !t.Standby {
+		go sub.HandleQueue(newCtx, cfg, t.Name)
+	}
 
 	log.Printf("Successfully subscribed Subscriber[Address: %s] to the topic %s \n", address, t.Name)
 }
 
+// newSubscriber creates a subscriber delivering the messages of the topic.
+func (t *Topic) newSubscriber(address string, opts subscriber.Options) *subscriber.Subscriber {
+	sub := subscriber.NewSubscriberWithOptions(address, opts)
+	sub.OnExpire = t.expireMessage
+	sub.OnAck = t.OnAck
+	sub.Transport = t.PushTransport
+	if t.Priority {
+		sub.MessageQueue = queue.NewPriorityQueue(t.StarvationLimit)
+	}
+
+	return sub
+}
+
+// Restore attaches a subscriber saved before a restart, the messages the topic holds after the
+// last one it acknowledged, or all of them when it acknowledged none, are queued for it. When the
+// topic no longer holds the last acknowledged message the ones added after it are queued. An active
+// subscriber resumes its delivery.
+func (t *Topic) Restore(cfg config.Config, state request.SubscriberState, opts subscriber.Options) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, sub := range t.Subscribers {
+		if sub.Addr == state.Address {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := t.newSubscriber(state.Address, opts)
+	sub.CancelFunc = cancel
+	sub.IsActive = state.Active
+	sub.LastActive = state.LastActive
+	sub.LastAckedId = state.LastAckedId
+	sub.Delivered = state.Delivered
+	sub.LastAckedAt = state.LastAckedAt
+
+	// the messages up to the last acknowledged one were delivered before the restart
+	lastAcked := -1
+	totalMessages := t.MessageQueue.Len()
+	for i := 0; i < totalMessages && state.LastAckedId != ""; i++ {
+		if t.MessageQueue.GetAt(i).Id == state.LastAckedId {
+			lastAcked = i
+
+			break
+		}
+	}
+
+	for i := lastAcked + 1; i < totalMessages; i++ {
+		msg := t.MessageQueue.GetAt(i)
+		if lastAcked < 0 && !msg.AddedAt.After(state.LastAckedAt) {
+			continue
+		}
+
+		if !sub.Accepts(msg) || msg.Expired(time.Now()) || msg.Acked(sub.Addr) {
+			continue
+		}
+
+		sub.AddMessage(msg)
+		msg.AddSubscriber(sub.Addr)
+	}
+
+	t.Subscribers = append(t.Subscribers, sub)
+
+	if sub.IsActive && !t.Standby {
+		go sub.HandleQueue(ctx, cfg, t.Name)
+	}
+
+	log.Printf("Restored Subscriber[Address: %s] of the topic %s \n", state.Address, t.Name)
+}
+
+// SubscriberStates returns the state of the subscribers to save it.
+func (t *Topic) SubscriberStates() []request.SubscriberState {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	states := make([]request.SubscriberState, 0, len(t.Subscribers))
+	for _, sub := range t.Subscribers {
+		sub.Lock.Lock()
+		states = append(states, t.subscriberState(sub))
+		sub.Lock.Unlock()
+	}
+
+	return states
+}
+
+// subscriberState returns the state of the subscriber, the caller must hold sub.Lock.
+func (t *Topic) subscriberState(sub *subscriber.Subscriber) request.SubscriberState {
+	return request.SubscriberState{
+		Topic:   t.Name,
+		Address: sub.Addr,
+		Subscription: request.ReplicatedSubscription{
+			Raw:    sub.Options.Raw,
+			Filter: sub.Options.Filter.String(),
+			Secret: sub.Options.Secret,
+			Owner:  sub.Options.Owner,
+		},
+		Active:      sub.IsActive,
+		LastActive:  sub.LastActive,
+		LastAckedId: sub.LastAckedId,
+		Delivered:   sub.Delivered,
+		LastAckedAt: sub.LastAckedAt,
+	}
+}
+
+// Snapshot returns the messages, the dedup window and the subscribers of the topic with the
+// messages they have still to receive.
+func (t *Topic) Snapshot() request.TopicSnapshot {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	snapshot := request.TopicSnapshot{
+		Name:            t.Name,
+		Priority:        t.Priority,
+		StarvationLimit: t.StarvationLimit,
+		Temporary:       t.Temporary,
+		Owner:           t.Owner,
+		Messages:        make([]request.SnapshotMessage, 0, t.MessageQueue.Len()),
+		Subscribers:     make([]request.SubscriberSnapshot, 0, len(t.Subscribers)),
+	}
+	if t.Temporary {
+		expiresAt := t.ExpiresAt
+		snapshot.ExpiresAt = &expiresAt
+	}
+
+	totalMessages := t.MessageQueue.Len()
+	for i := 0; i < totalMessages; i++ {
+		msg := t.MessageQueue.GetAt(i)
+		snapshot.Messages = append(snapshot.Messages, request.SnapshotMessage{
+			ReplicatedMessage: *msg.Replicated(),
+			Delivered:         msg.Deliveries(),
+		})
+	}
+
+	snapshot.Expired = t.expired
+	for id := range t.expiredIds {
+		snapshot.ExpiredIds = append(snapshot.ExpiredIds, id)
+	}
+	sort.Strings(snapshot.ExpiredIds)
+
+	entries := t.Dedup.Entries()
+	snapshot.Dedup = make([]request.DedupEntry, len(entries))
+	for i, e := range entries {
+		snapshot.Dedup[i] = request.DedupEntry{Id: e.Id, SeenAt: e.SeenAt}
+	}
+
+	for _, sub := range t.Subscribers {
+		sub.Lock.Lock()
+		state := request.SubscriberSnapshot{
+			SubscriberState: t.subscriberState(sub),
+			Queue:           make([]string, 0, sub.MessageQueue.Len()),
+			Expired:         sub.Expired.Load(),
+		}
+		sub.Lock.Unlock()
+
+		queued := sub.MessageQueue.Len()
+		for i := 0; i < queued; i++ {
+			if msg := sub.MessageQueue.GetAt(i); msg != nil {
+				state.Queue = append(state.Queue, msg.Id)
+			}
+		}
+		snapshot.Subscribers = append(snapshot.Subscribers, state)
+	}
+
+	return snapshot
+}
+
+// Load fills the topic with the content of a snapshot, the active subscribers start delivering
+// the messages queued for them. It's used on topics without messages nor subscribers.
+func (t *Topic) Load(cfg config.Config, snapshot request.TopicSnapshot, options func(request.SubscriberState) subscriber.Options) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.Priority = snapshot.Priority
+	t.StarvationLimit = snapshot.StarvationLimit
+	t.Temporary = snapshot.Temporary
+	t.Owner = snapshot.Owner
+	if snapshot.ExpiresAt != nil {
+		t.ExpiresAt = *snapshot.ExpiresAt
+	}
+
+	for _, e := range snapshot.Dedup {
+		t.Dedup.Add(e.Id, e.SeenAt)
+	}
+
+	t.expired = snapshot.Expired
+	if t.expiredIds == nil {
+		t.expiredIds = make(map[string]bool)
+	}
+	for _, id := range snapshot.ExpiredIds {
+		t.expiredIds[id] = true
+	}
+
+	messages := make(map[string]*message.Message, len(snapshot.Messages))
+	for i := range snapshot.Messages {
+		m := snapshot.Messages[i]
+		msg := message.FromReplicated(&m.ReplicatedMessage)
+		for addr, acked := range m.Delivered {
+			msg.Delivered[addr] = acked
+		}
+		messages[msg.Id] = msg
+		t.MessageQueue.Enqueue(msg)
+	}
+
+	for _, s := range snapshot.Subscribers {
+		ctx, cancel := context.WithCancel(context.Background())
+		sub := t.newSubscriber(s.Address, options(s.SubscriberState))
+		sub.CancelFunc = cancel
+		sub.IsActive = s.Active
+		sub.LastActive = s.LastActive
+		sub.LastAckedId = s.LastAckedId
+		sub.Delivered = s.Delivered
+		sub.LastAckedAt = s.LastAckedAt
+		sub.Expired.Store(s.Expired)
+
+		for _, id := range s.Queue {
+			if msg, ok := messages[id]; ok {
+				sub.MessageQueue.Enqueue(msg)
+			}
+		}
+		t.Subscribers = append(t.Subscribers, sub)
+
+		if sub.IsActive && !t.Standby {
+			go sub.HandleQueue(ctx, cfg, t.Name)
+		}
+	}
+
+	log.Printf("Loaded %d messages and %d subscribers into the topic %s \n", len(snapshot.Messages), len(snapshot.Subscribers), t.Name)
+}
+
+// expireMessage is handed to the subscribers, it counts the expired message and hands it to OnExpire
+// the first time one of them drops it.
+func (t *Topic) expireMessage(msg *message.Message, topicName string) {
+	t.lock.Lock()
+	if t.expiredIds == nil {
+		t.expiredIds = make(map[string]bool)
+	}
+	if t.expiredIds[msg.Id] {
+		t.lock.Unlock()
+
+		return
+	}
+	t.expiredIds[msg.Id] = true
+	t.expired++
+	onExpire := t.OnExpire
+	t.lock.Unlock()
+
+	if onExpire != nil {
+		onExpire(msg, topicName)
+	}
+}
+
+// Reconfigure applies a reloaded config to the topic, its subscribers push with the new settings
+// and hand expired messages to onExpire from their next message.
+func (t *Topic) Reconfigure(settings config.Subscriber, onExpire func(msg *message.Message, topicName string)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.OnExpire = onExpire
+	for _, sub := range t.Subscribers {
+		sub.Reconfigure(settings, t.expireMessage)
+	}
+}
+
 func (t *Topic) Unsubscribe(addr string) error {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
 	for _, s := range t.Subscribers {
-		s.Lock.Lock()
 		if s.Addr == addr {
+			s.Lock.Lock()
 			s.IsActive = false
 			s.Lock.Unlock()
 			log.Printf("Subscriber[Address: %s] subscribed to the topic %s set to inactive \n", addr, t.Name)
@@ -166,7 +518,7 @@ func (t *Topic) CleanupSubscribers(cfg config.Config) {
 
 	for _, sub := range t.Subscribers {
 		sub.Lock.Lock()
-		if !sub.IsActive && time.Now().Sub(sub.LastActive).Seconds() >= float64(cfg.Subscriber.InactiveTime) {
+		if !sub.IsActive && time.Now().Sub(sub.LastActive) >= time.Duration(cfg.Subscriber.InactiveTime) {
 			sub.CancelFunc()
 			sub.Lock.Unlock()
 
@@ -196,12 +548,141 @@ func (t *Topic) CleanupSubscribers(cfg config.Config) {
 func (t *Topic) CleanupMessages(cfg config.Config) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
+
+	t.Dedup.Evict(time.Now())
 	totalMessages := t.MessageQueue.Len()
 	for i := 0; i < totalMessages; i++ {
 		if t.MessageQueue.GetAt(i).SafeToDelete(cfg) {
 			msg := t.MessageQueue.DeleteAtIndex(i)
+			delete(t.expiredIds, msg.Id)
 
 			log.Printf("Message with id %s has been deleted from the topic %s \n", msg.Id, t.Name)
 		}
 	}
 }
+
+// HasSubscriber reports whether a subscriber with the address, active or not, is attached to the topic.
+func (t *Topic) HasSubscriber(addr string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, s := range t.Subscribers {
+		if s.Addr == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SubscriberOwner returns the principal which registered the subscriber with the address, it reports
+// whether such a subscriber, active or not, is attached to the topic.
+func (t *Topic) SubscriberOwner(addr string) (string, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, s := range t.Subscribers {
+		if s.Addr == addr {
+			s.Lock.Lock()
+			defer s.Lock.Unlock()
+
+			return s.Options.Owner, true
+		}
+	}
+
+	return "", false
+}
+
+// Resume starts delivering to the active subscribers of a standby topic.
+func (t *Topic) Resume(cfg config.Config) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.Standby {
+		return
+	}
+	t.Standby = false
+
+	for _, sub := range t.Subscribers {
+		sub.Lock.Lock()
+		if sub.IsActive {
+			if sub.CancelFunc != nil {
+				sub.CancelFunc()
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			sub.CancelFunc = cancel
+
+			go sub.HandleQueue(ctx, cfg, t.Name)
+		}
+		sub.Lock.Unlock()
+	}
+
+	log.Printf("Topic %s resumed delivering to its subscribers \n", t.Name)
+}
+
+// AckDelivered acknowledges the message with the id for the subscriber and removes it from the
+// subscriber's queue, it's used to apply the deliveries of the leader on followers.
+func (t *Topic) AckDelivered(addr string, id string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, sub := range t.Subscribers {
+		if sub.Addr == addr && sub.AckById(id) {
+			return
+		}
+	}
+
+	// the message is not queued yet, acking it keeps it from being queued
+	for i := 0; i < t.MessageQueue.Len(); i++ {
+		if msg := t.MessageQueue.GetAt(i); msg.Id == id {
+			msg.Ack(addr)
+
+			return
+		}
+	}
+}
+
+// HasActiveSubscriber reports whether the address is subscribed and has not unsubscribed.
+func (t *Topic) HasActiveSubscriber(addr string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, s := range t.Subscribers {
+		if s.Addr == addr {
+			s.Lock.Lock()
+			active := s.IsActive
+			s.Lock.Unlock()
+
+			return active
+		}
+	}
+
+	return false
+}
+
+// StoredBytes adds the payload size of the stored messages to the usage of their owners.
+func (t *Topic) StoredBytes(usage map[string]int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for i := 0; i < t.MessageQueue.Len(); i++ {
+		msg := t.MessageQueue.GetAt(i)
+		usage[msg.Owner] += int64(len(msg.Payload))
+	}
+}
+
+// Stats returns a snapshot of the topic counters.
+func (t *Topic) Stats() request.TopicStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	stats := request.TopicStats{
+		Name:        t.Name,
+		Messages:    t.MessageQueue.Len(),
+		Subscribers: len(t.Subscribers),
+		Expired:     t.expired,
+		DedupIds:    t.Dedup.Len(),
+	}
+
+	return stats
+}
@@ -2,22 +2,25 @@ package topic
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/NamanBalaji/flux/pkg/broker/subscriber"
 	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/filter"
 	"github.com/NamanBalaji/flux/pkg/message"
+	"github.com/NamanBalaji/flux/pkg/request"
 )
 
 // Helper function to create a default config
 func defaultConfig() config.Config {
 	return config.Config{
 		Subscriber: config.Subscriber{
-			InactiveTime: 1,
+			InactiveTime: config.Seconds(1),
 		},
 		Message: config.Message{
-			TTL: 1,
+			TTL: config.Seconds(1),
 		},
 	}
 }
@@ -35,12 +38,12 @@ func TestCreateTopic(t *testing.T) {
 // add message
 func TestAddMessage(t *testing.T) {
 	topic := CreateTopic("testTopic", 100)
-	msg := &message.Message{Id: "1", Payload: "Hello World"}
+	msg := &message.Message{Id: "1", Payload: []byte("Hello World")}
 	topic.AddMessage(msg)
 	defer close(topic.MessageChan)
 
-	if _, exists := topic.MessageSet[msg.Id]; !exists {
-		t.Error("Message ID should exist in MessageSet")
+	if !topic.Dedup.Seen(msg.Id, time.Now()) {
+		t.Error("Message ID should exist in the dedup window")
 	}
 
 	retrievedMessage := topic.MessageQueue.Peek()
@@ -51,7 +54,7 @@ func TestAddMessage(t *testing.T) {
 
 func TestDeliverToSubscriber(t *testing.T) {
 	topic := CreateTopic("testTopic", 100)
-	msg := message.NewMessage("1", "Hello World")
+	msg := message.NewMessage("1", []byte("Hello World"))
 
 	sub := subscriber.NewSubscriber("localhost:6969")
 	topic.Subscribers = append(topic.Subscribers, sub)
@@ -82,7 +85,7 @@ func TestDeliverToSubscriber(t *testing.T) {
 
 func TestShouldEnqueue(t *testing.T) {
 	topic := CreateTopic("testTopic", 100)
-	msg := message.NewMessage("1", "Hello World")
+	msg := message.NewMessage("1", []byte("Hello World"))
 
 	if !topic.ShouldEnqueue(msg) {
 		t.Errorf("Should enqueue message %v", msg)
@@ -98,11 +101,11 @@ func TestSubscribe_ReadOldFalse(t *testing.T) {
 	topic := CreateTopic("testTopic", 100)
 	defer close(topic.MessageChan)
 
-	msg := message.NewMessage("1", "Hello World")
+	msg := message.NewMessage("1", []byte("Hello World"))
 	topic.MessageQueue.Enqueue(msg)
-	topic.MessageSet[msg.Id] = struct{}{}
+	topic.Dedup.Add(msg.Id, time.Now())
 
-	topic.Subscribe(context.Background(), defaultConfig(), "localhost:6969", false)
+	topic.Subscribe(context.Background(), defaultConfig(), "localhost:6969", false, subscriber.Options{})
 
 	if len(topic.Subscribers) != 1 {
 		t.Error("Should be 1 subscriber in topic")
@@ -121,11 +124,11 @@ func TestSubscribe_ReadOldTrue(t *testing.T) {
 	topic := CreateTopic("testTopic", 100)
 	defer close(topic.MessageChan)
 
-	msg := message.NewMessage("1", "Hello World")
+	msg := message.NewMessage("1", []byte("Hello World"))
 	topic.MessageQueue.Enqueue(msg)
-	topic.MessageSet[msg.Id] = struct{}{}
+	topic.Dedup.Add(msg.Id, time.Now())
 
-	topic.Subscribe(context.Background(), defaultConfig(), "localhost:6969", true)
+	topic.Subscribe(context.Background(), defaultConfig(), "localhost:6969", true, subscriber.Options{})
 
 	if len(topic.Subscribers) != 1 {
 		t.Error("Should be 1 subscriber in topic")
@@ -148,9 +151,9 @@ func TestSubscribe_ReactivateOld(t *testing.T) {
 	topic := CreateTopic("testTopic", 100)
 	defer close(topic.MessageChan)
 
-	msg := message.NewMessage("1", "Hello World")
+	msg := message.NewMessage("1", []byte("Hello World"))
 	topic.MessageQueue.Enqueue(msg)
-	topic.MessageSet[msg.Id] = struct{}{}
+	topic.Dedup.Add(msg.Id, time.Now())
 
 	sub := subscriber.NewSubscriber("localhost:6969")
 	_, cancel := context.WithCancel(context.Background())
@@ -159,7 +162,7 @@ func TestSubscribe_ReactivateOld(t *testing.T) {
 
 	topic.Subscribers = append(topic.Subscribers, sub)
 
-	topic.Subscribe(context.Background(), defaultConfig(), "localhost:6969", true)
+	topic.Subscribe(context.Background(), defaultConfig(), "localhost:6969", true, subscriber.Options{})
 
 	if len(topic.Subscribers) != 1 {
 		t.Error("Should be 1 subscriber in topic")
@@ -228,17 +231,17 @@ func TestCleanupMessages_Remove(t *testing.T) {
 	topic := CreateTopic("testTopic", 100)
 	defer close(topic.MessageChan)
 
-	msg := message.NewMessage("1", "Hello World")
+	msg := message.NewMessage("1", []byte("Hello World"))
 	msg.AddedAt = time.Now().Add(-time.Hour)
 
 	topic.MessageQueue.Enqueue(msg)
-	topic.MessageSet[msg.Id] = struct{}{}
+	topic.Dedup.Add(msg.Id, time.Now())
 
 	topic.CleanupMessages(defaultConfig())
 	if topic.MessageQueue.Len() != 0 {
 		t.Error("Messages should have been deleted")
 	}
-	if len(topic.MessageSet) != 1 {
+	if topic.Dedup.Len() != 1 {
 		t.Error("Messages should still be in the set")
 	}
 }
@@ -247,14 +250,203 @@ func TestCleanupMessages_DontRemove(t *testing.T) {
 	topic := CreateTopic("testTopic", 100)
 	defer close(topic.MessageChan)
 
-	msg := message.NewMessage("1", "Hello World")
+	msg := message.NewMessage("1", []byte("Hello World"))
 	msg.AddedAt = time.Now().Add(time.Hour)
 
 	topic.MessageQueue.Enqueue(msg)
-	topic.MessageSet[msg.Id] = struct{}{}
+	topic.Dedup.Add(msg.Id, time.Now())
 
 	topic.CleanupMessages(defaultConfig())
 	if topic.MessageQueue.Len() != 1 {
 		t.Error("Messages should still be in the queue")
 	}
 }
+
+func TestDeliverToSubscriber_Filtered(t *testing.T) {
+	topic := CreateTopic("testTopic", 100)
+	defer close(topic.MessageChan)
+
+	f, err := filter.Parse("region = 'eu'")
+	if err != nil {
+		t.Fatalf("Unexpected filter error: %s", err)
+	}
+
+	sub := subscriber.NewSubscriberWithOptions("localhost:6969", subscriber.Options{Filter: f})
+	topic.Subscribers = append(topic.Subscribers, sub)
+
+	skipped := message.NewMessage("1", []byte("Hello World"))
+	skipped.SetHeaders(map[string]string{"region": "us"})
+	matched := message.NewMessage("2", []byte("Hello World"))
+	matched.SetHeaders(map[string]string{"region": "eu"})
+
+	topic.AddMessage(skipped)
+	topic.AddMessage(matched)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if sub.MessageQueue.Len() != 1 {
+		t.Fatalf("Expected message queue length of 1, got %d", sub.MessageQueue.Len())
+	}
+
+	if sub.MessageQueue.Peek() != matched {
+		t.Error("Expected only the matching message to be enqueued")
+	}
+
+	skipped.Lock.Lock()
+	defer skipped.Lock.Unlock()
+
+	if _, ok := skipped.Delivered[sub.Addr]; ok {
+		t.Error("Skipped message should not track the subscriber")
+	}
+}
+
+func TestSubscribe_PriorityTopic(t *testing.T) {
+	topic := CreateTopic("alerts", 100)
+	defer close(topic.MessageChan)
+	topic.Priority = true
+
+	low := message.NewMessage("low", []byte("bulk"))
+	high := message.NewMessage("high", []byte("page"))
+	high.Priority = 10
+	topic.MessageQueue.Enqueue(low)
+	topic.MessageQueue.Enqueue(high)
+
+	topic.Subscribe(context.Background(), defaultConfig(), "localhost:6969", true, subscriber.Options{})
+
+	sub := topic.Subscribers[0]
+	sub.CancelFunc()
+
+	if sub.MessageQueue.Peek() != high {
+		t.Error("Subscriber queue of a priority topic should deliver higher priorities first")
+	}
+}
+
+func TestShouldEnqueue_Duplicate(t *testing.T) {
+	topic := CreateTopic("testTopic", 100)
+	defer close(topic.MessageChan)
+
+	msg := message.NewMessage("1", []byte("Hello World"))
+	topic.AddMessage(msg)
+
+	if topic.ShouldEnqueue(message.NewMessage("1", []byte("Hello World"))) {
+		t.Error("Should not enqueue a message with an id inside the dedup window")
+	}
+}
+
+func TestRestore_QueuesMessagesAfterLastAcked(t *testing.T) {
+	topic := CreateTopic("testTopic", 100)
+	defer close(topic.MessageChan)
+	topic.Standby = true
+
+	for _, id := range []string{"1", "2", "3"} {
+		topic.MessageQueue.Enqueue(message.NewMessage(id, []byte(id)))
+	}
+
+	lastActive := time.Now().Add(-time.Minute)
+	topic.Restore(defaultConfig(), request.SubscriberState{
+		Topic:       "testTopic",
+		Address:     "localhost:6969",
+		Active:      true,
+		LastActive:  lastActive,
+		LastAckedId: "2",
+		Delivered:   2,
+	}, subscriber.Options{Owner: "alice"})
+	topic.Restore(defaultConfig(), request.SubscriberState{Topic: "testTopic", Address: "localhost:6969"}, subscriber.Options{})
+
+	if len(topic.Subscribers) != 1 {
+		t.Fatalf("Expected 1 restored subscriber, got %d", len(topic.Subscribers))
+	}
+	sub := topic.Subscribers[0]
+	sub.CancelFunc()
+
+	if sub.MessageQueue.Len() != 1 || sub.MessageQueue.Peek().Id != "3" {
+		t.Errorf("Expected only the message after the last acked one to be queued, got %d messages", sub.MessageQueue.Len())
+	}
+	if !sub.IsActive || !sub.LastActive.Equal(lastActive) || sub.Delivered != 2 || sub.Options.Owner != "alice" {
+		t.Errorf("Expected subscriber state to be restored, got %+v", sub)
+	}
+
+	states := topic.SubscriberStates()
+	if len(states) != 1 || states[0].LastAckedId != "2" || states[0].Subscription.Owner != "alice" {
+		t.Errorf("Expected the restored state to be saved again, got %+v", states)
+	}
+}
+
+func TestRestore_QueuesMessagesAddedAfterMissingLastAcked(t *testing.T) {
+	topic := CreateTopic("testTopic", 100)
+	defer close(topic.MessageChan)
+	topic.Standby = true
+
+	lastAckedAt := time.Now().Add(-time.Minute)
+	for i, id := range []string{"1", "2", "3"} {
+		msg := message.NewMessage(id, []byte(id))
+		msg.AddedAt = lastAckedAt.Add(time.Duration(i-1) * time.Second)
+		topic.MessageQueue.Enqueue(msg)
+	}
+
+	topic.Restore(defaultConfig(), request.SubscriberState{
+		Topic:       "testTopic",
+		Address:     "localhost:6969",
+		LastAckedId: "trimmed",
+		LastAckedAt: lastAckedAt,
+		Delivered:   5,
+	}, subscriber.Options{})
+	topic.Restore(defaultConfig(), request.SubscriberState{
+		Topic:       "testTopic",
+		Address:     "localhost:7070",
+		LastAckedId: "unknown",
+	}, subscriber.Options{})
+
+	if len(topic.Subscribers) != 2 {
+		t.Fatalf("Expected 2 restored subscribers, got %d", len(topic.Subscribers))
+	}
+	if sub := topic.Subscribers[0]; sub.MessageQueue.Len() != 1 || sub.MessageQueue.Peek().Id != "3" {
+		t.Errorf("Expected only the message added after the last acked one to be queued, got %d messages", sub.MessageQueue.Len())
+	}
+	if sub := topic.Subscribers[1]; sub.MessageQueue.Len() != 3 {
+		t.Errorf("Expected every message to be queued without the time of the last ack, got %d messages", sub.MessageQueue.Len())
+	}
+}
+
+func TestExpiredMessageDeadLetteredOnce(t *testing.T) {
+	topic := CreateTopic("testTopic", 100)
+	defer close(topic.MessageChan)
+
+	var mu sync.Mutex
+	var expired []string
+	topic.OnExpire = func(msg *message.Message, topicName string) {
+		mu.Lock()
+		expired = append(expired, msg.Id)
+		mu.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	topic.Subscribe(ctx, defaultConfig(), "localhost:6969", false, subscriber.Options{})
+	topic.Subscribe(ctx, defaultConfig(), "localhost:6970", false, subscriber.Options{})
+
+	msg := message.NewMessage("1", []byte("Hello World"))
+	msg.TTL = time.Millisecond
+	msg.AddedAt = time.Now().Add(-time.Second)
+	topic.AddMessage(msg)
+
+	deadline := time.Now().Add(time.Second)
+	for !msg.Acked("localhost:6969") || !msg.Acked("localhost:6970") {
+		if time.Now().After(deadline) {
+			t.Fatal("Expired message was not dropped by both subscribers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the subscribers hand the message to the topic right after dropping it
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 1 {
+		t.Errorf("Expected the expired message to be handed to OnExpire once, got %d", len(expired))
+	}
+
+	if stats := topic.Stats(); stats.Expired != 1 {
+		t.Errorf("Expected 1 expired message, got %d", stats.Expired)
+	}
+}
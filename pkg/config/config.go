@@ -1,23 +1,69 @@
 package config
 
 import (
+	"fmt"
 	"os"
 
 	"gopkg.in/yaml.v3"
 )
 
+// EnvPrefix prefixes the environment variables overriding the broker config, see ApplyEnv.
+const EnvPrefix = "FLUX"
+
+// LoadConfig loads the broker config from the file, the settings missing from the file keep their
+// default and the FLUX_* environment variables override the file. An empty fileName loads the
+// defaults and the environment variables only. The config is validated before it is returned.
 func LoadConfig(fileName string) (*Config, error) {
+	cfg := Default()
+	if fileName != "" {
+		if err := decodeFile(fileName, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ApplyEnv(&cfg, EnvPrefix); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// LoadMirrorConfig loads the config of the mirror, the FLUX_MIRROR_* environment variables
+// override the file. The config is validated before it is returned.
+func LoadMirrorConfig(fileName string) (*MirrorConfig, error) {
+	cfg := DefaultMirror()
+	if err := decodeFile(fileName, &cfg); err != nil {
+		return nil, err
+	}
+
+	if err := ApplyEnv(&cfg, EnvPrefix+"_MIRROR"); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func decodeFile(fileName string, v any) error {
 	f, err := os.Open(fileName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer f.Close()
 
-	var cfg Config
+	// unknown settings are rejected so a misspelled or renamed one isn't silently ignored
 	decoder := yaml.NewDecoder(f)
-	if err := decoder.Decode(&cfg); err != nil {
-		return nil, err
+	decoder.KnownFields(true)
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", fileName, err)
 	}
 
-	return &cfg, nil
+	return nil
 }
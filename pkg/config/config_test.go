@@ -0,0 +1,167 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestLoadConfigDefaultsAndDurations(t *testing.T) {
+	file := writeFile(t, `
+message:
+  ttl: 30s
+  cleanup_time: 120
+subscriber:
+  timeout: 1m30s
+`)
+
+	cfg, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Message.TTL != Duration(30*time.Second) || cfg.Message.CleanupTime != Seconds(120) {
+		t.Errorf("Expected a Go duration and bare seconds to be parsed, got %s and %s", cfg.Message.TTL, cfg.Message.CleanupTime)
+	}
+	if cfg.Subscriber.Timeout != Duration(90*time.Second) {
+		t.Errorf("Expected subscriber timeout 1m30s, got %s", cfg.Subscriber.Timeout)
+	}
+
+	defaults := Default()
+	if cfg.Subscriber.RetryCount != defaults.Subscriber.RetryCount || cfg.Message.DedupWindow != defaults.Message.DedupWindow || cfg.Api.Port != defaults.Api.Port {
+		t.Error("Expected the settings missing from the file to keep their default")
+	}
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	file := writeFile(t, `
+api:
+  port: 8080
+subscriber:
+  retry_count: 5
+`)
+	t.Setenv("FLUX_API_PORT", "9000")
+	t.Setenv("FLUX_SUBSCRIBER_TIMEOUT", "5s")
+	t.Setenv("FLUX_SUBSCRIBER_HEADERS_AS_HTTP", "true")
+	t.Setenv("FLUX_TOPIC_PRIORITY_TOPICS", "orders.*, payments")
+
+	cfg, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Api.Port != 9000 || cfg.Subscriber.Timeout != Duration(5*time.Second) || !cfg.Subscriber.HeadersAsHTTP {
+		t.Error("Expected the environment variables to override the file")
+	}
+	if cfg.Subscriber.RetryCount != 5 {
+		t.Errorf("Expected the settings without environment variable to come from the file, got retry count %d", cfg.Subscriber.RetryCount)
+	}
+	if len(cfg.Topic.PriorityTopics) != 2 || cfg.Topic.PriorityTopics[1] != "payments" {
+		t.Errorf("Expected a comma separated list, got %v", cfg.Topic.PriorityTopics)
+	}
+
+	withoutFile, err := LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withoutFile.Api.Port != 9000 || withoutFile.Subscriber.RetryCount != Default().Subscriber.RetryCount {
+		t.Error("Expected a config without file to use the defaults and the environment variables")
+	}
+
+	t.Setenv("FLUX_MESSAGE_TTL", "soon")
+	if _, err := LoadConfig(file); err == nil || !strings.Contains(err.Error(), "FLUX_MESSAGE_TTL") {
+		t.Errorf("Expected an invalid environment variable to be reported, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	file := writeFile(t, `
+message:
+  cleanup_time: 0
+subscriber:
+  retry_count: 0
+  state_file: subscribers.json
+replication:
+  role: follower
+`)
+
+	_, err := LoadConfig(file)
+	if err == nil {
+		t.Fatal("Expected the config to be rejected")
+	}
+	for _, setting := range []string{"message.cleanup_time", "subscriber.retry_count", "subscriber.state_file", "replication.leader"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("Expected the error to report %s, got %s", setting, err)
+		}
+	}
+
+	if _, err := LoadConfig(writeFile(t, "message:\n  ttl: 10 minutes\n")); err == nil || !strings.Contains(err.Error(), "30s") {
+		t.Errorf("Expected an invalid duration to be rejected with an example, got %v", err)
+	}
+
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Expected the default config to be valid, got %s", err)
+	}
+
+	if _, err := LoadConfig(writeFile(t, "cluster:\n  election_timeout_ms: 500\n")); err == nil || !strings.Contains(err.Error(), "election_timeout_ms") {
+		t.Errorf("Expected an unknown setting to be rejected, got %v", err)
+	}
+}
+
+func TestLoadMirrorConfig(t *testing.T) {
+	cfg, err := LoadMirrorConfig(writeFile(t, `
+source:
+  address: http://localhost:9092
+destination:
+  address: http://localhost:9093
+host: http://localhost
+port: 8090
+topics:
+  - source: orders
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CheckpointInterval != DefaultMirror().CheckpointInterval || cfg.ResubscribeInterval != DefaultMirror().ResubscribeInterval {
+		t.Errorf("Expected the intervals missing from the file to keep their default, got %s and %s", cfg.CheckpointInterval, cfg.ResubscribeInterval)
+	}
+
+	_, err = LoadMirrorConfig(writeFile(t, `
+source:
+  address: http://localhost:9092
+port: 8090
+topics:
+  - prefix: dc1.
+checkpoint_interval: 0s
+`))
+	if err == nil {
+		t.Fatal("Expected the mirror config to be rejected")
+	}
+	for _, setting := range []string{"destination.address", "host", "topics[0]", "checkpoint_interval"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("Expected the error to report %s, got %s", setting, err)
+		}
+	}
+}
+
+func TestDistConfigsAreValid(t *testing.T) {
+	if _, err := LoadConfig("../../cmd/broker/config.dist.yml"); err != nil {
+		t.Errorf("Expected the broker dist config to load, got %s", err)
+	}
+	if _, err := LoadMirrorConfig("../../cmd/mirror/config.dist.yml"); err != nil {
+		t.Errorf("Expected the mirror dist config to load, got %s", err)
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a Go duration in the config, e.g. 30s or 5m. A bare integer is read as seconds so the
+// configs written before durations were supported keep working.
+type Duration time.Duration
+
+// Seconds returns the duration of n seconds.
+func Seconds(n int) Duration {
+	return Duration(time.Duration(n) * time.Second)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := string(text)
+	if seconds, err := strconv.Atoi(s); err == nil {
+		*d = Seconds(seconds)
+
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected a Go duration like 30s", s)
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a duration", node.Line)
+	}
+	if err := d.UnmarshalText([]byte(node.Value)); err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+
+	return nil
+}
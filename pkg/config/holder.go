@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Holder holds the config of the running broker. Reload replaces it with the content of the config
+// file and hands it to the OnReload callbacks, the long running parts of the broker read it with Get.
+type Holder struct {
+	mu sync.RWMutex
+	// reloading serializes the reloads so the callbacks see them in order
+	reloading sync.Mutex
+	file      string
+	cfg       Config
+	onReload  []func(Config)
+}
+
+// NewHolder creates a holder of the config loaded from the file.
+func NewHolder(file string, cfg Config) *Holder {
+	return &Holder{file: file, cfg: cfg}
+}
+
+// Get returns the current config.
+func (h *Holder) Get() Config {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.cfg
+}
+
+// OnReload registers a callback called with the new config after every successful reload.
+func (h *Holder) OnReload(f func(Config)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onReload = append(h.onReload, f)
+}
+
+// Reload loads the config file again and replaces the current config with it, the current config
+// is kept when the file is invalid or changes settings which need a restart.
+func (h *Holder) Reload() (Config, error) {
+	if h.file == "" {
+		return Config{}, errors.New("the config wasn't loaded from a file")
+	}
+
+	cfg, err := LoadConfig(h.file)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to load %s: %w", h.file, err)
+	}
+
+	if err := h.Set(*cfg); err != nil {
+		return Config{}, err
+	}
+
+	return *cfg, nil
+}
+
+// Set replaces the current config, see Reload.
+func (h *Holder) Set(cfg Config) error {
+	h.reloading.Lock()
+	defer h.reloading.Unlock()
+
+	h.mu.Lock()
+	if err := CheckReload(h.cfg, cfg); err != nil {
+		h.mu.Unlock()
+
+		return err
+	}
+	h.cfg = cfg
+	callbacks := append([]func(Config){}, h.onReload...)
+	h.mu.Unlock()
+
+	for _, f := range callbacks {
+		f(cfg)
+	}
+
+	return nil
+}
+
+// CheckReload returns an error if the new config is invalid or changes settings which are only
+// read when the broker starts.
+func CheckReload(old Config, new Config) error {
+	if err := new.Validate(); err != nil {
+		return err
+	}
+
+	var changed []string
+	for _, s := range []struct {
+		name     string
+		old, new any
+	}{
+		{"api", old.Api, new.Api},
+		{"auth", old.Auth, new.Auth},
+		{"acl", old.ACL, new.ACL},
+		{"audit", old.Audit, new.Audit},
+		{"replication", old.Replication, new.Replication},
+		{"cluster", old.Cluster, new.Cluster},
+		{"subscriber.tls", old.Subscriber.TLS, new.Subscriber.TLS},
+		{"subscriber.state_file", old.Subscriber.StateFile, new.Subscriber.StateFile},
+		{"limits.principal", old.Limits.Principal, new.Limits.Principal},
+		{"limits.topic", old.Limits.Topic, new.Limits.Topic},
+		{"limits.ip", old.Limits.IP, new.Limits.IP},
+	} {
+		if !reflect.DeepEqual(s.old, s.new) {
+			changed = append(changed, s.name)
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("changing %s requires a restart", strings.Join(changed, ", "))
+	}
+
+	return nil
+}
+
+// Reloadable returns the settings a reload can change, e.g. to record them in the audit trail.
+func (c Config) Reloadable() map[string]any {
+	return map[string]any{
+		"message":    c.Message,
+		"subscriber": c.Subscriber,
+		"topic":      c.Topic,
+		"limits":     c.Limits,
+		"namespaces": c.Namespaces,
+	}
+}
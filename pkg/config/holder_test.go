@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+api:
+  port: 8080
+message:
+  cleanup_time: 10
+  ttl: %TTL%
+subscriber:
+  cleanup_time: 10
+  retry_count: 3
+`
+
+func writeConfig(t *testing.T, file string, ttl string, port string) {
+	t.Helper()
+
+	content := strings.ReplaceAll(testConfig, "%TTL%", ttl)
+	content = strings.ReplaceAll(content, "8080", port)
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHolderReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	writeConfig(t, file, "60", "8080")
+
+	cfg, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	holder := NewHolder(file, *cfg)
+	var reloaded []Config
+	holder.OnReload(func(c Config) {
+		reloaded = append(reloaded, c)
+	})
+
+	writeConfig(t, file, "120", "8080")
+	if _, err := holder.Reload(); err != nil {
+		t.Fatalf("Expected the reload to succeed, got %s", err)
+	}
+	if holder.Get().Message.TTL != Seconds(120) || len(reloaded) != 1 || reloaded[0].Message.TTL != Seconds(120) {
+		t.Error("Expected the reloaded ttl to be applied and handed to the callbacks")
+	}
+
+	writeConfig(t, file, "180", "9090")
+	_, err = holder.Reload()
+	if err == nil || !strings.Contains(err.Error(), "api") {
+		t.Errorf("Expected changing the api port to require a restart, got %v", err)
+	}
+	if holder.Get().Message.TTL != Seconds(120) || len(reloaded) != 1 {
+		t.Error("Expected a rejected reload to keep the current config")
+	}
+}
+
+func TestCheckReload(t *testing.T) {
+	cfg := Default()
+
+	invalid := cfg
+	invalid.Message.CleanupTime = 0
+	if err := CheckReload(cfg, invalid); err == nil {
+		t.Error("Expected a zero cleanup time to be rejected")
+	}
+
+	changed := cfg
+	changed.Subscriber.RetryCount = 5
+	changed.Topic.Buffer = 100
+	if err := CheckReload(cfg, changed); err != nil {
+		t.Errorf("Expected the subscriber and topic settings to be reloadable, got %s", err)
+	}
+
+	changed.Auth.Enabled = true
+	if err := CheckReload(cfg, changed); err == nil || !strings.Contains(err.Error(), "auth") {
+		t.Errorf("Expected enabling auth to require a restart, got %v", err)
+	}
+}
@@ -5,25 +5,260 @@ type Config struct {
 	Message    Message    `yaml:"message"`
 	Subscriber Subscriber `yaml:"subscriber"`
 	Topic      Topic      `yaml:"topic"`
+	Auth       Auth       `yaml:"auth"`
+	ACL        ACL        `yaml:"acl"`
+	Limits     Limits     `yaml:"limits"`
+	Audit      Audit      `yaml:"audit"`
+	// Replication makes the broker a leader replicating to followers or a follower of a leader
+	Replication Replication `yaml:"replication"`
+	// Cluster makes the broker a node of a raft cluster electing its leader, it excludes Replication
+	Cluster Cluster `yaml:"cluster"`
+	// Namespaces override the retention and quotas of the topics in a namespace
+	Namespaces []Namespace `yaml:"namespaces"`
 }
 
 type Api struct {
 	Port int `yaml:"port"`
+	// TLS serves the api over TLS when a certificate is configured
+	TLS TLS `yaml:"tls"`
+}
+
+// TLS configures the certificates of a server or client, the files are reloaded when they change.
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// CAFile verifies the peer: client certificates on servers (mTLS), server certificates on clients
+	CAFile string `yaml:"ca_file"`
+}
+
+// Enabled reports whether any certificate is configured.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.CAFile != ""
 }
 
 type Subscriber struct {
-	CleanupTime   int `yaml:"cleanup_time"`
-	RetryCount    int `yaml:"retry_count"`
-	RetryInterval int `yaml:"retry_interval"`
-	Timeout       int `yaml:"timeout"`
-	InactiveTime  int `yaml:"inactive_time"`
+	CleanupTime   Duration `yaml:"cleanup_time"`
+	RetryCount    int      `yaml:"retry_count"`
+	RetryInterval Duration `yaml:"retry_interval"`
+	Timeout       Duration `yaml:"timeout"`
+	InactiveTime  Duration `yaml:"inactive_time"`
+	// HeadersAsHTTP additionally sends message headers as X-Flux-Header-* HTTP headers on push
+	HeadersAsHTTP bool `yaml:"headers_as_http"`
+	// TLS configures the client certificate and trusted CA used to push to https subscribers
+	TLS TLS `yaml:"tls"`
+	// StateFile keeps the subscriptions and their progress across restarts, disabled when empty
+	StateFile string `yaml:"state_file"`
+	// StateInterval is how often the subscriber state is saved
+	StateInterval Duration `yaml:"state_interval"`
 }
 
 type Topic struct {
 	Buffer int `yaml:"buffer"`
+	// PriorityTopics lists the topics, wildcard patterns allowed, delivering higher priority messages first
+	PriorityTopics []string `yaml:"priority_topics"`
+	// StarvationLimit is how many times a message can be overtaken by higher priorities before being delivered
+	StarvationLimit int `yaml:"starvation_limit"`
+	// TemporaryTTL is the default lifetime of temporary reply topics
+	TemporaryTTL Duration `yaml:"temporary_ttl"`
 }
 
 type Message struct {
-	CleanupTime int `yaml:"cleanup_time"`
-	TTL         int `yaml:"ttl"`
+	CleanupTime Duration `yaml:"cleanup_time"`
+	TTL         Duration `yaml:"ttl"`
+	// DeadLetterTopic receives the messages that expired before being delivered, disabled when empty
+	DeadLetterTopic string `yaml:"dead_letter_topic"`
+	// DedupWindow is how long a message id is remembered per topic to drop duplicates
+	DedupWindow Duration `yaml:"dedup_window"`
+	// DedupSize is the maximum number of message ids remembered per topic
+	DedupSize int `yaml:"dedup_size"`
+	// TransactionTimeout is the default time before an uncommitted transaction is aborted
+	TransactionTimeout Duration `yaml:"transaction_timeout"`
+}
+
+type Auth struct {
+	// Enabled requires every request, except /ping, to be authenticated
+	Enabled bool     `yaml:"enabled"`
+	APIKeys []APIKey `yaml:"api_keys"`
+	JWT     JWT      `yaml:"jwt"`
+}
+
+// APIKey is a static key sent in the X-Flux-Api-Key header, requests using it act as Subject.
+type APIKey struct {
+	Key     string `yaml:"key"`
+	Subject string `yaml:"subject"`
+}
+
+type JWT struct {
+	// KeyFile holds the HMAC key the bearer tokens are signed with, JWTs are disabled when empty
+	KeyFile string `yaml:"key_file"`
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+}
+
+type ACL struct {
+	// Enabled denies every publish and subscribe not allowed by a rule
+	Enabled bool `yaml:"enabled"`
+	// Admins are the subjects allowed to use the admin endpoints, "*" allows everyone
+	Admins []string  `yaml:"admins"`
+	Rules  []ACLRule `yaml:"rules"`
+}
+
+// ACLRule allows Subject, or everyone with "*", the publish and subscribe Actions on Topics.
+type ACLRule struct {
+	// Namespace of the Topics, the default namespace when empty
+	Namespace string   `yaml:"namespace"`
+	Subject   string   `yaml:"subject"`
+	Topics    []string `yaml:"topics"`
+	Actions   []string `yaml:"actions"`
+}
+
+// Limits are the publish rate limits and the per tenant quotas, zero values disable a limit.
+type Limits struct {
+	// Principal, Topic and IP limit the publish rate per authenticated principal, topic and source IP
+	Principal Rate `yaml:"principal"`
+	Topic     Rate `yaml:"topic"`
+	IP        Rate `yaml:"ip"`
+	// MaxSubscriptions is the number of subscriptions each tenant can register
+	MaxSubscriptions int `yaml:"max_subscriptions"`
+	// MaxStorageBytes is the payload size of the messages each tenant can have stored in the broker
+	MaxStorageBytes int64 `yaml:"max_storage_bytes"`
+}
+
+type Rate struct {
+	// Messages and Bytes are allowed per second, with bursts of one second
+	Messages float64 `yaml:"messages"`
+	Bytes    float64 `yaml:"bytes"`
+}
+
+// Audit is the audit trail of the subscription, topic and admin changes, disabled when File is empty.
+type Audit struct {
+	File string `yaml:"file"`
+	// MaxSizeBytes rotates the file once it would grow over it, 0 disables the rotation
+	MaxSizeBytes int64 `yaml:"max_size_bytes"`
+	// MaxBackups is the number of rotated files kept
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// Replication between a leader and its followers, disabled when Role is empty.
+type Replication struct {
+	// Role is leader or follower
+	Role string `yaml:"role"`
+	// Id names the follower to the leader, the follower's api port is used when empty
+	Id string `yaml:"id"`
+	// Leader is the url of the leader's api a follower replicates from, e.g. http://localhost:9092
+	Leader string `yaml:"leader"`
+	// APIKey authenticates the follower to the leader, its subject must be an acl admin. On the leader
+	// only the requests sending it get the push secrets of the subscriptions in the replication log
+	APIKey string `yaml:"api_key"`
+	// Sync makes publishes wait until the in sync followers have replicated them
+	Sync bool `yaml:"sync"`
+	// SyncTimeout is how long a publish waits for a follower before it is no longer in sync
+	SyncTimeout Duration `yaml:"sync_timeout"`
+	// LogSize is the number of changes kept by the leader for followers to catch up
+	LogSize int `yaml:"log_size"`
+}
+
+// Cluster of brokers replicating their changes through raft, disabled when Nodes is empty.
+type Cluster struct {
+	// NodeId is the id of this broker in Nodes
+	NodeId string `yaml:"node_id"`
+	// Nodes lists every node of the cluster, this one included
+	Nodes []ClusterNode `yaml:"nodes"`
+	// DataDir holds the raft log and state of the node, raft-<node_id> when empty
+	DataDir string `yaml:"data_dir"`
+	// ElectionTimeout is the minimum time without a leader before a node starts an election
+	ElectionTimeout Duration `yaml:"election_timeout"`
+	// HeartbeatInterval is how often the leader contacts the other nodes
+	HeartbeatInterval Duration `yaml:"heartbeat_interval"`
+	// SnapshotThreshold is the number of changes applied after the last snapshot before the raft
+	// log is compacted into a new one, constants.DefaultSnapshotThreshold when 0
+	SnapshotThreshold int `yaml:"snapshot_threshold"`
+	// APIKey authenticates the nodes to each other on the raft routes, with auth enabled its subject
+	// must be an acl admin
+	APIKey string `yaml:"api_key"`
+}
+
+type ClusterNode struct {
+	Id string `yaml:"id"`
+	// Address is the url of the node's api, e.g. http://localhost:9092
+	Address string `yaml:"address"`
+}
+
+// Addresses maps the ids of the nodes to the urls of their apis.
+func (c Cluster) Addresses() map[string]string {
+	addresses := make(map[string]string, len(c.Nodes))
+	for _, n := range c.Nodes {
+		addresses[n.Id] = n.Address
+	}
+
+	return addresses
+}
+
+// Namespace settings, zero values fall back to the broker wide settings.
+type Namespace struct {
+	Name string `yaml:"name"`
+	// MessageTTL is the retention of acknowledged messages in the namespace
+	MessageTTL Duration `yaml:"message_ttl"`
+	// DedupWindow is how long message ids are remembered per topic of the namespace
+	DedupWindow Duration `yaml:"dedup_window"`
+	// MaxSubscriptions and MaxStorageBytes bound the subscriptions and stored payload bytes of the namespace
+	MaxSubscriptions int   `yaml:"max_subscriptions"`
+	MaxStorageBytes  int64 `yaml:"max_storage_bytes"`
+}
+
+// ForNamespace returns the config applying to the topics of the namespace.
+func (c Config) ForNamespace(namespace string) Config {
+	ns := c.Namespace(namespace)
+	if ns.MessageTTL > 0 {
+		c.Message.TTL = ns.MessageTTL
+	}
+	if ns.DedupWindow > 0 {
+		c.Message.DedupWindow = ns.DedupWindow
+	}
+
+	return c
+}
+
+// Namespace returns the settings of the namespace, the zero settings if it is not configured.
+func (c Config) Namespace(namespace string) Namespace {
+	for _, ns := range c.Namespaces {
+		if ns.Name == namespace {
+			return ns
+		}
+	}
+
+	return Namespace{Name: namespace}
+}
+
+// MirrorConfig configures a mirror copying topics from a source broker to a destination broker.
+type MirrorConfig struct {
+	Source      MirrorBroker `yaml:"source"`
+	Destination MirrorBroker `yaml:"destination"`
+	// Host is the url the source broker pushes to, e.g. http://localhost, and Port the port the mirror listens on
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// Topics lists the mirrored topics or wildcard patterns of the source with their name on the destination
+	Topics []MirrorTopic `yaml:"topics"`
+	// CheckpointFile keeps the progress of the mirror across restarts, disabled when empty
+	CheckpointFile string `yaml:"checkpoint_file"`
+	// CheckpointInterval is how often the checkpoint is written
+	CheckpointInterval Duration `yaml:"checkpoint_interval"`
+	// ResubscribeInterval is how often the subscriptions are renewed, the source stops pushing to a
+	// subscriber once a push failed until it subscribes again
+	ResubscribeInterval Duration `yaml:"resubscribe_interval"`
+}
+
+type MirrorBroker struct {
+	// Address is the url of the broker's api, e.g. http://localhost:9092
+	Address string `yaml:"address"`
+	APIKey  string `yaml:"api_key"`
+}
+
+// MirrorTopic renames the topics matching Source, a topic or a wildcard pattern. A topic is renamed
+// to Destination when set, otherwise to its own name with Prefix prepended.
+type MirrorTopic struct {
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+	Prefix      string `yaml:"prefix"`
 }
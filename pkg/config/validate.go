@@ -0,0 +1,204 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Default returns the config used for the settings missing from the config file.
+func Default() Config {
+	return Config{
+		Api: Api{Port: 9092},
+		Topic: Topic{
+			Buffer:          10,
+			StarvationLimit: 10,
+			TemporaryTTL:    Duration(time.Minute),
+		},
+		Message: Message{
+			TTL:                Duration(10 * time.Minute),
+			CleanupTime:        Duration(5 * time.Minute),
+			DedupWindow:        Duration(time.Hour),
+			DedupSize:          100000,
+			TransactionTimeout: Duration(time.Minute),
+		},
+		Subscriber: Subscriber{
+			RetryCount:    3,
+			RetryInterval: Duration(5 * time.Second),
+			CleanupTime:   Duration(5 * time.Minute),
+			Timeout:       Duration(2 * time.Second),
+			InactiveTime:  Duration(5 * time.Minute),
+			StateInterval: Duration(5 * time.Second),
+		},
+		Audit: Audit{
+			MaxSizeBytes: 100 << 20,
+			MaxBackups:   5,
+		},
+		Replication: Replication{
+			SyncTimeout: Duration(5 * time.Second),
+			LogSize:     100000,
+		},
+		Cluster: Cluster{
+			ElectionTimeout:   Duration(time.Second),
+			HeartbeatInterval: Duration(100 * time.Millisecond),
+		},
+	}
+}
+
+// Validate returns an error listing every invalid setting of the config.
+func (c Config) Validate() error {
+	v := validator{}
+
+	v.check(c.Api.Port > 0 && c.Api.Port <= 65535, "api.port must be between 1 and 65535, got %d", c.Api.Port)
+	v.tls("api.tls", c.Api.TLS)
+	v.check(c.Api.TLS.CAFile == "" || c.Api.TLS.CertFile != "", "api.tls.ca_file requires a cert_file")
+
+	v.check(c.Topic.Buffer >= 0, "topic.buffer can't be negative, got %d", c.Topic.Buffer)
+	v.check(c.Topic.StarvationLimit >= 0, "topic.starvation_limit can't be negative, got %d", c.Topic.StarvationLimit)
+	v.positive("topic.temporary_ttl", c.Topic.TemporaryTTL)
+
+	v.positive("message.cleanup_time", c.Message.CleanupTime)
+	v.notNegative("message.ttl", c.Message.TTL)
+	v.notNegative("message.dedup_window", c.Message.DedupWindow)
+	v.check(c.Message.DedupSize >= 0, "message.dedup_size can't be negative, got %d", c.Message.DedupSize)
+	v.notNegative("message.transaction_timeout", c.Message.TransactionTimeout)
+
+	v.check(c.Subscriber.RetryCount > 0, "subscriber.retry_count must be at least 1, got %d", c.Subscriber.RetryCount)
+	v.notNegative("subscriber.retry_interval", c.Subscriber.RetryInterval)
+	v.positive("subscriber.cleanup_time", c.Subscriber.CleanupTime)
+	v.positive("subscriber.timeout", c.Subscriber.Timeout)
+	v.notNegative("subscriber.inactive_time", c.Subscriber.InactiveTime)
+	v.tls("subscriber.tls", c.Subscriber.TLS)
+	if c.Subscriber.StateFile != "" {
+		v.positive("subscriber.state_interval", c.Subscriber.StateInterval)
+		// followers and cluster nodes rebuild their subscribers from the leader
+		v.check(c.Replication.Role != "follower" && len(c.Cluster.Nodes) == 0, "subscriber.state_file can't be used by a replication follower or a cluster node")
+	}
+
+	if c.Auth.Enabled {
+		v.check(len(c.Auth.APIKeys) > 0 || c.Auth.JWT.KeyFile != "", "auth is enabled but neither auth.api_keys nor auth.jwt.key_file are configured")
+	}
+	for i, k := range c.Auth.APIKeys {
+		v.check(k.Key != "" && k.Subject != "", "auth.api_keys[%d] must have a key and a subject", i)
+	}
+
+	for i, r := range c.ACL.Rules {
+		v.check(r.Subject != "", "acl.rules[%d] must have a subject", i)
+		v.check(len(r.Topics) > 0, "acl.rules[%d] must have topics", i)
+		v.check(len(r.Actions) > 0, "acl.rules[%d] must have actions", i)
+		for _, a := range r.Actions {
+			v.check(a == "publish" || a == "subscribe", "acl.rules[%d] action %q must be publish or subscribe", i, a)
+		}
+	}
+
+	for _, r := range []struct {
+		name string
+		rate Rate
+	}{{"principal", c.Limits.Principal}, {"topic", c.Limits.Topic}, {"ip", c.Limits.IP}} {
+		v.check(r.rate.Messages >= 0 && r.rate.Bytes >= 0, "limits.%s can't be negative", r.name)
+	}
+	v.check(c.Limits.MaxSubscriptions >= 0, "limits.max_subscriptions can't be negative, got %d", c.Limits.MaxSubscriptions)
+	v.check(c.Limits.MaxStorageBytes >= 0, "limits.max_storage_bytes can't be negative, got %d", c.Limits.MaxStorageBytes)
+
+	v.check(c.Audit.MaxSizeBytes >= 0, "audit.max_size_bytes can't be negative, got %d", c.Audit.MaxSizeBytes)
+	v.check(c.Audit.MaxBackups >= 0, "audit.max_backups can't be negative, got %d", c.Audit.MaxBackups)
+
+	switch c.Replication.Role {
+	case "", "leader":
+	case "follower":
+		v.check(c.Replication.Leader != "", "replication.leader is required for a follower")
+	default:
+		v.check(false, "replication.role must be leader or follower, got %q", c.Replication.Role)
+	}
+	v.notNegative("replication.sync_timeout", c.Replication.SyncTimeout)
+	v.check(c.Replication.LogSize >= 0, "replication.log_size can't be negative, got %d", c.Replication.LogSize)
+
+	if len(c.Cluster.Nodes) > 0 {
+		v.check(c.Replication.Role == "", "cluster and replication can't be both enabled")
+
+		ids := make(map[string]bool, len(c.Cluster.Nodes))
+		for i, n := range c.Cluster.Nodes {
+			v.check(n.Id != "" && n.Address != "", "cluster.nodes[%d] must have an id and an address", i)
+			v.check(!ids[n.Id], "cluster.nodes[%d] id %q is used twice", i, n.Id)
+			ids[n.Id] = true
+		}
+		v.check(ids[c.Cluster.NodeId], "cluster.node_id %q is not one of the nodes", c.Cluster.NodeId)
+		v.check(c.Cluster.APIKey != "", "cluster.api_key is required for the nodes to authenticate to each other")
+		v.positive("cluster.election_timeout", c.Cluster.ElectionTimeout)
+		v.check(c.Cluster.HeartbeatInterval > 0 && c.Cluster.HeartbeatInterval < c.Cluster.ElectionTimeout,
+			"cluster.heartbeat_interval must be positive and shorter than election_timeout, got %s", c.Cluster.HeartbeatInterval)
+		v.check(c.Cluster.SnapshotThreshold >= 0, "cluster.snapshot_threshold can't be negative, got %d", c.Cluster.SnapshotThreshold)
+	}
+
+	names := make(map[string]bool, len(c.Namespaces))
+	for i, ns := range c.Namespaces {
+		v.check(ns.Name != "", "namespaces[%d] must have a name", i)
+		v.check(!names[ns.Name], "namespaces[%d] name %q is used twice", i, ns.Name)
+		names[ns.Name] = true
+		v.notNegative(fmt.Sprintf("namespaces[%d].message_ttl", i), ns.MessageTTL)
+		v.notNegative(fmt.Sprintf("namespaces[%d].dedup_window", i), ns.DedupWindow)
+		v.check(ns.MaxSubscriptions >= 0 && ns.MaxStorageBytes >= 0, "namespaces[%d] quotas can't be negative", i)
+	}
+
+	if len(v.errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(v.errs...))
+	}
+
+	return nil
+}
+
+// DefaultMirror returns the mirror config used for the settings missing from the config file.
+func DefaultMirror() MirrorConfig {
+	return MirrorConfig{
+		CheckpointInterval:  Duration(time.Second),
+		ResubscribeInterval: Duration(5 * time.Second),
+	}
+}
+
+// Validate returns an error listing every invalid setting of the mirror config.
+func (c MirrorConfig) Validate() error {
+	v := validator{}
+
+	v.check(c.Source.Address != "", "source.address is required")
+	v.check(c.Destination.Address != "", "destination.address is required")
+	v.check(c.Source.Address == "" || c.Source.Address != c.Destination.Address, "source and destination can't be the same broker, got %s", c.Source.Address)
+	v.check(c.Host != "", "host is required for the source to push to the mirror")
+	v.check(c.Port > 0 && c.Port <= 65535, "port must be between 1 and 65535, got %d", c.Port)
+
+	v.check(len(c.Topics) > 0, "topics must list at least one topic to mirror")
+	for i, t := range c.Topics {
+		v.check(t.Source != "", "topics[%d] must have a source", i)
+	}
+
+	v.positive("checkpoint_interval", c.CheckpointInterval)
+	v.positive("resubscribe_interval", c.ResubscribeInterval)
+
+	if len(v.errs) > 0 {
+		return fmt.Errorf("invalid mirror config: %w", errors.Join(v.errs...))
+	}
+
+	return nil
+}
+
+// validator collects the errors of the invalid settings.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) check(ok bool, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf(format, args...))
+	}
+}
+
+func (v *validator) positive(name string, d Duration) {
+	v.check(d > 0, "%s must be a positive duration, e.g. 30s, got %s", name, d)
+}
+
+func (v *validator) notNegative(name string, d Duration) {
+	v.check(d >= 0, "%s can't be negative, got %s", name, d)
+}
+
+func (v *validator) tls(name string, t TLS) {
+	v.check((t.CertFile == "") == (t.KeyFile == ""), "%s.cert_file and %s.key_file must be set together", name, name)
+}
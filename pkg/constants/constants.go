@@ -1,6 +1,76 @@
 package constants
 
+import "time"
+
 const (
 	ConfigFlag        = "config"
 	DefaultConfigFile = "config.dist.yml"
+	HeaderPrefix      = "X-Flux-Header-"
+	IdHeader          = "X-Flux-Id"
+	TopicHeader       = "X-Flux-Topic"
+	DeliverAtHeader   = "X-Flux-Deliver-At"
+	DelayHeader       = "X-Flux-Delay"
+	TTLHeader         = "X-Flux-TTL"
+	PriorityHeader    = "X-Flux-Priority"
+	ReplyToHeader     = "X-Flux-Reply-To"
+	CorrelationHeader = "X-Flux-Correlation-Id"
+	ProducerHeader    = "X-Flux-Producer-Id"
+	SequenceHeader    = "X-Flux-Sequence"
+	APIKeyHeader      = "X-Flux-Api-Key"
+	SignatureHeader   = "X-Flux-Signature"
+	LeaderHeader      = "X-Flux-Leader"
+	TimestampHeader   = "X-Flux-Timestamp"
+	NonceHeader       = "X-Flux-Nonce"
+	PublishedAtHeader = "X-Flux-Published-At"
+	// SignatureTolerance is how far a signed delivery's timestamp may be from the subscriber's clock
+	SignatureTolerance = 5 * time.Minute
+	// IdentityKey is the gin context key holding the authenticated caller
+	IdentityKey = "flux.identity"
+	// ReplyTopicPrefix is the prefix of the temporary topics created for request/reply
+	ReplyTopicPrefix = "_reply."
+	// DefaultTemporaryTopicTTL is used when no ttl is configured for temporary topics
+	DefaultTemporaryTopicTTL = time.Minute
+	// DefaultDedupWindow and DefaultDedupSize bound the dedup window when not configured
+	DefaultDedupWindow = time.Hour
+	DefaultDedupSize   = 100000
+	// DefaultPublishRetries and DefaultPublishRetryInterval configure the publisher retries
+	DefaultPublishRetries       = 3
+	DefaultPublishRetryInterval = 200 * time.Millisecond
+	// DefaultTransactionTimeout is used when no transaction timeout is configured
+	DefaultTransactionTimeout = time.Minute
+	// CertReloadInterval is how often the tls certificate files are checked for changes
+	CertReloadInterval = 30 * time.Second
+	// MaxReplyWait bounds how long a reply request can be held open by the broker
+	MaxReplyWait = 5 * time.Minute
+	// ExpiredTopicHeader is the message header set on dead lettered messages with their original topic
+	ExpiredTopicHeader = "x-flux-expired-topic"
+	OctetStream        = "application/octet-stream"
+	// DefaultAuditBackups is the number of rotated audit files kept when not configured
+	DefaultAuditBackups = 5
+	// DefaultAuditQueryLimit is the number of audit events returned when the query sets no limit
+	DefaultAuditQueryLimit = 100
+	// RoleLeader and RoleFollower are the replication roles of a broker
+	RoleLeader   = "leader"
+	RoleFollower = "follower"
+	// DefaultReplicationLogSize and DefaultSyncTimeout are used when not configured
+	DefaultReplicationLogSize = 100000
+	DefaultSyncTimeout        = 5 * time.Second
+	// ReplicationPollWait is how long the leader holds a follower's fetch open when there are no new changes
+	ReplicationPollWait = 10 * time.Second
+	// ReplicationRetryInterval is how long a follower waits before fetching again after an error
+	ReplicationRetryInterval = time.Second
+	// DefaultElectionTimeout and DefaultHeartbeatInterval are the raft timings used when not configured
+	DefaultElectionTimeout   = time.Second
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+	// DefaultSnapshotThreshold is the number of raft entries applied between two compactions of the log
+	DefaultSnapshotThreshold = 10000
+	// ClusterCommitTimeout bounds how long a publish waits for a majority of the cluster to store it
+	ClusterCommitTimeout = 5 * time.Second
+	// DefaultMirrorCheckpointInterval and DefaultMirrorResubscribeInterval are used when not configured
+	DefaultMirrorCheckpointInterval  = time.Second
+	DefaultMirrorResubscribeInterval = 5 * time.Second
+	// DefaultStateInterval is how often the subscriber state is saved when not configured
+	DefaultStateInterval = 5 * time.Second
+	// SnapshotVersion is the version of the snapshot format written by the broker
+	SnapshotVersion = 1
 )
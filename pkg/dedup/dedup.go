@@ -0,0 +1,127 @@
+package dedup
+
+import (
+	"sync"
+	"time"
+)
+
+// Window remembers the message ids seen within a time window and up to a maximum count,
+// older ids are evicted so a legitimate id reuse outside the window is accepted again.
+type Window struct {
+	lock    sync.Mutex
+	maxAge  time.Duration
+	maxSize int
+	seen    map[string]time.Time
+	// order holds the ids in insertion order, the oldest at head
+	order []entry
+	head  int
+}
+
+type entry struct {
+	id     string
+	seenAt time.Time
+}
+
+// Entry is an id inside the window with the time it was added.
+type Entry struct {
+	Id     string
+	SeenAt time.Time
+}
+
+// NewWindow creates a dedup window, a zero maxAge or maxSize disables that bound.
+func NewWindow(maxAge time.Duration, maxSize int) *Window {
+	return &Window{
+		maxAge:  maxAge,
+		maxSize: maxSize,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// Seen reports whether the id is inside the window.
+func (w *Window) Seen(id string, now time.Time) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.evict(now)
+	_, ok := w.seen[id]
+
+	return ok
+}
+
+// Add records the id, it returns false if the id was already inside the window.
+func (w *Window) Add(id string, now time.Time) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.evict(now)
+	if _, ok := w.seen[id]; ok {
+		return false
+	}
+
+	w.seen[id] = now
+	w.order = append(w.order, entry{id: id, seenAt: now})
+	w.evict(now)
+
+	return true
+}
+
+// Evict drops the ids that fell out of the window.
+func (w *Window) Evict(now time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.evict(now)
+}
+
+// Entries returns the ids inside the window, the oldest first. Adding them in order to another
+// window with their SeenAt as the time restores the window.
+func (w *Window) Entries() []Entry {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	entries := make([]Entry, 0, len(w.seen))
+	for _, e := range w.order[w.head:] {
+		entries = append(entries, Entry{Id: e.id, SeenAt: e.seenAt})
+	}
+
+	return entries
+}
+
+// Resize changes the bounds of the window, the ids outside the new bounds are evicted.
+func (w *Window) Resize(maxAge time.Duration, maxSize int, now time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.maxAge = maxAge
+	w.maxSize = maxSize
+	w.evict(now)
+}
+
+func (w *Window) Len() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return len(w.seen)
+}
+
+// evict must be called with the lock held.
+func (w *Window) evict(now time.Time) {
+	for w.head < len(w.order) {
+		oldest := w.order[w.head]
+		tooOld := w.maxAge > 0 && now.Sub(oldest.seenAt) >= w.maxAge
+		tooMany := w.maxSize > 0 && len(w.seen) > w.maxSize
+		if !tooOld && !tooMany {
+			break
+		}
+
+		delete(w.seen, oldest.id)
+		w.order[w.head] = entry{}
+		w.head++
+	}
+
+	// compact once the evicted prefix dominates the slice
+	if w.head > 0 && w.head*2 >= len(w.order) {
+		w.order = append([]entry(nil), w.order[w.head:]...)
+		w.head = 0
+	}
+}
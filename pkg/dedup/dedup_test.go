@@ -0,0 +1,100 @@
+package dedup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAdd(t *testing.T) {
+	w := NewWindow(time.Minute, 0)
+	now := time.Now()
+
+	if !w.Add("1", now) {
+		t.Error("First add should be accepted")
+	}
+
+	if w.Add("1", now) {
+		t.Error("Duplicate add should be rejected")
+	}
+
+	if !w.Seen("1", now) || w.Seen("2", now) {
+		t.Error("Seen should only report added ids")
+	}
+}
+
+func TestEvictByAge(t *testing.T) {
+	w := NewWindow(time.Minute, 0)
+	now := time.Now()
+
+	w.Add("old", now)
+	w.Add("new", now.Add(30*time.Second))
+
+	later := now.Add(time.Minute)
+	if w.Seen("old", later) {
+		t.Error("Id older than the window should be evicted")
+	}
+
+	if !w.Seen("new", later) {
+		t.Error("Id inside the window should be kept")
+	}
+
+	if !w.Add("old", later) {
+		t.Error("Evicted id should be accepted again")
+	}
+}
+
+func TestEvictBySize(t *testing.T) {
+	w := NewWindow(0, 3)
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		w.Add(fmt.Sprintf("%d", i), now)
+	}
+
+	if w.Len() != 3 {
+		t.Errorf("Expected 3 ids in the window, got %d", w.Len())
+	}
+
+	if w.Seen("6", now) || !w.Seen("7", now) || !w.Seen("9", now) {
+		t.Error("Only the most recent ids should be kept")
+	}
+}
+
+func TestEntriesRestoreWindow(t *testing.T) {
+	w := NewWindow(time.Minute, 0)
+	now := time.Now()
+
+	w.Add("old", now)
+	w.Add("new", now.Add(30*time.Second))
+
+	restored := NewWindow(time.Minute, 0)
+	for _, e := range w.Entries() {
+		restored.Add(e.Id, e.SeenAt)
+	}
+
+	later := now.Add(time.Minute)
+	if restored.Seen("old", later) || !restored.Seen("new", later) {
+		t.Error("Restored window should keep the times the ids were added")
+	}
+
+	if entries := w.Entries(); len(entries) != 2 || entries[0].Id != "old" {
+		t.Errorf("Expected entries oldest first, got %v", entries)
+	}
+}
+
+func TestResize(t *testing.T) {
+	w := NewWindow(time.Hour, 0)
+	now := time.Now()
+
+	w.Add("old", now)
+	w.Add("1", now.Add(30*time.Second))
+	w.Add("2", now.Add(40*time.Second))
+	w.Add("3", now.Add(50*time.Second))
+
+	w.Resize(45*time.Second, 1, now.Add(time.Minute))
+
+	if w.Len() != 1 || !w.Seen("3", now.Add(time.Minute)) {
+		t.Errorf("Expected only the most recent id inside the new bounds, got %d ids", w.Len())
+	}
+}
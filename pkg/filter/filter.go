@@ -0,0 +1,194 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/NamanBalaji/flux/pkg/message"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+// Filter is a parsed subscription filter expression evaluated against message headers.
+//
+// Identifiers refer to header names, $id and $contentType refer to the message id and content type.
+// Supported expressions:
+//
+//	region = 'eu' AND (type IN ('created', 'updated') OR priority >= 5)
+//	source PREFIX 'billing.' AND NOT debug EXISTS
+type Filter struct {
+	expr string
+	root node
+}
+
+// Parse parses the filter expression, an empty expression matches every message.
+func Parse(expr string) (*Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected token %q at position %d", p.peek().value, p.peek().pos)
+	}
+
+	return &Filter{expr: expr, root: root}, nil
+}
+
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+
+	return f.expr
+}
+
+// Match reports whether the message satisfies the filter, a nil filter matches everything.
+func (f *Filter) Match(msg *message.Message) bool {
+	if f == nil {
+		return true
+	}
+
+	return f.root.eval(func(name string) (string, bool) {
+		switch name {
+		case "$id":
+			return msg.Id, true
+		case "$contentType":
+			return msg.ContentType, msg.ContentType != ""
+		}
+
+		v, ok := msg.Headers[request.HeaderName(name)]
+
+		return v, ok
+	})
+}
+
+type lookupFunc func(name string) (string, bool)
+
+type node interface {
+	eval(lookup lookupFunc) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(lookup lookupFunc) bool { return n.left.eval(lookup) && n.right.eval(lookup) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(lookup lookupFunc) bool { return n.left.eval(lookup) || n.right.eval(lookup) }
+
+type notNode struct{ inner node }
+
+func (n notNode) eval(lookup lookupFunc) bool { return !n.inner.eval(lookup) }
+
+type existsNode struct{ name string }
+
+func (n existsNode) eval(lookup lookupFunc) bool {
+	_, ok := lookup(n.name)
+
+	return ok
+}
+
+type prefixNode struct{ name, prefix string }
+
+func (n prefixNode) eval(lookup lookupFunc) bool {
+	v, ok := lookup(n.name)
+
+	return ok && strings.HasPrefix(v, n.prefix)
+}
+
+type inNode struct {
+	name   string
+	values []literal
+}
+
+func (n inNode) eval(lookup lookupFunc) bool {
+	v, ok := lookup(n.name)
+	if !ok {
+		return false
+	}
+
+	for _, l := range n.values {
+		if compare(v, "=", l) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type compareNode struct {
+	name  string
+	op    string
+	value literal
+}
+
+func (n compareNode) eval(lookup lookupFunc) bool {
+	v, ok := lookup(n.name)
+	if !ok {
+		return false
+	}
+
+	return compare(v, n.op, n.value)
+}
+
+type literal struct {
+	str       string
+	num       float64
+	isNumeric bool
+}
+
+// compare compares numerically when the literal is a number and the header parses as one,
+// otherwise it falls back to string comparison.
+func compare(v string, op string, l literal) bool {
+	if l.isNumeric {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return op == "!="
+		}
+
+		switch op {
+		case "=":
+			return f == l.num
+		case "!=":
+			return f != l.num
+		case "<":
+			return f < l.num
+		case "<=":
+			return f <= l.num
+		case ">":
+			return f > l.num
+		case ">=":
+			return f >= l.num
+		}
+
+		return false
+	}
+
+	switch op {
+	case "=":
+		return v == l.str
+	case "!=":
+		return v != l.str
+	case "<":
+		return v < l.str
+	case "<=":
+		return v <= l.str
+	case ">":
+		return v > l.str
+	case ">=":
+		return v >= l.str
+	}
+
+	return false
+}
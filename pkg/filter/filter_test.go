@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/NamanBalaji/flux/pkg/message"
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+func newMessage(headers map[string]string) *message.Message {
+	msg := message.NewMessage("order-1", []byte("payload"))
+	msg.SetHeaders(headers)
+
+	return msg
+}
+
+func TestMatch(t *testing.T) {
+	msg := newMessage(map[string]string{
+		"region":   "eu-west",
+		"type":     "created",
+		"priority": "7",
+	})
+
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{"", true},
+		{"region = 'eu-west'", true},
+		{"region != 'eu-west'", false},
+		{"region PREFIX 'eu'", true},
+		{"region PREFIX 'us'", false},
+		{"type IN ('created', 'updated')", true},
+		{"type IN ('deleted')", false},
+		{"priority > 5", true},
+		{"priority <= 5", false},
+		{"priority = 7.0", true},
+		{"region = 'us' OR priority >= 7", true},
+		{"region = 'eu-west' AND type = 'deleted'", false},
+		{"NOT (region = 'us')", true},
+		{"missing = 'x'", false},
+		{"missing EXISTS", false},
+		{"NOT missing EXISTS AND region EXISTS", true},
+		{"$id PREFIX 'order-'", true},
+		{"region = \"eu-west\" and (type = 'x' or type = 'created')", true},
+	}
+
+	for _, tt := range tests {
+		f, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q) returned an error: %s", tt.expr, err)
+			continue
+		}
+
+		if f.Match(msg) != tt.match {
+			t.Errorf("Match(%q) expected %v", tt.expr, tt.match)
+		}
+	}
+}
+
+func TestMatch_NonNumericHeader(t *testing.T) {
+	msg := newMessage(map[string]string{"priority": "high"})
+
+	f, err := Parse("priority > 5")
+	if err != nil {
+		t.Fatalf("Parse returned an error: %s", err)
+	}
+
+	if f.Match(msg) {
+		t.Error("Non numeric header should not match a numeric comparison")
+	}
+}
+
+func TestMatch_HeaderNameCase(t *testing.T) {
+	// the same header published in the JSON body and as a raw publish HTTP header
+	published := newMessage(map[string]string{"X-Tenant": "a"})
+
+	header := http.Header{}
+	header.Set("X-Flux-Header-X-Tenant", "a")
+	raw := newMessage(request.HeadersFromHTTP(header))
+
+	for _, expr := range []string{"X-Tenant = 'a'", "x-tenant = 'a'", "x-TENANT EXISTS"} {
+		f, err := Parse(expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) returned an error: %s", expr, err)
+		}
+
+		if !f.Match(published) || !f.Match(raw) {
+			t.Errorf("Expected %q to match the header on both publish paths", expr)
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	invalid := []string{
+		"region =",
+		"region 'eu'",
+		"(region = 'eu'",
+		"region = 'eu",
+		"type IN ('a' 'b')",
+		"region = 'eu' extra",
+		"= 'eu'",
+		"region ! 'eu'",
+	}
+
+	for _, expr := range invalid {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) should have returned an error", expr)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokString
+	tokNumber
+	tokOperator
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, value: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, value: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokComma, value: ",", pos: i})
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexRune(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokString, value: expr[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(expr) && expr[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected character '!' at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokOperator, value: op, pos: i})
+			i += len(op)
+		case c == '-' || unicode.IsDigit(c):
+			start := i
+			i++
+			for i < len(expr) && (unicode.IsDigit(rune(expr[i])) || expr[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, value: expr[start:i], pos: start})
+		case c == '$' || c == '_' || unicode.IsLetter(c):
+			start := i
+			i++
+			for i < len(expr) && isIdentChar(rune(expr[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, value: expr[start:i], pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+func isIdentChar(c rune) bool {
+	return c == '_' || c == '-' || c == '.' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{}
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() (token, error) {
+	if p.done() {
+		return token{}, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+
+	return t, nil
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	t := p.peek()
+
+	return !p.done() && t.kind == tokIdent && strings.EqualFold(t.value, keyword)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("AND") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isKeyword("NOT") {
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notNode{inner: inner}, nil
+	}
+
+	if !p.done() && p.peek().kind == tokLParen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != tokRParen {
+			return nil, fmt.Errorf("expected ')' at position %d", t.pos)
+		}
+
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	ident, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if ident.kind != tokIdent {
+		return nil, fmt.Errorf("expected header name at position %d, got %q", ident.pos, ident.value)
+	}
+
+	switch {
+	case p.isKeyword("EXISTS"):
+		p.pos++
+
+		return existsNode{name: ident.value}, nil
+	case p.isKeyword("PREFIX"):
+		p.pos++
+		l, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+
+		return prefixNode{name: ident.value, prefix: l.str}, nil
+	case p.isKeyword("IN"):
+		p.pos++
+
+		return p.parseIn(ident.value)
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if op.kind != tokOperator {
+		return nil, fmt.Errorf("expected operator after %s at position %d, got %q", ident.value, op.pos, op.value)
+	}
+
+	l, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+
+	return compareNode{name: ident.value, op: op.value, value: l}, nil
+}
+
+func (p *parser) parseIn(name string) (node, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokLParen {
+		return nil, fmt.Errorf("expected '(' after IN at position %d", t.pos)
+	}
+
+	n := inNode{name: name}
+	for {
+		l, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		n.values = append(n.values, l)
+
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if t.kind == tokRParen {
+			return n, nil
+		}
+		if t.kind != tokComma {
+			return nil, fmt.Errorf("expected ',' or ')' at position %d", t.pos)
+		}
+	}
+}
+
+func (p *parser) parseLiteral() (literal, error) {
+	t, err := p.next()
+	if err != nil {
+		return literal{}, err
+	}
+
+	switch t.kind {
+	case tokString:
+		return literal{str: t.value}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return literal{}, fmt.Errorf("invalid number %q at position %d", t.value, t.pos)
+		}
+
+		return literal{str: t.value, num: f, isNumeric: true}, nil
+	}
+
+	return literal{}, fmt.Errorf("expected a string or number at position %d, got %q", t.pos, t.value)
+}
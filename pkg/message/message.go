@@ -6,17 +6,29 @@ import (
 	"time"
 
 	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/request"
 )
 
 type Message struct {
-	Lock      sync.Mutex
-	Id        string `json:"id"`
-	Payload   string `json:"payload"`
+	Lock        sync.Mutex
+	Id          string            `json:"id"`
+	Payload     []byte            `json:"payload"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	// TTL is the producer chosen lifetime of the message counted from AddedAt, zero means no expiry
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Priority orders delivery on priority topics, higher values are delivered first
+	Priority int `json:"priority,omitempty"`
+	// ReplyTo is the topic the consumer should publish its reply to, matched by CorrelationId
+	ReplyTo       string `json:"replyTo,omitempty"`
+	CorrelationId string `json:"correlationId,omitempty"`
+	// Owner is the tenant which published the message, its size counts toward the tenant's storage quota
+	Owner     string `json:"-"`
 	Delivered map[string]bool
 	AddedAt   time.Time
 }
 
-func NewMessage(id string, payload string) *Message {
+func NewMessage(id string, payload []byte) *Message {
 	return &Message{
 		Id:        id,
 		Payload:   payload,
@@ -25,6 +37,51 @@ func NewMessage(id string, payload string) *Message {
 	}
 }
 
+// FromReplicated creates the message replicated, or saved, by a broker.
+func FromReplicated(r *request.ReplicatedMessage) *Message {
+	msg := NewMessage(r.Id, r.Payload)
+	msg.ContentType = r.ContentType
+	msg.SetHeaders(r.Headers)
+	msg.TTL = r.TTL
+	msg.Priority = r.Priority
+	msg.ReplyTo = r.ReplyTo
+	msg.CorrelationId = r.CorrelationId
+	msg.Owner = r.Owner
+	msg.AddedAt = r.AddedAt
+
+	return msg
+}
+
+// Replicated returns the message as it's replicated, or saved, by a broker.
+func (m *Message) Replicated() *request.ReplicatedMessage {
+	return &request.ReplicatedMessage{
+		Id:            m.Id,
+		Payload:       m.Payload,
+		ContentType:   m.ContentType,
+		Headers:       m.Headers,
+		TTL:           m.TTL,
+		Priority:      m.Priority,
+		ReplyTo:       m.ReplyTo,
+		CorrelationId: m.CorrelationId,
+		Owner:         m.Owner,
+		AddedAt:       m.AddedAt,
+	}
+}
+
+// SetHeaders stores a copy of the given headers on the message so later
+// changes to the caller's map are not visible to subscribers. Header names
+// are normalized with request.HeaderName.
+func (m *Message) SetHeaders(headers map[string]string) {
+	if len(headers) == 0 {
+		return
+	}
+
+	m.Headers = make(map[string]string, len(headers))
+	for k, v := range headers {
+		m.Headers[request.HeaderName(k)] = v
+	}
+}
+
 func (m *Message) Ack(subscriberAddress string) {
 	m.Lock.Lock()
 	defer m.Lock.Unlock()
@@ -33,6 +90,27 @@ func (m *Message) Ack(subscriberAddress string) {
 	log.Printf("Acked messageID %s to subscriber[address: %s] \n", m.Id, subscriberAddress)
 }
 
+// Acked reports whether the subscriber acknowledged the message.
+func (m *Message) Acked(subscriberAddress string) bool {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	return m.Delivered[subscriberAddress]
+}
+
+// Deliveries returns a copy of the delivery state of the subscribers tracking the message.
+func (m *Message) Deliveries() map[string]bool {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	deliveries := make(map[string]bool, len(m.Delivered))
+	for addr, acked := range m.Delivered {
+		deliveries[addr] = acked
+	}
+
+	return deliveries
+}
+
 func (m *Message) AddSubscriber(subscriberAddress string) {
 	m.Lock.Lock()
 	defer m.Lock.Unlock()
@@ -49,9 +127,19 @@ func (m *Message) RemoveSubscriber(subscriberAddress string) {
 	log.Printf("messageID %s not tracking ACK from subscriber[address: %s] \n", m.Id, subscriberAddress)
 }
 
+// Expired reports whether the message outlived its producer set TTL.
+func (m *Message) Expired(now time.Time) bool {
+	return m.TTL > 0 && now.Sub(m.AddedAt) >= m.TTL
+}
+
 func (m *Message) SafeToDelete(cfg config.Config) bool {
 	allAcked := true
 
+	// expired messages are never delivered so they don't need to wait for the acks
+	if m.Expired(time.Now()) {
+		return true
+	}
+
 	m.Lock.Lock()
 	defer m.Lock.Unlock()
 
@@ -63,5 +151,5 @@ func (m *Message) SafeToDelete(cfg config.Config) bool {
 		}
 	}
 
-	return allAcked && time.Now().Sub(m.AddedAt).Seconds() >= float64(cfg.Message.TTL)
+	return allAcked && time.Now().Sub(m.AddedAt) >= time.Duration(cfg.Message.TTL)
 }
@@ -1,6 +1,7 @@
 package message
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
 	"testing"
@@ -11,10 +12,10 @@ import (
 
 func TestNewMessage(t *testing.T) {
 	id := "msg1"
-	payload := "Hello, World!"
+	payload := []byte("Hello, World!")
 	msg := NewMessage(id, payload)
 
-	if msg.Id != id || msg.Payload != payload {
+	if msg.Id != id || !bytes.Equal(msg.Payload, payload) {
 		t.Errorf("Message fields not initialized correctly. Got id %s and payload %s", msg.Id, msg.Payload)
 	}
 
@@ -24,7 +25,7 @@ func TestNewMessage(t *testing.T) {
 }
 
 func TestAck(t *testing.T) {
-	msg := NewMessage("msg1", "test payload")
+	msg := NewMessage("msg1", []byte("test payload"))
 	subscriber := "sub1"
 	msg.AddSubscriber(subscriber)
 	msg.Ack(subscriber)
@@ -35,7 +36,7 @@ func TestAck(t *testing.T) {
 }
 
 func TestConcurrentAcks(t *testing.T) {
-	msg := NewMessage("msg1", "test payload")
+	msg := NewMessage("msg1", []byte("test payload"))
 	subscriberCount := 100
 
 	var wg sync.WaitGroup
@@ -64,7 +65,7 @@ func TestConcurrentAcks(t *testing.T) {
 }
 
 func TestAddAndRemoveSubscriber(t *testing.T) {
-	msg := NewMessage("msg1", "test payload")
+	msg := NewMessage("msg1", []byte("test payload"))
 	subscriber := "sub1"
 	msg.AddSubscriber(subscriber)
 
@@ -79,7 +80,7 @@ func TestAddAndRemoveSubscriber(t *testing.T) {
 }
 
 func TestConcurrentAddRemoveSubscribersIndependent(t *testing.T) {
-	msg := NewMessage("msg1", "test payload")
+	msg := NewMessage("msg1", []byte("test payload"))
 	subscriberCount := 100
 
 	var wg sync.WaitGroup
@@ -103,13 +104,13 @@ func TestConcurrentAddRemoveSubscribersIndependent(t *testing.T) {
 }
 
 func TestSafeToDelete(t *testing.T) {
-	msg := NewMessage("msg1", "test payload")
+	msg := NewMessage("msg1", []byte("test payload"))
 	msg.AddedAt = time.Now().Add(-time.Hour)
 	msg.AddSubscriber("sub1")
 	msg.Ack("sub1")
 
 	cfg := config.Config{
-		Message: config.Message{TTL: 1},
+		Message: config.Message{TTL: config.Seconds(1)},
 	}
 
 	if !msg.SafeToDelete(cfg) {
@@ -118,11 +119,11 @@ func TestSafeToDelete(t *testing.T) {
 }
 
 func TestNotSafeToDelete_MessageUnAcked(t *testing.T) {
-	msg := NewMessage("msg1", "test payload")
+	msg := NewMessage("msg1", []byte("test payload"))
 	msg.AddSubscriber("sub1")
 
 	cfg := config.Config{
-		Message: config.Message{TTL: 1},
+		Message: config.Message{TTL: config.Seconds(1)},
 	}
 
 	if msg.SafeToDelete(cfg) {
@@ -131,15 +132,58 @@ func TestNotSafeToDelete_MessageUnAcked(t *testing.T) {
 }
 
 func TestNotSafeToDelete_MessageNotExpired(t *testing.T) {
-	msg := NewMessage("msg1", "test payload")
+	msg := NewMessage("msg1", []byte("test payload"))
 	msg.AddSubscriber("sub1")
 	msg.Ack("sub1")
 
 	cfg := config.Config{
-		Message: config.Message{TTL: 40},
+		Message: config.Message{TTL: config.Seconds(40)},
 	}
 
 	if msg.SafeToDelete(cfg) {
 		t.Errorf("SafeToDelete incorrectly returned true for undelivered message")
 	}
 }
+
+func TestSetHeaders(t *testing.T) {
+	msg := NewMessage("msg1", []byte("test payload"))
+	headers := map[string]string{"content-type": "application/json"}
+	msg.SetHeaders(headers)
+
+	headers["content-type"] = "text/plain"
+
+	if msg.Headers["content-type"] != "application/json" {
+		t.Errorf("SetHeaders should copy the headers, got %s", msg.Headers["content-type"])
+	}
+}
+
+func TestExpired(t *testing.T) {
+	msg := NewMessage("msg1", []byte("test payload"))
+	if msg.Expired(time.Now().Add(time.Hour)) {
+		t.Error("Message without ttl should never expire")
+	}
+
+	msg.TTL = time.Minute
+	if msg.Expired(time.Now()) {
+		t.Error("Message should not be expired before its ttl")
+	}
+
+	if !msg.Expired(time.Now().Add(time.Minute)) {
+		t.Error("Message should be expired after its ttl")
+	}
+}
+
+func TestSafeToDelete_ExpiredUnAcked(t *testing.T) {
+	msg := NewMessage("msg1", []byte("test payload"))
+	msg.AddSubscriber("sub1")
+	msg.TTL = time.Millisecond
+	msg.AddedAt = time.Now().Add(-time.Second)
+
+	cfg := config.Config{
+		Message: config.Message{TTL: config.Seconds(40)},
+	}
+
+	if !msg.SafeToDelete(cfg) {
+		t.Errorf("SafeToDelete should return true for expired message")
+	}
+}
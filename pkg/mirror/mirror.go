@@ -0,0 +1,339 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/broker/topic"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/request"
+	"github.com/NamanBalaji/flux/pkg/signature"
+	"github.com/NamanBalaji/flux/publisher"
+)
+
+var ErrNotMirrored = errors.New("topic is not mirrored")
+
+// Progress is the mirroring progress of a source topic.
+type Progress struct {
+	Topic            string `json:"topic"`
+	DestinationTopic string `json:"destinationTopic"`
+	// LastId is the id of the last message mirrored
+	LastId     string `json:"lastId,omitempty"`
+	Mirrored   uint64 `json:"mirrored"`
+	Duplicates uint64 `json:"duplicates"`
+	Failed     uint64 `json:"failed"`
+	// LastPublishedAt and LastMirroredAt are when the last message was published on the source and on the destination
+	LastPublishedAt time.Time `json:"lastPublishedAt,omitempty"`
+	LastMirroredAt  time.Time `json:"lastMirroredAt,omitempty"`
+	// LagSeconds is how long after its publication on the source the last message was mirrored
+	LagSeconds float64 `json:"lagSeconds"`
+}
+
+// checkpoint is the state of the mirror kept across restarts.
+type checkpoint struct {
+	// Secret signs the pushes of the source, it's kept so the existing subscriptions stay valid
+	Secret string `json:"secret"`
+	// Subscribed lists the sources already subscribed to, they don't read the old messages again
+	Subscribed map[string]bool      `json:"subscribed"`
+	Topics     map[string]*Progress `json:"topics"`
+}
+
+// Mirror republishes the messages pushed by the source broker to the destination broker. A push is
+// acknowledged once the destination accepted the message, so the source pushes each topic in order
+// and pushes again what failed.
+type Mirror struct {
+	mu        sync.Mutex
+	cfg       config.MirrorConfig
+	publisher *publisher.Publisher
+	verifier  *signature.Verifier
+	state     checkpoint
+	// dirty is set when the state changed since the last checkpoint
+	dirty bool
+	now   func() time.Time
+}
+
+// New creates the mirror, restoring its checkpoint when there is one.
+func New(cfg config.MirrorConfig) (*Mirror, error) {
+	if len(cfg.Topics) == 0 {
+		return nil, errors.New("no topic to mirror")
+	}
+	for _, t := range cfg.Topics {
+		if topic.IsPattern(t.Source) && t.Destination != "" {
+			return nil, fmt.Errorf("pattern %s can't be renamed to a single topic, use a prefix", t.Source)
+		}
+	}
+
+	m := &Mirror{
+		cfg:       cfg,
+		publisher: publisher.NewPublisher(cfg.Destination.Address),
+		state: checkpoint{
+			Subscribed: make(map[string]bool),
+			Topics:     make(map[string]*Progress),
+		},
+		now: time.Now,
+	}
+	m.publisher.SetCredentials(request.Credentials{APIKey: cfg.Destination.APIKey})
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if m.state.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			return nil, err
+		}
+		m.state.Secret = secret
+		m.dirty = true
+	}
+	m.verifier = signature.NewVerifier([]byte(m.state.Secret), constants.SignatureTolerance)
+
+	return m, nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate the subscription secret: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (m *Mirror) load() error {
+	if m.cfg.CheckpointFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.cfg.CheckpointFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint %s: %w", m.cfg.CheckpointFile, err)
+	}
+
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		return fmt.Errorf("invalid checkpoint %s: %w", m.cfg.CheckpointFile, err)
+	}
+	if m.state.Subscribed == nil {
+		m.state.Subscribed = make(map[string]bool)
+	}
+	if m.state.Topics == nil {
+		m.state.Topics = make(map[string]*Progress)
+	}
+
+	log.Printf("Restored mirror checkpoint of %d topics \n", len(m.state.Topics))
+
+	return nil
+}
+
+// Checkpoint writes the progress to the checkpoint file if it changed.
+func (m *Mirror) Checkpoint() error {
+	if m.cfg.CheckpointFile == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	if !m.dirty {
+		m.mu.Unlock()
+
+		return nil
+	}
+	data, err := json.Marshal(m.state)
+	m.dirty = false
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := m.cfg.CheckpointFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	return os.Rename(tmp, m.cfg.CheckpointFile)
+}
+
+// Destination returns the name of the source topic on the destination, ErrNotMirrored when it
+// doesn't match any mirrored topic.
+func (m *Mirror) Destination(name string) (string, error) {
+	for _, t := range m.cfg.Topics {
+		if !topic.MatchPattern(t.Source, name) {
+			continue
+		}
+		if t.Destination != "" {
+			return t.Destination, nil
+		}
+
+		return t.Prefix + name, nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrNotMirrored, name)
+}
+
+// Verify checks that the push was signed by the source with the subscription secret.
+func (m *Mirror) Verify(header http.Header, body []byte) error {
+	return m.verifier.Verify(header, body, m.now())
+}
+
+// Mirror publishes the message to the destination, a message the destination already has is
+// counted as a duplicate.
+func (m *Mirror) Mirror(msg request.PollMessage) error {
+	destination, err := m.Destination(msg.Topic)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.publisher.Forward(destination, msg)
+	duplicate := errors.Is(err, publisher.ErrDuplicate)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	progress, ok := m.state.Topics[msg.Topic]
+	if !ok {
+		progress = &Progress{Topic: msg.Topic}
+		m.state.Topics[msg.Topic] = progress
+	}
+	progress.DestinationTopic = destination
+	m.dirty = true
+
+	if err != nil && !duplicate {
+		progress.Failed++
+
+		return fmt.Errorf("failed to mirror message %s to %s: %w", msg.Id, destination, err)
+	}
+
+	now := m.now()
+	if duplicate {
+		progress.Duplicates++
+	} else {
+		progress.Mirrored++
+	}
+	progress.LastId = msg.Id
+	progress.LastMirroredAt = now
+	if !msg.PublishedAt.IsZero() {
+		progress.LastPublishedAt = msg.PublishedAt
+		progress.LagSeconds = now.Sub(msg.PublishedAt).Seconds()
+	}
+
+	return nil
+}
+
+// Subscribe subscribes to the mirrored topics of the source, sources subscribed for the first time
+// read the messages the source still holds.
+func (m *Mirror) Subscribe(ctx context.Context) error {
+	var errs []error
+	for _, t := range m.cfg.Topics {
+		m.mu.Lock()
+		readOld := !m.state.Subscribed[t.Source]
+		m.mu.Unlock()
+
+		if err := m.subscribe(ctx, t.Source, readOld); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if readOld {
+			m.mu.Lock()
+			m.state.Subscribed[t.Source] = true
+			m.dirty = true
+			m.mu.Unlock()
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *Mirror) subscribe(ctx context.Context, source string, readOld bool) error {
+	body, err := json.Marshal(request.RegisterSubscriberRequest{
+		Address: fmt.Sprintf("%s:%d", m.cfg.Host, m.cfg.Port),
+		Topics:  []string{source},
+		ReadOld: readOld,
+		Secret:  m.state.Secret,
+	})
+	if err != nil {
+		return err
+	}
+
+	credentials := request.Credentials{APIKey: m.cfg.Source.APIKey}
+	_, status, err := request.SendAuthenticatedRequest(ctx, http.MethodPost, m.cfg.Source.Address+"/subscribe", bytes.NewReader(body), credentials)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", source, err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("subscribing to %s failed with status code %d", source, status)
+	}
+
+	return nil
+}
+
+// Run renews the subscriptions and writes the checkpoint until the context is done, the
+// checkpoint is written a last time before it returns.
+func (m *Mirror) Run(ctx context.Context) {
+	resubscribe := time.Duration(m.cfg.ResubscribeInterval)
+	if resubscribe <= 0 {
+		resubscribe = constants.DefaultMirrorResubscribeInterval
+	}
+	checkpoint := time.Duration(m.cfg.CheckpointInterval)
+	if checkpoint <= 0 {
+		checkpoint = constants.DefaultMirrorCheckpointInterval
+	}
+
+	subscribeTicker := time.NewTicker(resubscribe)
+	defer subscribeTicker.Stop()
+	checkpointTicker := time.NewTicker(checkpoint)
+	defer checkpointTicker.Stop()
+
+	for {
+		if err := m.Subscribe(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to subscribe to the source [ERROR]: %s", err)
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				if err := m.Checkpoint(); err != nil {
+					log.Printf("failed to write the mirror checkpoint [ERROR]: %s", err)
+				}
+
+				return
+			case <-checkpointTicker.C:
+				if err := m.Checkpoint(); err != nil {
+					log.Printf("failed to write the mirror checkpoint [ERROR]: %s", err)
+				}
+			case <-subscribeTicker.C:
+				waiting = false
+			}
+		}
+	}
+}
+
+// Stats returns the progress of every mirrored topic sorted by name.
+func (m *Mirror) Stats() []Progress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make([]Progress, 0, len(m.state.Topics))
+	for _, p := range m.state.Topics {
+		stats = append(stats, *p)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Topic < stats[j].Topic
+	})
+
+	return stats
+}
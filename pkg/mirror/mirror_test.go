@@ -0,0 +1,203 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/request"
+	"github.com/NamanBalaji/flux/pkg/signature"
+)
+
+// fakeBroker records the publishes and subscriptions it receives.
+type fakeBroker struct {
+	mu         sync.Mutex
+	published  []request.PublishMessageRequest
+	subscribed []request.RegisterSubscriberRequest
+	seen       map[string]bool
+	fail       bool
+}
+
+func newFakeBroker(t *testing.T) (*fakeBroker, *httptest.Server) {
+	f := &fakeBroker{seen: make(map[string]bool)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/publish":
+			if f.fail {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+			var req request.PublishMessageRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			key := req.Topic + "/" + req.Id
+			response := request.PublishResponse{Id: req.Id, Duplicate: f.seen[key]}
+			if !f.seen[key] {
+				f.seen[key] = true
+				f.published = append(f.published, req)
+			}
+			json.NewEncoder(w).Encode(response)
+		case "/subscribe":
+			var req request.RegisterSubscriberRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			f.subscribed = append(f.subscribed, req)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return f, server
+}
+
+func TestDestination(t *testing.T) {
+	m, err := New(config.MirrorConfig{Topics: []config.MirrorTopic{
+		{Source: "orders", Destination: "orders-copy"},
+		{Source: "payments.*", Prefix: "dc1."},
+		{Source: "users"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"orders":      "orders-copy",
+		"payments.eu": "dc1.payments.eu",
+		"users":       "users",
+	}
+	for source, expected := range cases {
+		if destination, err := m.Destination(source); err != nil || destination != expected {
+			t.Errorf("Expected %s to be mirrored to %s, got %s (%v)", source, expected, destination, err)
+		}
+	}
+
+	if _, err := m.Destination("payments"); !errors.Is(err, ErrNotMirrored) {
+		t.Errorf("Expected unmatched topic to be rejected, got %v", err)
+	}
+
+	if _, err := New(config.MirrorConfig{Topics: []config.MirrorTopic{{Source: "orders.#", Destination: "all"}}}); err == nil {
+		t.Error("Expected a pattern renamed to a single topic to be rejected")
+	}
+}
+
+func TestMirrorPreservesMessages(t *testing.T) {
+	destination, server := newFakeBroker(t)
+	m, err := New(config.MirrorConfig{
+		Destination: config.MirrorBroker{Address: server.URL},
+		Topics:      []config.MirrorTopic{{Source: "orders", Prefix: "dc1."}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	msg := request.PollMessage{
+		Id:            "m1",
+		Topic:         "orders",
+		Payload:       []byte("order"),
+		ContentType:   "text/plain",
+		Headers:       map[string]string{"region": "eu"},
+		CorrelationId: "c1",
+		PublishedAt:   now.Add(-2 * time.Second),
+	}
+	if err := m.Mirror(msg); err != nil {
+		t.Fatal(err)
+	}
+	// the source pushes again a message whose acknowledgement was lost
+	if err := m.Mirror(msg); err != nil {
+		t.Fatalf("Expected duplicate to be acknowledged, got %s", err)
+	}
+
+	if len(destination.published) != 1 {
+		t.Fatalf("Expected 1 message on the destination, got %d", len(destination.published))
+	}
+	published := destination.published[0]
+	if published.Id != "m1" || published.Topic != "dc1.orders" || string(published.Data) != "order" ||
+		published.ContentType != "text/plain" || published.Headers["region"] != "eu" || published.CorrelationId != "c1" {
+		t.Errorf("Expected message to be preserved, got %+v", published)
+	}
+
+	stats := m.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("Expected stats of 1 topic, got %d", len(stats))
+	}
+	if stats[0].Mirrored != 1 || stats[0].Duplicates != 1 || stats[0].LastId != "m1" || stats[0].LagSeconds != 2 {
+		t.Errorf("Unexpected progress %+v", stats[0])
+	}
+
+	destination.fail = true
+	if err := m.Mirror(request.PollMessage{Id: "m2", Topic: "orders"}); err == nil {
+		t.Error("Expected rejected publish to fail the push")
+	}
+	if stats := m.Stats(); stats[0].Failed != 1 || stats[0].LastId != "m1" {
+		t.Errorf("Expected failed message to be counted, got %+v", stats[0])
+	}
+}
+
+func TestCheckpointRestoresSubscriptions(t *testing.T) {
+	source, server := newFakeBroker(t)
+	cfg := config.MirrorConfig{
+		Source:         config.MirrorBroker{Address: server.URL},
+		Destination:    config.MirrorBroker{Address: server.URL},
+		Host:           "http://localhost",
+		Port:           8090,
+		Topics:         []config.MirrorTopic{{Source: "orders"}},
+		CheckpointFile: filepath.Join(t.TempDir(), "checkpoint.json"),
+	}
+
+	m, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Subscribe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mirror(request.PollMessage{Id: "m1", Topic: "orders"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Subscribe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(source.subscribed) != 2 {
+		t.Fatalf("Expected 2 subscriptions, got %d", len(source.subscribed))
+	}
+	first, second := source.subscribed[0], source.subscribed[1]
+	if first.Address != "http://localhost:8090" || !first.ReadOld {
+		t.Errorf("Expected first subscription to read the old messages, got %+v", first)
+	}
+	if second.ReadOld {
+		t.Error("Expected restarted mirror not to read the old messages again")
+	}
+	if first.Secret == "" || second.Secret != first.Secret {
+		t.Error("Expected restarted mirror to keep its subscription secret")
+	}
+	if stats := restarted.Stats(); len(stats) != 1 || stats[0].LastId != "m1" {
+		t.Errorf("Expected progress to be restored, got %+v", stats)
+	}
+
+	header := http.Header{}
+	body := []byte(`{"id":"m2"}`)
+	signature.SetHeaders(header, []byte(first.Secret), time.Now(), body)
+	if err := restarted.Verify(header, body); err != nil {
+		t.Errorf("Expected push signed with the restored secret to be accepted, got %s", err)
+	}
+}
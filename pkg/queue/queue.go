@@ -9,6 +9,12 @@ type Queue struct {
 	messages []*message.Message
 	lock     sync.Mutex
 	cond     *sync.Cond
+	// priority makes Peek return the highest priority message, FIFO within a priority
+	priority bool
+	// starvationLimit is the number of times the oldest message can be overtaken by
+	// higher priority ones before it is served, zero disables the protection
+	starvationLimit int
+	overtaken       int
 }
 
 func NewQueue() *Queue {
@@ -18,6 +24,16 @@ func NewQueue() *Queue {
 	return q
 }
 
+// NewPriorityQueue returns a queue serving higher priority messages first, the oldest message
+// is served after being overtaken starvationLimit times so low priorities are not starved.
+func NewPriorityQueue(starvationLimit int) *Queue {
+	q := NewQueue()
+	q.priority = true
+	q.starvationLimit = starvationLimit
+
+	return q
+}
+
 func (q *Queue) Enqueue(msg *message.Message) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
@@ -34,10 +50,7 @@ func (q *Queue) Dequeue() *message.Message {
 		q.cond.Wait()
 	}
 
-	msg := q.messages[0]
-	q.messages = q.messages[1:]
-
-	return msg
+	return q.removeAt(q.next())
 }
 
 func (q *Queue) Peek() *message.Message {
@@ -48,7 +61,54 @@ func (q *Queue) Peek() *message.Message {
 		q.cond.Wait()
 	}
 
-	msg := q.messages[0]
+	msg := q.messages[q.next()]
+
+	return msg
+}
+
+// Remove removes the given message, typically the one returned by Peek, keeping the order of the others.
+func (q *Queue) Remove(msg *message.Message) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i, m := range q.messages {
+		if m == msg {
+			q.removeAt(i)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// next returns the index of the message to serve, the caller must hold the lock.
+func (q *Queue) next() int {
+	if !q.priority || (q.starvationLimit > 0 && q.overtaken >= q.starvationLimit) {
+		return 0
+	}
+
+	index := 0
+	for i, m := range q.messages {
+		if m.Priority > q.messages[index].Priority {
+			index = i
+		}
+	}
+
+	return index
+}
+
+// removeAt removes the message at the index keeping the order, the caller must hold the lock.
+func (q *Queue) removeAt(index int) *message.Message {
+	msg := q.messages[index]
+
+	if index == 0 {
+		q.messages = q.messages[1:]
+		q.overtaken = 0
+	} else {
+		q.messages = append(q.messages[:index], q.messages[index+1:]...)
+		q.overtaken++
+	}
 
 	return msg
 }
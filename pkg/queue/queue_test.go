@@ -9,8 +9,8 @@ import (
 
 func TestEnqueueAndDequeue(t *testing.T) {
 	q := NewQueue()
-	msg1 := &message.Message{Id: "1", Payload: "first"}
-	msg2 := &message.Message{Id: "2", Payload: "second"}
+	msg1 := &message.Message{Id: "1", Payload: []byte("first")}
+	msg2 := &message.Message{Id: "2", Payload: []byte("second")}
 
 	q.Enqueue(msg1)
 	q.Enqueue(msg2)
@@ -36,7 +36,7 @@ func TestEnqueueAndDequeue(t *testing.T) {
 
 func TestPeek(t *testing.T) {
 	q := NewQueue()
-	msg := &message.Message{Id: "1", Payload: "test message"}
+	msg := &message.Message{Id: "1", Payload: []byte("test message")}
 
 	q.Enqueue(msg)
 	peekedMsg := q.Peek()
@@ -52,9 +52,9 @@ func TestPeek(t *testing.T) {
 
 func TestGetAtAndDeleteAtIndex(t *testing.T) {
 	q := NewQueue()
-	msg1 := &message.Message{Id: "1", Payload: "first"}
-	msg2 := &message.Message{Id: "2", Payload: "second"}
-	msg3 := &message.Message{Id: "3", Payload: "third"}
+	msg1 := &message.Message{Id: "1", Payload: []byte("first")}
+	msg2 := &message.Message{Id: "2", Payload: []byte("second")}
+	msg3 := &message.Message{Id: "3", Payload: []byte("third")}
 
 	q.Enqueue(msg1)
 	q.Enqueue(msg2)
@@ -88,7 +88,7 @@ func TestQueueConcurrency(t *testing.T) {
 	go func() {
 		<-start
 		for i := 0; i < 100; i++ {
-			q.Enqueue(&message.Message{Id: string(rune(i)), Payload: "payload"})
+			q.Enqueue(&message.Message{Id: string(rune(i)), Payload: []byte("payload")})
 		}
 
 		wg.Done()
@@ -110,3 +110,66 @@ func TestQueueConcurrency(t *testing.T) {
 		t.Errorf("Expected empty queue after concurrent enqueue and dequeue, got length %d", q.Len())
 	}
 }
+
+func TestPriorityQueue(t *testing.T) {
+	q := NewPriorityQueue(0)
+	low1 := &message.Message{Id: "low1", Priority: 1}
+	high1 := &message.Message{Id: "high1", Priority: 5}
+	low2 := &message.Message{Id: "low2", Priority: 1}
+	high2 := &message.Message{Id: "high2", Priority: 5}
+
+	q.Enqueue(low1)
+	q.Enqueue(high1)
+	q.Enqueue(low2)
+	q.Enqueue(high2)
+
+	expected := []*message.Message{high1, high2, low1, low2}
+	for i, msg := range expected {
+		if peeked := q.Peek(); peeked != msg {
+			t.Errorf("Expected peek %d to be %s, got %s", i, msg.Id, peeked.Id)
+		}
+
+		if deq := q.Dequeue(); deq != msg {
+			t.Errorf("Expected dequeue %d to be %s, got %s", i, msg.Id, deq.Id)
+		}
+	}
+}
+
+func TestPriorityQueue_StarvationLimit(t *testing.T) {
+	q := NewPriorityQueue(2)
+	low := &message.Message{Id: "low", Priority: 0}
+	q.Enqueue(low)
+	for i := 0; i < 5; i++ {
+		q.Enqueue(&message.Message{Id: "high", Priority: 9})
+	}
+
+	q.Dequeue()
+	q.Dequeue()
+
+	if deq := q.Dequeue(); deq != low {
+		t.Errorf("Expected the low priority message after being overtaken twice, got %s", deq.Id)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	q := NewQueue()
+	msg1 := &message.Message{Id: "1"}
+	msg2 := &message.Message{Id: "2"}
+	msg3 := &message.Message{Id: "3"}
+
+	q.Enqueue(msg1)
+	q.Enqueue(msg2)
+	q.Enqueue(msg3)
+
+	if !q.Remove(msg2) {
+		t.Error("Remove should find the message")
+	}
+
+	if q.Remove(msg2) {
+		t.Error("Remove should not find an already removed message")
+	}
+
+	if q.Len() != 2 || q.GetAt(0) != msg1 || q.GetAt(1) != msg3 {
+		t.Error("Remove should keep the order of the other messages")
+	}
+}
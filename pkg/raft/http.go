@@ -0,0 +1,84 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+const (
+	VotePath     = "/raft/vote"
+	AppendPath   = "/raft/append"
+	SnapshotPath = "/raft/snapshot"
+)
+
+// HTTPTransport sends the requests as JSON to the VotePath, AppendPath and SnapshotPath of the peers' apis.
+type HTTPTransport struct {
+	// addresses maps the peer ids to the urls of their apis
+	addresses   map[string]string
+	credentials request.Credentials
+}
+
+func NewHTTPTransport(addresses map[string]string, credentials request.Credentials) *HTTPTransport {
+	trimmed := make(map[string]string, len(addresses))
+	for id, address := range addresses {
+		trimmed[id] = strings.TrimSuffix(address, "/")
+	}
+
+	return &HTTPTransport{addresses: trimmed, credentials: credentials}
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error) {
+	var res VoteResponse
+	err := t.send(ctx, peer, VotePath, req, &res)
+
+	return res, err
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error) {
+	var res AppendResponse
+	err := t.send(ctx, peer, AppendPath, req, &res)
+
+	return res, err
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error) {
+	var res SnapshotResponse
+	err := t.send(ctx, peer, SnapshotPath, req, &res)
+
+	return res, err
+}
+
+func (t *HTTPTransport) send(ctx context.Context, peer string, path string, req any, res any) error {
+	address, ok := t.addresses[peer]
+	if !ok {
+		return fmt.Errorf("unknown raft peer %s", peer)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	body, status, err := request.SendAuthenticatedRequest(ctx, http.MethodPost, address+path, bytes.NewReader(data), t.credentials)
+	if err != nil {
+		return err
+	}
+	if closer, ok := body.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	if status != http.StatusOK {
+		message, _ := io.ReadAll(body)
+
+		return fmt.Errorf("raft peer %s responded with %d: %s", peer, status, message)
+	}
+
+	return json.NewDecoder(body).Decode(res)
+}
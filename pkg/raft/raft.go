@@ -0,0 +1,972 @@
+// Package raft implements the Raft consensus algorithm: the nodes of a cluster elect a leader which
+// replicates a log of commands, a command is committed once a majority of the nodes stored it.
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Role string
+
+const (
+	Follower  Role = "follower"
+	Candidate Role = "candidate"
+	Leader    Role = "leader"
+)
+
+var (
+	ErrNotLeader      = errors.New("node is not the leader")
+	ErrLostLeadership = errors.New("node lost the leadership before the entry was committed")
+	ErrStopped        = errors.New("node is stopped")
+)
+
+// maxAppendBatch bounds the number of entries sent to a follower in one request.
+const maxAppendBatch = 500
+
+// Entry is a command of the log, entries without command are appended by new leaders to commit
+// the entries of the previous terms.
+type Entry struct {
+	Index   uint64          `json:"index"`
+	Term    uint64          `json:"term"`
+	Command json.RawMessage `json:"command,omitempty"`
+}
+
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type AppendRequest struct {
+	Term         uint64  `json:"term"`
+	Leader       string  `json:"leader"`
+	PrevLogIndex uint64  `json:"prevLogIndex"`
+	PrevLogTerm  uint64  `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leaderCommit"`
+}
+
+type AppendResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// LastIndex is the last entry the follower may share with the leader, the leader retries from there
+	LastIndex uint64 `json:"lastIndex"`
+}
+
+// SnapshotRequest sends the snapshot of the leader to a follower missing the entries it replaced.
+type SnapshotRequest struct {
+	Term     uint64   `json:"term"`
+	Leader   string   `json:"leader"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type SnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+// Transport sends the requests of a node to its peers.
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error)
+	AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error)
+	InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error)
+}
+
+type Options struct {
+	Id string
+	// Peers are the ids of the other nodes of the cluster
+	Peers []string
+	// ElectionTimeout is the minimum time without a leader before a follower starts an election,
+	// each election waits a random time between ElectionTimeout and twice of it
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	Storage           *Storage
+	Transport         Transport
+	// Apply is called in order with the committed commands, the leader's own ones included
+	Apply func(e Entry)
+	// Snapshot returns the state of the commands applied so far, the log is compacted into it once
+	// SnapshotThreshold entries were applied after the previous snapshot. Without it the log is kept whole
+	Snapshot          func() ([]byte, error)
+	SnapshotThreshold uint64
+	// Restore replaces the state with the snapshot before the commands after it are applied, when
+	// the node starts, receives the snapshot of the leader or stops leading
+	Restore func(data []byte)
+	// OnRoleChange is called when the node is ready to lead, after it applied every command of
+	// the previous leaders, and when it stops leading. Once a leader stops leading, Restore is called
+	// with the last snapshot and Apply with the entries after it
+	OnRoleChange func(role Role)
+}
+
+type peer struct {
+	nextIndex  uint64
+	matchIndex uint64
+	// lastContact is the last time the peer answered the leader
+	lastContact time.Time
+	// trigger wakes up the replication to the peer
+	trigger chan struct{}
+}
+
+// Status is a snapshot of the state of a node.
+type Status struct {
+	Id          string `json:"id"`
+	Role        Role   `json:"role"`
+	Term        uint64 `json:"term"`
+	Leader      string `json:"leader"`
+	LastIndex   uint64 `json:"lastIndex"`
+	CommitIndex uint64 `json:"commitIndex"`
+	LastApplied uint64 `json:"lastApplied"`
+	// SnapshotIndex is the last entry compacted into the snapshot
+	SnapshotIndex uint64       `json:"snapshotIndex"`
+	Peers         []PeerStatus `json:"peers,omitempty"`
+}
+
+type PeerStatus struct {
+	Id          string    `json:"id"`
+	MatchIndex  uint64    `json:"matchIndex"`
+	LastContact time.Time `json:"lastContact"`
+}
+
+type Node struct {
+	mu   sync.Mutex
+	opts Options
+
+	role     Role
+	term     uint64
+	votedFor string
+	leader   string
+	// log starts with a sentinel entry holding the index and term of the last snapshot, 0 without
+	// one, the entries it replaced are not kept
+	log         []Entry
+	commitIndex uint64
+	lastApplied uint64
+	// restore is set when the state has to be restored from the snapshot before applying the entries after it
+	restore bool
+	peers   map[string]*peer
+
+	electionDeadline time.Time
+	// noopIndex is the entry appended when the node became the leader, it leads once it's applied
+	noopIndex uint64
+	// leading is set once the node is ready to lead, after it applied the entries up to noopIndex
+	leading bool
+	// roleEvents are the role changes not yet handed to OnRoleChange
+	roleEvents []Role
+	// proposed keeps the term of the entries proposed by the node until they are waited for
+	proposed map[uint64]uint64
+	// changed is closed and replaced when the log, the commit index or the role changes
+	changed chan struct{}
+
+	stopCh  chan struct{}
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewNode restores the state of the node from its storage, the node starts as a follower once Start is called.
+func NewNode(opts Options) (*Node, error) {
+	term, votedFor, entries, err := opts.Storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := opts.Storage.LoadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	// a crash while compacting may leave the entries the snapshot replaced in the log
+	for len(entries) > 0 && entries[0].Index <= snapshot.Index {
+		entries = entries[1:]
+	}
+
+	n := &Node{
+		opts:        opts,
+		role:        Follower,
+		term:        term,
+		votedFor:    votedFor,
+		log:         append([]Entry{{Index: snapshot.Index, Term: snapshot.Term}}, entries...),
+		commitIndex: snapshot.Index,
+		lastApplied: snapshot.Index,
+		restore:     snapshot.Index > 0,
+		peers:       make(map[string]*peer),
+		proposed:    make(map[uint64]uint64),
+		changed:     make(chan struct{}),
+		stopCh:      make(chan struct{}),
+	}
+	for _, id := range opts.Peers {
+		n.peers[id] = &peer{trigger: make(chan struct{}, 1)}
+	}
+
+	return n, nil
+}
+
+// Start runs the elections, the replication and the apply loop until Stop is called.
+func (n *Node) Start() {
+	n.mu.Lock()
+	n.resetElectionTimerLocked()
+	log.Printf("Raft node %s started at term %d with %d entries after the snapshot at %d \n", n.opts.Id, n.term, len(n.log)-1, n.snapshotIndexLocked())
+	n.mu.Unlock()
+
+	n.wg.Add(2 + len(n.peers))
+	go n.run()
+	go n.applyLoop()
+	for id, p := range n.peers {
+		go n.replicate(id, p)
+	}
+}
+
+// Stop stops the node and waits for its goroutines, Apply and OnRoleChange are no longer called.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+
+		return
+	}
+	n.stopped = true
+	close(n.stopCh)
+	n.notifyLocked()
+	n.mu.Unlock()
+
+	n.wg.Wait()
+}
+
+func (n *Node) notifyLocked() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+func (n *Node) lastIndexLocked() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTermLocked() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+func (n *Node) snapshotIndexLocked() uint64 {
+	return n.log[0].Index
+}
+
+// entryLocked returns the entry at the index, the index must be between the snapshot and the last entry.
+func (n *Node) entryLocked(index uint64) Entry {
+	return n.log[index-n.snapshotIndexLocked()]
+}
+
+// entriesLocked returns a copy of the entries from one index to another, both included.
+func (n *Node) entriesLocked(from uint64, to uint64) []Entry {
+	offset := n.snapshotIndexLocked()
+
+	return append([]Entry(nil), n.log[from-offset:to-offset+1]...)
+}
+
+// truncateLocked drops the entries from the index on, the proposals among them are lost.
+func (n *Node) truncateLocked(index uint64) {
+	n.log = n.log[:index-n.snapshotIndexLocked()]
+	for i := range n.proposed {
+		if i >= index {
+			delete(n.proposed, i)
+		}
+	}
+}
+
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+func (n *Node) resetElectionTimerLocked() {
+	timeout := n.opts.ElectionTimeout + time.Duration(rand.Int63n(int64(n.opts.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+func (n *Node) saveStateLocked() {
+	if err := n.opts.Storage.SaveState(n.term, n.votedFor); err != nil {
+		// a node that can't persist its vote could vote twice in a term, it must not go on
+		log.Fatalf("raft node %s failed to persist its state [ERROR]: %s", n.opts.Id, err)
+	}
+}
+
+func (n *Node) appendLocked(entries []Entry) {
+	if err := n.opts.Storage.Append(entries); err != nil {
+		log.Fatalf("raft node %s failed to persist its log [ERROR]: %s", n.opts.Id, err)
+	}
+	n.log = append(n.log, entries...)
+}
+
+// becomeFollowerLocked steps down to follower at the term, a leader that was leading replays the log.
+func (n *Node) becomeFollowerLocked(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.saveStateLocked()
+	}
+
+	if n.role == Leader {
+		log.Printf("Raft node %s stepped down at term %d \n", n.opts.Id, n.term)
+	}
+	if n.leading {
+		n.leading = false
+		// the state may hold the changes the node made while leading outside of the log, it is
+		// restored from the snapshot and the entries after it are applied again
+		n.restore = true
+		n.roleEvents = append(n.roleEvents, Follower)
+	}
+
+	n.role = Follower
+	n.leader = leader
+	n.resetElectionTimerLocked()
+	n.notifyLocked()
+}
+
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.opts.HeartbeatInterval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		now := time.Now()
+		switch {
+		case n.role == Leader && !n.hasQuorumContactLocked(now):
+			// a leader cut off from the majority stops accepting commands it can't commit
+			log.Printf("Raft node %s lost contact with the majority \n", n.opts.Id)
+			n.becomeFollowerLocked(n.term, "")
+		case n.role != Leader && now.After(n.electionDeadline):
+			n.startElectionLocked()
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *Node) hasQuorumContactLocked(now time.Time) bool {
+	contacted := 1
+	for _, p := range n.peers {
+		if now.Sub(p.lastContact) < 2*n.opts.ElectionTimeout {
+			contacted++
+		}
+	}
+
+	return contacted >= n.quorum()
+}
+
+func (n *Node) startElectionLocked() {
+	n.role = Candidate
+	n.term++
+	n.votedFor = n.opts.Id
+	n.leader = ""
+	n.saveStateLocked()
+	n.resetElectionTimerLocked()
+
+	term := n.term
+	req := VoteRequest{
+		Term:         term,
+		Candidate:    n.opts.Id,
+		LastLogIndex: n.lastIndexLocked(),
+		LastLogTerm:  n.lastTermLocked(),
+	}
+	log.Printf("Raft node %s started an election at term %d \n", n.opts.Id, term)
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeaderLocked()
+
+		return
+	}
+
+	for id := range n.peers {
+		go func(id string) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.opts.ElectionTimeout)
+			defer cancel()
+
+			res, err := n.opts.Transport.RequestVote(ctx, id, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			if res.Term > n.term {
+				n.becomeFollowerLocked(res.Term, "")
+
+				return
+			}
+			if n.role != Candidate || n.term != term || !res.Granted {
+				return
+			}
+
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeaderLocked()
+			}
+		}(id)
+	}
+}
+
+func (n *Node) becomeLeaderLocked() {
+	n.role = Leader
+	n.leader = n.opts.Id
+
+	now := time.Now()
+	next := n.lastIndexLocked() + 1
+	for _, p := range n.peers {
+		p.nextIndex = next
+		p.matchIndex = 0
+		// the election counts as contact, the peers voted for the node
+		p.lastContact = now
+	}
+
+	// committing an entry of the new term commits the entries of the previous leaders
+	n.noopIndex = next
+	n.appendLocked([]Entry{{Index: next, Term: n.term}})
+	n.advanceCommitLocked()
+	n.triggerPeersLocked()
+	n.notifyLocked()
+
+	log.Printf("Raft node %s became the leader at term %d \n", n.opts.Id, n.term)
+}
+
+func (n *Node) triggerPeersLocked() {
+	for _, p := range n.peers {
+		select {
+		case p.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// advanceCommitLocked commits the entries of the current term stored by a majority.
+func (n *Node) advanceCommitLocked() {
+	matches := []uint64{n.lastIndexLocked()}
+	for _, p := range n.peers {
+		matches = append(matches, p.matchIndex)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+
+	index := matches[n.quorum()-1]
+	if index > n.commitIndex && n.entryLocked(index).Term == n.term {
+		n.commitIndex = index
+		n.notifyLocked()
+	}
+}
+
+// replicate sends the new entries, or a heartbeat, to the peer while the node is the leader.
+func (n *Node) replicate(id string, p *peer) {
+	defer n.wg.Done()
+
+	timer := time.NewTimer(n.opts.HeartbeatInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-p.trigger:
+		case <-timer.C:
+		}
+		timer.Reset(n.opts.HeartbeatInterval)
+
+		n.mu.Lock()
+		if n.role != Leader {
+			n.mu.Unlock()
+
+			continue
+		}
+		if p.nextIndex <= n.snapshotIndexLocked() {
+			term := n.term
+			n.mu.Unlock()
+
+			n.sendSnapshot(id, p, term)
+
+			continue
+		}
+		req := n.appendRequestLocked(p)
+		n.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), n.opts.ElectionTimeout)
+		res, err := n.opts.Transport.AppendEntries(ctx, id, req)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		n.mu.Lock()
+		more := n.handleAppendResponseLocked(p, req, res)
+		n.mu.Unlock()
+
+		if more {
+			select {
+			case p.trigger <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// sendSnapshot sends the last snapshot to the peer missing the entries it replaced.
+func (n *Node) sendSnapshot(id string, p *peer, term uint64) {
+	snapshot, err := n.opts.Storage.LoadSnapshot()
+	if err != nil {
+		log.Printf("raft node %s failed to load its snapshot for %s [ERROR]: %s", n.opts.Id, id, err)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.opts.ElectionTimeout)
+	res, err := n.opts.Transport.InstallSnapshot(ctx, id, SnapshotRequest{Term: term, Leader: n.opts.Id, Snapshot: snapshot})
+	cancel()
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if res.Term > n.term {
+		n.becomeFollowerLocked(res.Term, "")
+
+		return
+	}
+	if n.role != Leader || n.term != term {
+		return
+	}
+
+	p.lastContact = time.Now()
+	if snapshot.Index > p.matchIndex {
+		p.matchIndex = snapshot.Index
+		n.advanceCommitLocked()
+	}
+	p.nextIndex = max(p.nextIndex, snapshot.Index+1)
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) appendRequestLocked(p *peer) AppendRequest {
+	prev := p.nextIndex - 1
+	end := min(n.lastIndexLocked(), prev+maxAppendBatch)
+
+	return AppendRequest{
+		Term:         n.term,
+		Leader:       n.opts.Id,
+		PrevLogIndex: prev,
+		PrevLogTerm:  n.entryLocked(prev).Term,
+		Entries:      n.entriesLocked(prev+1, end),
+		LeaderCommit: n.commitIndex,
+	}
+}
+
+// handleAppendResponseLocked updates the progress of the peer, it returns true when the peer is still behind.
+func (n *Node) handleAppendResponseLocked(p *peer, req AppendRequest, res AppendResponse) bool {
+	if res.Term > n.term {
+		n.becomeFollowerLocked(res.Term, "")
+
+		return false
+	}
+	if n.role != Leader || n.term != req.Term {
+		return false
+	}
+
+	p.lastContact = time.Now()
+	if !res.Success {
+		p.nextIndex = max(1, min(p.nextIndex-1, res.LastIndex+1))
+
+		return true
+	}
+
+	match := req.PrevLogIndex + uint64(len(req.Entries))
+	if match > p.matchIndex {
+		p.matchIndex = match
+		n.advanceCommitLocked()
+	}
+	p.nextIndex = max(p.nextIndex, match+1)
+
+	return p.nextIndex <= n.lastIndexLocked()
+}
+
+// HandleRequestVote answers the vote request of a candidate.
+func (n *Node) HandleRequestVote(req VoteRequest) VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term > n.term {
+		n.becomeFollowerLocked(req.Term, "")
+	}
+	if req.Term < n.term {
+		return VoteResponse{Term: n.term}
+	}
+
+	upToDate := req.LastLogTerm > n.lastTermLocked() ||
+		(req.LastLogTerm == n.lastTermLocked() && req.LastLogIndex >= n.lastIndexLocked())
+	if (n.votedFor == "" || n.votedFor == req.Candidate) && upToDate {
+		n.votedFor = req.Candidate
+		n.saveStateLocked()
+		n.resetElectionTimerLocked()
+
+		return VoteResponse{Term: n.term, Granted: true}
+	}
+
+	return VoteResponse{Term: n.term}
+}
+
+// HandleAppendEntries stores the entries of the leader and commits up to the leader's commit index.
+func (n *Node) HandleAppendEntries(req AppendRequest) AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return AppendResponse{Term: n.term, LastIndex: n.lastIndexLocked()}
+	}
+	if req.Term > n.term || n.role != Follower || n.leader != req.Leader {
+		n.becomeFollowerLocked(req.Term, req.Leader)
+	}
+	n.resetElectionTimerLocked()
+
+	// the entries up to the snapshot are committed, they match the ones of the leader
+	if snapshotIndex := n.snapshotIndexLocked(); req.PrevLogIndex < snapshotIndex {
+		for len(req.Entries) > 0 && req.Entries[0].Index <= snapshotIndex {
+			req.Entries = req.Entries[1:]
+		}
+		req.PrevLogIndex, req.PrevLogTerm = snapshotIndex, n.log[0].Term
+	}
+
+	if req.PrevLogIndex > n.lastIndexLocked() {
+		return AppendResponse{Term: n.term, LastIndex: n.lastIndexLocked()}
+	}
+	if term := n.entryLocked(req.PrevLogIndex).Term; term != req.PrevLogTerm {
+		// skip back over the whole conflicting term instead of one entry per request
+		index := req.PrevLogIndex - 1
+		for index > n.commitIndex && n.entryLocked(index).Term == term {
+			index--
+		}
+
+		return AppendResponse{Term: n.term, LastIndex: index}
+	}
+
+	for i, e := range req.Entries {
+		if e.Index <= n.lastIndexLocked() {
+			if n.entryLocked(e.Index).Term == e.Term {
+				continue
+			}
+			if e.Index <= n.commitIndex {
+				log.Printf("raft node %s refused to overwrite committed entry %d", n.opts.Id, e.Index)
+
+				return AppendResponse{Term: n.term, LastIndex: n.commitIndex}
+			}
+			n.truncateLocked(e.Index)
+		}
+
+		n.appendLocked(req.Entries[i:])
+		n.notifyLocked()
+
+		break
+	}
+
+	if last := req.PrevLogIndex + uint64(len(req.Entries)); req.LeaderCommit > n.commitIndex {
+		n.commitIndex = max(n.commitIndex, min(req.LeaderCommit, last))
+		n.notifyLocked()
+	}
+
+	return AppendResponse{Term: n.term, Success: true, LastIndex: n.lastIndexLocked()}
+}
+
+// HandleInstallSnapshot replaces the log with the snapshot of the leader, the entries after the
+// snapshot are kept when they follow it.
+func (n *Node) HandleInstallSnapshot(req SnapshotRequest) SnapshotResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return SnapshotResponse{Term: n.term}
+	}
+	if req.Term > n.term || n.role != Follower || n.leader != req.Leader {
+		n.becomeFollowerLocked(req.Term, req.Leader)
+	}
+	n.resetElectionTimerLocked()
+
+	snapshot := req.Snapshot
+	if snapshot.Index <= n.commitIndex {
+		return SnapshotResponse{Term: n.term}
+	}
+
+	var kept []Entry
+	if snapshot.Index < n.lastIndexLocked() && n.entryLocked(snapshot.Index).Term == snapshot.Term {
+		kept = n.entriesLocked(snapshot.Index+1, n.lastIndexLocked())
+	} else {
+		n.truncateLocked(n.snapshotIndexLocked() + 1)
+	}
+	for i := range n.proposed {
+		if i <= snapshot.Index {
+			delete(n.proposed, i)
+		}
+	}
+
+	if err := n.opts.Storage.SaveSnapshot(snapshot, kept); err != nil {
+		log.Fatalf("raft node %s failed to persist the snapshot of the leader [ERROR]: %s", n.opts.Id, err)
+	}
+	n.log = append([]Entry{{Index: snapshot.Index, Term: snapshot.Term}}, kept...)
+	n.commitIndex = snapshot.Index
+	n.restore = true
+	n.notifyLocked()
+
+	log.Printf("Raft node %s installed the snapshot of %s at %d \n", n.opts.Id, req.Leader, snapshot.Index)
+
+	return SnapshotResponse{Term: n.term}
+}
+
+// Propose appends the command to the log of the leader and returns its index.
+func (n *Node) Propose(command []byte) (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return 0, ErrStopped
+	}
+	if !n.leading {
+		return 0, ErrNotLeader
+	}
+
+	e := Entry{Index: n.lastIndexLocked() + 1, Term: n.term, Command: command}
+	n.appendLocked([]Entry{e})
+	n.proposed[e.Index] = e.Term
+	n.advanceCommitLocked()
+	n.triggerPeersLocked()
+
+	return e.Index, nil
+}
+
+// WaitCommitted blocks until the entry proposed at the index is committed. It fails when the entry
+// has been replaced by the one of another leader or the context is done.
+func (n *Node) WaitCommitted(ctx context.Context, index uint64) error {
+	n.mu.Lock()
+	term, ok := n.proposed[index]
+	n.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("entry %d was not proposed by node %s", index, n.opts.Id)
+	}
+
+	defer func() {
+		n.mu.Lock()
+		delete(n.proposed, index)
+		n.mu.Unlock()
+	}()
+
+	for {
+		n.mu.Lock()
+		switch {
+		case n.stopped:
+			n.mu.Unlock()
+
+			return ErrStopped
+		case n.proposed[index] != term || index > n.lastIndexLocked():
+			n.mu.Unlock()
+
+			return ErrLostLeadership
+		case n.commitIndex >= index:
+			n.mu.Unlock()
+
+			return nil
+		}
+		changed := n.changed
+		n.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// applyLoop hands the committed entries and the role changes to the callbacks in order.
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+
+	for {
+		n.mu.Lock()
+		if n.stopped {
+			n.mu.Unlock()
+
+			return
+		}
+
+		if len(n.roleEvents) > 0 {
+			role := n.roleEvents[0]
+			n.roleEvents = n.roleEvents[1:]
+			n.mu.Unlock()
+
+			if n.opts.OnRoleChange != nil {
+				n.opts.OnRoleChange(role)
+			}
+
+			continue
+		}
+
+		if n.restore {
+			n.restore = false
+			n.lastApplied = n.snapshotIndexLocked()
+			n.mu.Unlock()
+
+			n.restoreSnapshot()
+
+			continue
+		}
+
+		if n.lastApplied < n.commitIndex {
+			entries := n.entriesLocked(n.lastApplied+1, n.commitIndex)
+			n.lastApplied = n.commitIndex
+			n.pruneProposedLocked()
+			n.mu.Unlock()
+
+			for _, e := range entries {
+				if e.Command != nil {
+					n.opts.Apply(e)
+				}
+			}
+			n.compact(entries[len(entries)-1].Index)
+
+			continue
+		}
+
+		if n.role == Leader && !n.leading && n.lastApplied >= n.noopIndex {
+			n.leading = true
+			n.roleEvents = append(n.roleEvents, Leader)
+			n.mu.Unlock()
+
+			continue
+		}
+
+		changed := n.changed
+		n.mu.Unlock()
+
+		select {
+		case <-n.stopCh:
+			return
+		case <-changed:
+		}
+	}
+}
+
+// restoreSnapshot hands the last snapshot to Restore, there is nothing to restore before the first one.
+func (n *Node) restoreSnapshot() {
+	snapshot, err := n.opts.Storage.LoadSnapshot()
+	if err != nil {
+		// the entries after the snapshot can't be applied without it
+		log.Fatalf("raft node %s failed to load its snapshot [ERROR]: %s", n.opts.Id, err)
+	}
+
+	if snapshot.Index > 0 && n.opts.Restore != nil {
+		n.opts.Restore(snapshot.Data)
+	}
+}
+
+// compact replaces the entries up to the applied index with a snapshot once SnapshotThreshold entries
+// were applied since the last one. It's called by the apply loop so the snapshot holds the state
+// of exactly the entries up to the index.
+func (n *Node) compact(applied uint64) {
+	n.mu.Lock()
+	due := n.opts.Snapshot != nil && n.opts.SnapshotThreshold > 0 &&
+		applied >= n.snapshotIndexLocked()+n.opts.SnapshotThreshold
+	n.mu.Unlock()
+	if !due {
+		return
+	}
+
+	data, err := n.opts.Snapshot()
+	if err != nil {
+		log.Printf("raft node %s failed to snapshot its state [ERROR]: %s", n.opts.Id, err)
+
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// a snapshot of the leader may have been installed meanwhile
+	if applied <= n.snapshotIndexLocked() {
+		return
+	}
+
+	snapshot := Snapshot{Index: applied, Term: n.entryLocked(applied).Term, Data: data}
+	kept := n.entriesLocked(applied+1, n.lastIndexLocked())
+	if err := n.opts.Storage.SaveSnapshot(snapshot, kept); err != nil {
+		log.Printf("raft node %s failed to compact its log [ERROR]: %s", n.opts.Id, err)
+
+		return
+	}
+	n.log = append([]Entry{{Index: snapshot.Index, Term: snapshot.Term}}, kept...)
+
+	log.Printf("Raft node %s compacted its log up to %d \n", n.opts.Id, applied)
+}
+
+// pruneProposedLocked forgets the old proposals nobody waited for.
+func (n *Node) pruneProposedLocked() {
+	const keep = 10000
+	if len(n.proposed) <= keep {
+		return
+	}
+
+	for index := range n.proposed {
+		if index+keep < n.lastApplied {
+			delete(n.proposed, index)
+		}
+	}
+}
+
+// IsLeading reports whether the node is the leader and ready to propose commands.
+func (n *Node) IsLeading() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.leading
+}
+
+// Leader returns the id of the known leader, empty during elections.
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.leader
+}
+
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	status := Status{
+		Id:            n.opts.Id,
+		Role:          n.role,
+		Term:          n.term,
+		Leader:        n.leader,
+		LastIndex:     n.lastIndexLocked(),
+		CommitIndex:   n.commitIndex,
+		LastApplied:   n.lastApplied,
+		SnapshotIndex: n.snapshotIndexLocked(),
+	}
+	if n.role == Leader {
+		for id, p := range n.peers {
+			status.Peers = append(status.Peers, PeerStatus{Id: id, MatchIndex: p.matchIndex, LastContact: p.lastContact})
+		}
+		sort.Slice(status.Peers, func(i, j int) bool { return status.Peers[i].Id < status.Peers[j].Id })
+	}
+
+	return status
+}
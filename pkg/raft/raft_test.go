@@ -0,0 +1,488 @@
+package raft_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NamanBalaji/flux/internal/rafttest"
+	"github.com/NamanBalaji/flux/pkg/raft"
+)
+
+const (
+	testElectionTimeout = 50 * time.Millisecond
+	testHeartbeat       = 10 * time.Millisecond
+	testWait            = 5 * time.Second
+	// testSnapshotThreshold compacts the logs often so the tests go through the snapshots
+	testSnapshotThreshold = 3
+)
+
+// testNode applies the commands to a list, its snapshots are the list.
+type testNode struct {
+	mu      sync.Mutex
+	node    *raft.Node
+	storage *raft.Storage
+	applied []string
+}
+
+func (n *testNode) commands() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string(nil), n.applied...)
+}
+
+type testCluster struct {
+	t         *testing.T
+	dir       string
+	ids       []string
+	transport *rafttest.LocalTransport
+	nodes     map[string]*testNode
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	c := &testCluster{
+		t:         t,
+		dir:       t.TempDir(),
+		transport: rafttest.NewLocalTransport(),
+		nodes:     make(map[string]*testNode),
+	}
+	for i := 1; i <= size; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("node%d", i))
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	t.Cleanup(func() {
+		for id := range c.nodes {
+			c.stop(id)
+		}
+	})
+
+	return c
+}
+
+// start starts the node from its storage, it's used to restart crashed nodes too.
+func (c *testCluster) start(id string) {
+	storage, err := raft.NewStorage(filepath.Join(c.dir, id))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	var peers []string
+	for _, other := range c.ids {
+		if other != id {
+			peers = append(peers, other)
+		}
+	}
+
+	tn := &testNode{storage: storage}
+	tn.node, err = raft.NewNode(raft.Options{
+		Id:                id,
+		Peers:             peers,
+		ElectionTimeout:   testElectionTimeout,
+		HeartbeatInterval: testHeartbeat,
+		Storage:           storage,
+		Transport:         c.transport.For(id),
+		Apply: func(e raft.Entry) {
+			tn.mu.Lock()
+			tn.applied = append(tn.applied, string(e.Command))
+			tn.mu.Unlock()
+		},
+		Snapshot: func() ([]byte, error) {
+			return json.Marshal(tn.commands())
+		},
+		SnapshotThreshold: testSnapshotThreshold,
+		Restore: func(data []byte) {
+			var applied []string
+			if err := json.Unmarshal(data, &applied); err != nil {
+				c.t.Errorf("Invalid snapshot of %s: %s", id, err)
+			}
+
+			tn.mu.Lock()
+			tn.applied = applied
+			tn.mu.Unlock()
+		},
+		OnRoleChange: func(role raft.Role) {
+			if role == raft.Follower {
+				tn.mu.Lock()
+				tn.applied = nil
+				tn.mu.Unlock()
+			}
+		},
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	c.nodes[id] = tn
+	c.transport.Register(id, tn.node)
+	tn.node.Start()
+}
+
+// stop crashes the node, it keeps its storage.
+func (c *testCluster) stop(id string) {
+	tn := c.nodes[id]
+	c.transport.Partition([]string{id}, c.ids)
+	tn.node.Stop()
+	tn.storage.Close()
+	delete(c.nodes, id)
+}
+
+// waitLeader waits until exactly one of the nodes leads and returns it.
+func (c *testCluster) waitLeader(ids []string) string {
+	deadline := time.Now().Add(testWait)
+	for time.Now().Before(deadline) {
+		var leaders []string
+		for _, id := range ids {
+			if tn, ok := c.nodes[id]; ok && tn.node.IsLeading() {
+				leaders = append(leaders, id)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+
+		time.Sleep(testHeartbeat)
+	}
+	c.t.Fatalf("No single leader elected among %v", ids)
+
+	return ""
+}
+
+func (c *testCluster) propose(id string, command string) (uint64, error) {
+	return c.nodes[id].node.Propose([]byte(`"` + command + `"`))
+}
+
+func (c *testCluster) proposeAndWait(id string, commands ...string) {
+	for _, command := range commands {
+		index, err := c.propose(id, command)
+		if err != nil {
+			c.t.Fatalf("Failed to propose %s to %s: %s", command, id, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testWait)
+		err = c.nodes[id].node.WaitCommitted(ctx, index)
+		cancel()
+		if err != nil {
+			c.t.Fatalf("Command %s was not committed: %s", command, err)
+		}
+	}
+}
+
+// waitApplied waits until every running node applied exactly the commands.
+func (c *testCluster) waitApplied(commands ...string) {
+	expected := make([]string, len(commands))
+	for i, command := range commands {
+		expected[i] = `"` + command + `"`
+	}
+
+	deadline := time.Now().Add(testWait)
+	for {
+		var behind []string
+		for id, tn := range c.nodes {
+			if !reflect.DeepEqual(tn.commands(), expected) {
+				behind = append(behind, fmt.Sprintf("%s: %v", id, tn.commands()))
+			}
+		}
+		if len(behind) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("Expected every node to apply %v, got %v", expected, behind)
+		}
+
+		time.Sleep(testHeartbeat)
+	}
+}
+
+func TestElectsSingleLeader(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.waitLeader(c.ids)
+
+	status := c.nodes[leader].node.Status()
+	if status.Role != raft.Leader || status.Leader != leader || len(status.Peers) != 2 {
+		t.Fatalf("Expected %s to report itself as leader with 2 peers, got %+v", leader, status)
+	}
+
+	// the followers learn the leader from its heartbeats
+	deadline := time.Now().Add(testWait)
+	for _, id := range c.ids {
+		for c.nodes[id].node.Leader() != leader {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %s to follow %s, got %q", id, leader, c.nodes[id].node.Leader())
+			}
+			time.Sleep(testHeartbeat)
+		}
+	}
+}
+
+func TestSingleNodeCluster(t *testing.T) {
+	c := newTestCluster(t, 1)
+	leader := c.waitLeader(c.ids)
+
+	c.proposeAndWait(leader, "a", "b")
+	c.waitApplied("a", "b")
+}
+
+func TestReplicatesCommittedCommands(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.waitLeader(c.ids)
+
+	for _, id := range c.ids {
+		if id != leader {
+			if _, err := c.propose(id, "x"); !errors.Is(err, raft.ErrNotLeader) {
+				t.Errorf("Expected follower %s to refuse proposals, got %v", id, err)
+			}
+		}
+	}
+
+	c.proposeAndWait(leader, "a", "b", "c")
+	c.waitApplied("a", "b", "c")
+}
+
+func TestLeaderCrashFailsOver(t *testing.T) {
+	c := newTestCluster(t, 5)
+	leader := c.waitLeader(c.ids)
+	c.proposeAndWait(leader, "a", "b")
+
+	c.stop(leader)
+	var rest []string
+	for _, id := range c.ids {
+		if id != leader {
+			rest = append(rest, id)
+		}
+	}
+
+	newLeader := c.waitLeader(rest)
+	c.proposeAndWait(newLeader, "c")
+	c.waitApplied("a", "b", "c")
+
+	// the crashed leader restarts from its storage and catches up as a follower
+	c.transport.Heal()
+	c.start(leader)
+	c.proposeAndWait(newLeader, "d")
+	c.waitApplied("a", "b", "c", "d")
+
+	if status := c.nodes[leader].node.Status(); status.Role != raft.Follower || status.LastIndex < 5 {
+		t.Errorf("Expected the restarted node to follow with the whole log, got %+v", status)
+	}
+}
+
+func TestRestartedClusterKeepsItsLog(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.waitLeader(c.ids)
+	c.proposeAndWait(leader, "a", "b")
+	c.waitApplied("a", "b")
+
+	term := c.nodes[leader].node.Status().Term
+	for _, id := range c.ids {
+		c.stop(id)
+	}
+	c.transport.Heal()
+	for _, id := range c.ids {
+		c.start(id)
+	}
+
+	// the restarted nodes apply the committed log again once a new leader commits its term
+	leader = c.waitLeader(c.ids)
+	if status := c.nodes[leader].node.Status(); status.Term <= term {
+		t.Errorf("Expected a term after %d, got %d", term, status.Term)
+	}
+	c.waitApplied("a", "b")
+}
+
+func TestPartitionedLeaderIsReplaced(t *testing.T) {
+	c := newTestCluster(t, 5)
+	oldLeader := c.waitLeader(c.ids)
+	c.proposeAndWait(oldLeader, "a")
+
+	var minority, majority []string
+	minority = append(minority, oldLeader)
+	for _, id := range c.ids {
+		switch {
+		case id == oldLeader:
+		case len(minority) < 2:
+			minority = append(minority, id)
+		default:
+			majority = append(majority, id)
+		}
+	}
+	c.transport.Partition(minority, majority)
+
+	// the old leader can't commit without the majority
+	index, err := c.propose(oldLeader, "lost")
+	if err != nil {
+		t.Fatalf("Expected the partitioned leader to accept the proposal, got %s", err)
+	}
+
+	newLeader := c.waitLeader(majority)
+	c.proposeAndWait(newLeader, "b")
+
+	ctx, cancel := context.WithTimeout(context.Background(), testWait)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() { errs <- c.nodes[oldLeader].node.WaitCommitted(ctx, index) }()
+
+	// the old leader steps down once it loses contact with the majority
+	deadline := time.Now().Add(testWait)
+	for c.nodes[oldLeader].node.IsLeading() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the partitioned leader to step down")
+		}
+		time.Sleep(testHeartbeat)
+	}
+
+	c.transport.Heal()
+	if err := <-errs; !errors.Is(err, raft.ErrLostLeadership) {
+		t.Errorf("Expected the proposal of the partitioned leader to be lost, got %v", err)
+	}
+
+	c.proposeAndWait(newLeader, "c")
+	c.waitApplied("a", "b", "c")
+}
+
+func TestSplitClusterWithoutMajority(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.waitLeader(c.ids)
+
+	// every node is isolated, nobody can become the leader
+	for _, id := range c.ids {
+		c.transport.Partition([]string{id}, c.ids)
+	}
+	time.Sleep(5 * testElectionTimeout)
+	for _, id := range c.ids {
+		if c.nodes[id].node.IsLeading() {
+			t.Fatalf("Expected no leader without a majority, %s leads", id)
+		}
+	}
+	if _, err := c.propose(leader, "x"); !errors.Is(err, raft.ErrNotLeader) {
+		t.Errorf("Expected proposals to be refused without a leader, got %v", err)
+	}
+
+	c.transport.Heal()
+	leader = c.waitLeader(c.ids)
+	c.proposeAndWait(leader, "a")
+	c.waitApplied("a")
+}
+
+func TestStorageReplacesTruncatedEntries(t *testing.T) {
+	dir := t.TempDir()
+	s, err := raft.NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SaveState(3, "node2"); err != nil {
+		t.Fatal(err)
+	}
+	s.Append([]raft.Entry{{Index: 1, Term: 1}, {Index: 2, Term: 1, Command: []byte(`"a"`)}, {Index: 3, Term: 2, Command: []byte(`"b"`)}})
+	// a new leader replaced the entries from 2 on
+	s.Append([]raft.Entry{{Index: 2, Term: 3, Command: []byte(`"c"`)}})
+	s.Close()
+
+	s, err = raft.NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	term, votedFor, entries, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if term != 3 || votedFor != "node2" {
+		t.Errorf("Expected term 3 and the vote for node2, got %d %q", term, votedFor)
+	}
+	if len(entries) != 2 || entries[1].Term != 3 || string(entries[1].Command) != `"c"` {
+		t.Errorf("Expected the replaced entry to be loaded, got %+v", entries)
+	}
+}
+
+func TestCompactedLogSurvivesRestart(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.waitLeader(c.ids)
+	c.proposeAndWait(leader, "a", "b", "c", "d", "e", "f", "g")
+	c.waitApplied("a", "b", "c", "d", "e", "f", "g")
+
+	for _, id := range c.ids {
+		if status := c.nodes[id].node.Status(); status.SnapshotIndex < testSnapshotThreshold {
+			t.Errorf("Expected %s to compact its log, got %+v", id, status)
+		}
+	}
+
+	for _, id := range c.ids {
+		c.stop(id)
+	}
+	c.transport.Heal()
+	for _, id := range c.ids {
+		c.start(id)
+	}
+
+	// the restarted nodes restore their snapshot and apply the entries after it
+	leader = c.waitLeader(c.ids)
+	c.proposeAndWait(leader, "h")
+	c.waitApplied("a", "b", "c", "d", "e", "f", "g", "h")
+}
+
+func TestLaggingFollowerInstallsSnapshot(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.waitLeader(c.ids)
+	c.proposeAndWait(leader, "a")
+	c.waitApplied("a")
+
+	var lagging string
+	for _, id := range c.ids {
+		if id != leader {
+			lagging = id
+		}
+	}
+	c.transport.Partition([]string{lagging}, c.ids)
+	c.proposeAndWait(leader, "b", "c", "d", "e", "f")
+
+	// the leader compacted the entries the follower is missing, it sends its snapshot instead
+	if status := c.nodes[leader].node.Status(); status.SnapshotIndex < 3 {
+		t.Fatalf("Expected the leader to compact its log, got %+v", status)
+	}
+	c.transport.Heal()
+	c.proposeAndWait(c.waitLeader(c.ids), "g")
+	c.waitApplied("a", "b", "c", "d", "e", "f", "g")
+}
+
+func TestStorageCompactsIntoSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s, err := raft.NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Append([]raft.Entry{{Index: 1, Term: 1}, {Index: 2, Term: 1, Command: []byte(`"a"`)}, {Index: 3, Term: 1, Command: []byte(`"b"`)}})
+	snapshot := raft.Snapshot{Index: 2, Term: 1, Data: []byte(`["a"]`)}
+	if err := s.SaveSnapshot(snapshot, []raft.Entry{{Index: 3, Term: 1, Command: []byte(`"b"`)}}); err != nil {
+		t.Fatal(err)
+	}
+	// the compacted log keeps being appended to
+	s.Append([]raft.Entry{{Index: 4, Term: 2, Command: []byte(`"c"`)}})
+	s.Close()
+
+	s, err = raft.NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	loaded, err := s.LoadSnapshot()
+	if err != nil || !reflect.DeepEqual(loaded, snapshot) {
+		t.Errorf("Expected the saved snapshot, got %+v %v", loaded, err)
+	}
+	_, _, entries, err := s.Load()
+	if err != nil || len(entries) != 2 || entries[0].Index != 3 || entries[1].Index != 4 {
+		t.Errorf("Expected the entries after the snapshot, got %+v %v", entries, err)
+	}
+}
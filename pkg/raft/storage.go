@@ -0,0 +1,207 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stateFile    = "state.json"
+	logFile      = "log.jsonl"
+	snapshotFile = "snapshot.json"
+)
+
+// Storage persists the term, the vote, the log and the last snapshot of a node in a directory so
+// it survives crashes.
+type Storage struct {
+	dir string
+	log *os.File
+}
+
+type persistentState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"votedFor"`
+}
+
+// Snapshot is the state of the commands applied up to Index, it replaces the entries up to Index.
+type Snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data"`
+}
+
+// NewStorage opens the storage in the directory, creating it if needed.
+func NewStorage(dir string) (*Storage, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create raft data dir %s: %w", dir, err)
+	}
+
+	s := &Storage{dir: dir}
+	if err := s.openLog(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Storage) openLog() error {
+	file, err := os.OpenFile(filepath.Join(s.dir, logFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open raft log: %w", err)
+	}
+	s.log = file
+
+	return nil
+}
+
+// Load returns the persisted term, vote and log entries.
+func (s *Storage) Load() (uint64, string, []Entry, error) {
+	var state persistentState
+	data, err := os.ReadFile(filepath.Join(s.dir, stateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, "", nil, fmt.Errorf("failed to read raft state: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return 0, "", nil, fmt.Errorf("invalid raft state: %w", err)
+		}
+	}
+
+	file, err := os.Open(filepath.Join(s.dir, logFile))
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("failed to read raft log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// a torn write at the end of the log was never acknowledged, it is dropped
+			break
+		}
+
+		if len(entries) > 0 && e.Index <= entries[len(entries)-1].Index {
+			// a truncated suffix is rewritten by appending after the kept entries
+			entries = entries[:e.Index-entries[0].Index]
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, "", nil, fmt.Errorf("failed to read raft log: %w", err)
+	}
+
+	return state.Term, state.VotedFor, entries, nil
+}
+
+// LoadSnapshot returns the last saved snapshot, the zero snapshot when none was saved.
+func (s *Storage) LoadSnapshot() (Snapshot, error) {
+	var snapshot Snapshot
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to read raft snapshot: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("invalid raft snapshot: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// SaveState durably stores the term and the vote.
+func (s *Storage) SaveState(term uint64, votedFor string) error {
+	data, err := json.Marshal(persistentState{Term: term, VotedFor: votedFor})
+	if err != nil {
+		return err
+	}
+
+	if err := s.replace(stateFile, data); err != nil {
+		return fmt.Errorf("failed to save raft state: %w", err)
+	}
+
+	return nil
+}
+
+// SaveSnapshot durably stores the snapshot and rewrites the log with the entries after it. The
+// entries the snapshot replaces are skipped when a crash left them in the log.
+func (s *Storage) SaveSnapshot(snapshot Snapshot, entries []Entry) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := s.replace(snapshotFile, data); err != nil {
+		return fmt.Errorf("failed to save raft snapshot: %w", err)
+	}
+
+	var log []byte
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		log = append(append(log, line...), '\n')
+	}
+
+	if err := s.log.Close(); err != nil {
+		return fmt.Errorf("failed to compact the raft log: %w", err)
+	}
+	if err := s.replace(logFile, log); err != nil {
+		return fmt.Errorf("failed to compact the raft log: %w", err)
+	}
+
+	return s.openLog()
+}
+
+// replace writes the data to a temporary file renamed over the file, so a crash leaves either version.
+func (s *Storage) replace(name string, data []byte) error {
+	tmp := filepath.Join(s.dir, name+".tmp")
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+// Append durably appends the entries to the log. Entries with an index already in the log replace
+// it and every entry after it when the log is loaded.
+func (s *Storage) Append(entries []Entry) error {
+	w := bufio.NewWriter(s.log)
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to append to the raft log: %w", err)
+	}
+
+	return s.log.Sync()
+}
+
+// Close closes the log file.
+func (s *Storage) Close() error {
+	return s.log.Close()
+}
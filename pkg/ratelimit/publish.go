@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+)
+
+const (
+	ScopePrincipal = "principal"
+	ScopeTopic     = "topic"
+	ScopeIP        = "ip"
+)
+
+// PublishLimits limits the messages and bytes per second published by each principal, to
+// each topic and from each source IP.
+type PublishLimits struct {
+	mu     sync.Mutex
+	scopes []*scope
+}
+
+type scope struct {
+	name      string
+	messages  *Limiter
+	bytes     *Limiter
+	throttled atomic.Uint64
+}
+
+// Throttled describes a rejected publish.
+type Throttled struct {
+	Scope      string
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (t *Throttled) Error() string {
+	return fmt.Sprintf("publish rate limit of %s %s exceeded, retry after %s", t.Scope, t.Key, t.RetryAfter)
+}
+
+// NewPublishLimits creates the configured limits, it returns nil when no limit is configured.
+func NewPublishLimits(cfg config.Limits) *PublishLimits {
+	l := &PublishLimits{}
+	for _, s := range []struct {
+		name string
+		rate config.Rate
+	}{
+		{ScopePrincipal, cfg.Principal},
+		{ScopeTopic, cfg.Topic},
+		{ScopeIP, cfg.IP},
+	} {
+		if s.rate.Messages > 0 || s.rate.Bytes > 0 {
+			l.scopes = append(l.scopes, &scope{
+				name:     s.name,
+				messages: NewLimiter(s.rate.Messages),
+				bytes:    NewLimiter(s.rate.Bytes),
+			})
+		}
+	}
+
+	if len(l.scopes) == 0 {
+		return nil
+	}
+
+	return l
+}
+
+// Allow takes a message of size bytes from every limit, or none of them if any limit is exceeded
+// in which case the limit with the longest wait is returned. A nil PublishLimits allows everything.
+func (l *PublishLimits) Allow(principal string, topic string, ip string, size int, now time.Time) *Throttled {
+	if l == nil {
+		return nil
+	}
+
+	keys := map[string]string{
+		ScopePrincipal: principal,
+		ScopeTopic:     topic,
+		ScopeIP:        ip,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var throttled *Throttled
+	var throttledScope *scope
+	for _, s := range l.scopes {
+		key := keys[s.name]
+		wait := max(s.messages.Wait(key, 1, now), s.bytes.Wait(key, float64(size), now))
+		if wait > 0 && (throttled == nil || wait > throttled.RetryAfter) {
+			throttled = &Throttled{Scope: s.name, Key: key, RetryAfter: wait}
+			throttledScope = s
+		}
+	}
+
+	if throttled != nil {
+		throttledScope.throttled.Add(1)
+
+		return throttled
+	}
+
+	for _, s := range l.scopes {
+		key := keys[s.name]
+		s.messages.Take(key, 1, now)
+		s.bytes.Take(key, float64(size), now)
+	}
+
+	return nil
+}
+
+// Stats returns the number of throttled publishes per scope.
+func (l *PublishLimits) Stats() map[string]uint64 {
+	stats := make(map[string]uint64)
+	if l == nil {
+		return stats
+	}
+
+	for _, s := range l.scopes {
+		stats[s.name] = s.throttled.Load()
+	}
+
+	return stats
+}
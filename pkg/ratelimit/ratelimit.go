@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often buckets which refilled completely are dropped
+const pruneInterval = time.Minute
+
+// Limiter is a set of token buckets keyed by client, refilling at rate tokens per second up
+// to burst tokens. A zero rate disables the limiter.
+type Limiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a limiter allowing rate tokens per second with bursts of one second.
+func NewLimiter(rate float64) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   rate,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *Limiter) Enabled() bool {
+	return l != nil && l.rate > 0
+}
+
+// Wait returns how long the key has to wait before n tokens can be taken, zero if they can be
+// taken now. A request larger than the burst only has to wait for a full bucket.
+func (l *Limiter) Wait(key string, n float64, now time.Time) time.Duration {
+	if !l.Enabled() {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, now)
+	needed := min(n, l.burst)
+	if b.tokens >= needed {
+		return 0
+	}
+
+	return time.Duration((needed - b.tokens) / l.rate * float64(time.Second))
+}
+
+// Take removes n tokens from the key's bucket, the bucket can go negative for requests larger than the burst.
+func (l *Limiter) Take(key string, n float64, now time.Time) {
+	if !l.Enabled() {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(key, now).tokens -= n
+	l.prune(now)
+}
+
+// refill returns the key's bucket with the tokens earned since it was last used, the caller must hold l.mu.
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+
+	return b
+}
+
+// prune drops the buckets that would be full, they are recreated full when needed, the caller must hold l.mu.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < pruneInterval {
+		return
+	}
+	l.lastPrune = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of buckets currently tracked.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.buckets)
+}
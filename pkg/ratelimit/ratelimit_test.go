@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if l.Wait("a", 1, now) != 0 {
+			t.Fatalf("Request %d within the burst should be allowed", i)
+		}
+		l.Take("a", 1, now)
+	}
+
+	if wait := l.Wait("a", 1, now); wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait for one token at 2/s, got %s", wait)
+	}
+
+	if l.Wait("b", 1, now) != 0 {
+		t.Error("Keys should have separate buckets")
+	}
+
+	if l.Wait("a", 1, now.Add(500*time.Millisecond)) != 0 {
+		t.Error("Bucket should refill over time")
+	}
+}
+
+func TestLimiterLargeRequest(t *testing.T) {
+	l := NewLimiter(100)
+	now := time.Now()
+
+	if l.Wait("a", 1000, now) != 0 {
+		t.Fatal("Request larger than the burst should be allowed on a full bucket")
+	}
+	l.Take("a", 1000, now)
+
+	if wait := l.Wait("a", 1, now); wait < 9*time.Second {
+		t.Errorf("Large request should be paid back before the next one, got %s", wait)
+	}
+}
+
+func TestLimiterPrune(t *testing.T) {
+	l := NewLimiter(10)
+	now := time.Now()
+
+	l.Take("a", 1, now)
+	l.Take("b", 1, now.Add(2*pruneInterval))
+	if l.Len() != 1 {
+		t.Errorf("Refilled buckets should be pruned, got %d buckets", l.Len())
+	}
+}
+
+func TestPublishLimits(t *testing.T) {
+	if NewPublishLimits(config.Limits{}) != nil {
+		t.Error("No configured limit should disable the publish limits")
+	}
+
+	l := NewPublishLimits(config.Limits{
+		Principal: config.Rate{Messages: 1},
+		Topic:     config.Rate{Bytes: 100},
+	})
+	now := time.Now()
+
+	if l.Allow("alice", "orders", "10.0.0.1", 10, now) != nil {
+		t.Fatal("First publish should be allowed")
+	}
+
+	throttled := l.Allow("alice", "payments", "10.0.0.1", 10, now)
+	if throttled == nil || throttled.Scope != ScopePrincipal || throttled.RetryAfter != time.Second {
+		t.Errorf("Second publish of the principal should be throttled, got %v", throttled)
+	}
+
+	if l.Allow("bob", "orders", "10.0.0.2", 90, now) != nil {
+		t.Error("Publish within the topic byte rate should be allowed")
+	}
+
+	throttled = l.Allow("carol", "orders", "10.0.0.3", 10, now)
+	if throttled == nil || throttled.Scope != ScopeTopic {
+		t.Errorf("Publish over the topic byte rate should be throttled, got %v", throttled)
+	}
+
+	// carol was throttled by the topic so her principal tokens were not taken
+	if l.Allow("carol", "payments", "10.0.0.3", 10, now) != nil {
+		t.Error("Throttled publish should not use the other limits")
+	}
+
+	stats := l.Stats()
+	if stats[ScopePrincipal] != 1 || stats[ScopeTopic] != 1 {
+		t.Errorf("Expected one throttled publish per scope, got %v", stats)
+	}
+}
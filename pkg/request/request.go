@@ -1,20 +1,56 @@
 package request
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+
+	"github.com/NamanBalaji/flux/pkg/constants"
 )
 
 func SendHTTPRequest(method string, url string, body io.Reader) (io.Reader, int, error) {
-	req, err := http.NewRequest(method, url, body)
+	return SendHTTPRequestWithContext(context.Background(), method, url, body)
+}
+
+// SendHTTPRequestWithContext sends the request and cancels it when the context is done.
+func SendHTTPRequestWithContext(ctx context.Context, method string, url string, body io.Reader) (io.Reader, int, error) {
+	return SendAuthenticatedRequest(ctx, method, url, body, Credentials{})
+}
+
+// Credentials authenticate the clients to a broker requiring authentication.
+type Credentials struct {
+	// APIKey is sent in the X-Flux-Api-Key header
+	APIKey string
+	// Token is a JWT sent as a bearer token
+	Token string
+	// TLS configures the client certificate and trusted CAs for brokers served over TLS
+	TLS *tls.Config
+}
+
+// SendAuthenticatedRequest sends the request with the given credentials.
+func SendAuthenticatedRequest(ctx context.Context, method string, url string, body io.Reader, credentials Credentials) (io.Reader, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, 0, fmt.Errorf("error creating request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if credentials.APIKey != "" {
+		req.Header.Set(constants.APIKeyHeader, credentials.APIKey)
+	}
+	if credentials.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+credentials.Token)
+	}
 
 	client := &http.Client{}
+	if credentials.TLS != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = credentials.TLS
+		client.Transport = transport
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, 0, fmt.Errorf("error getting response: %w", err)
@@ -22,3 +58,21 @@ func SendHTTPRequest(method string, url string, body io.Reader) (io.Reader, int,
 
 	return resp.Body, resp.StatusCode, nil
 }
+
+// HeaderName normalizes a message header name, header names are case insensitive like HTTP
+// headers so they are stored and matched lowercased whichever way the message was published.
+func HeaderName(name string) string {
+	return strings.ToLower(name)
+}
+
+// HeadersFromHTTP extracts the message headers sent as X-Flux-Header-* HTTP headers.
+func HeadersFromHTTP(header http.Header) map[string]string {
+	headers := make(map[string]string)
+	for k, v := range header {
+		if len(v) > 0 && strings.HasPrefix(k, constants.HeaderPrefix) {
+			headers[HeaderName(strings.TrimPrefix(k, constants.HeaderPrefix))] = v[0]
+		}
+	}
+
+	return headers
+}
@@ -1,15 +1,94 @@
 package request
 
+import (
+	"fmt"
+	"time"
+)
+
 type PublishMessageRequest struct {
 	Id      string `json:"id"`
-	Message string `json:"message"`
-	Topic   string `json:"topic"`
+	Message string `json:"message,omitempty"`
+	// Data carries a binary payload, base64 encoded in JSON, it takes precedence over Message
+	Data        []byte            `json:"data,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Topic       string            `json:"topic"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	// DeliverAt schedules the message for delivery at the given time
+	DeliverAt *time.Time `json:"deliverAt,omitempty"`
+	// Delay schedules the message for delivery after a Go duration, e.g. "90s", ignored when DeliverAt is set
+	Delay string `json:"delay,omitempty"`
+	// TTL is a Go duration after which the message expires and is no longer delivered
+	TTL string `json:"ttl,omitempty"`
+	// Priority is only used by priority topics, higher values are delivered first
+	Priority int `json:"priority,omitempty"`
+	// ReplyTo is the topic replies to this message should be published to
+	ReplyTo string `json:"replyTo,omitempty"`
+	// CorrelationId matches a reply with its request
+	CorrelationId string `json:"correlationId,omitempty"`
+	// ProducerId identifies an idempotent producer session, Sequence must increase by one per topic
+	ProducerId string `json:"producerId,omitempty"`
+	Sequence   uint64 `json:"sequence,omitempty"`
+}
+
+// MessageTTL parses the producer chosen TTL, zero means the message never expires.
+func (r PublishMessageRequest) MessageTTL() (time.Duration, error) {
+	if r.TTL == "" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(r.TTL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %q: %w", r.TTL, err)
+	}
+
+	if ttl <= 0 {
+		return 0, fmt.Errorf("ttl %q must be positive", r.TTL)
+	}
+
+	return ttl, nil
+}
+
+// DeliveryTime returns when the message should be delivered, the zero time means immediately.
+func (r PublishMessageRequest) DeliveryTime(now time.Time) (time.Time, error) {
+	if r.DeliverAt != nil {
+		return *r.DeliverAt, nil
+	}
+
+	if r.Delay == "" {
+		return time.Time{}, nil
+	}
+
+	delay, err := time.ParseDuration(r.Delay)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid delay %q: %w", r.Delay, err)
+	}
+
+	if delay < 0 {
+		return time.Time{}, fmt.Errorf("delay %q can't be negative", r.Delay)
+	}
+
+	return now.Add(delay), nil
+}
+
+// Payload returns the bytes that should be stored for the published message.
+func (r PublishMessageRequest) Payload() []byte {
+	if len(r.Data) > 0 {
+		return r.Data
+	}
+
+	return []byte(r.Message)
 }
 
 type RegisterSubscriberRequest struct {
 	Address string   `json:"address"`
 	Topics  []string `json:"topics"`
 	ReadOld bool     `json:"readOld"`
+	// Raw makes the broker push the payload as the request body with the metadata in HTTP headers
+	Raw bool `json:"raw,omitempty"`
+	// Filter is an expression over the message headers, only matching messages are delivered
+	Filter string `json:"filter,omitempty"`
+	// Secret signs every push so the subscriber can verify it was sent by the broker
+	Secret string `json:"secret,omitempty"`
 }
 
 type UnsubscribeRequest struct {
@@ -18,7 +97,245 @@ type UnsubscribeRequest struct {
 }
 
 type PollMessage struct {
+	Id            string            `json:"id"`
+	Payload       []byte            `json:"payload"`
+	ContentType   string            `json:"contentType,omitempty"`
+	Topic         string            `json:"topic"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	ReplyTo       string            `json:"replyTo,omitempty"`
+	CorrelationId string            `json:"correlationId,omitempty"`
+	// PublishedAt is when the message was published to the broker
+	PublishedAt time.Time `json:"publishedAt,omitempty"`
+}
+
+type PublishResponse struct {
+	Message string `json:"message"`
 	Id      string `json:"id"`
-	Payload string `json:"payload"`
-	Topic   string `json:"topic"`
+	// Duplicate is set when the id was already published to the topic within the dedup window
+	Duplicate bool       `json:"duplicate,omitempty"`
+	DeliverAt *time.Time `json:"deliverAt,omitempty"`
+	// ExpectedSequence is the next sequence accepted from the producer when the sequence was rejected
+	ExpectedSequence uint64 `json:"expectedSequence,omitempty"`
+}
+
+type ScheduledMessage struct {
+	Id        string    `json:"id"`
+	Topic     string    `json:"topic"`
+	DeliverAt time.Time `json:"deliverAt"`
+}
+
+type TopicStats struct {
+	Name        string `json:"name"`
+	Messages    int    `json:"messages"`
+	Subscribers int    `json:"subscribers"`
+	Expired     uint64 `json:"expired"`
+	DedupIds    int    `json:"dedupIds"`
+}
+
+type BrokerStats struct {
+	Topics    []TopicStats  `json:"topics"`
+	Scheduled int           `json:"scheduled"`
+	Tenants   []TenantStats `json:"tenants"`
+	// Namespaces aggregates the topic stats and quota usage per namespace
+	Namespaces []NamespaceStats `json:"namespaces"`
+	// Throttled counts the publishes rejected by the rate limits per scope
+	Throttled map[string]uint64 `json:"throttled,omitempty"`
+}
+
+type NamespaceStats struct {
+	Name          string `json:"name"`
+	Topics        int    `json:"topics"`
+	Messages      int    `json:"messages"`
+	Subscribers   int    `json:"subscribers"`
+	Subscriptions int    `json:"subscriptions"`
+	StorageBytes  int64  `json:"storageBytes"`
+}
+
+// TenantStats is the quota usage of a tenant.
+type TenantStats struct {
+	Name          string `json:"name"`
+	Subscriptions int    `json:"subscriptions"`
+	StorageBytes  int64  `json:"storageBytes"`
+}
+
+type CreateReplyTopicRequest struct {
+	// TTL is a Go duration after which the reply topic is deleted
+	TTL string `json:"ttl,omitempty"`
+}
+
+type BeginTransactionRequest struct {
+	// Timeout is a Go duration after which the transaction is aborted if not committed
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type CommitTransactionResponse struct {
+	Message   string `json:"message"`
+	Published int    `json:"published"`
+}
+
+type ReplicationOp string
+
+const (
+	ReplicatePublish          ReplicationOp = "publish"
+	ReplicateAck              ReplicationOp = "ack"
+	ReplicateSubscribe        ReplicationOp = "subscribe"
+	ReplicateUnsubscribe      ReplicationOp = "unsubscribe"
+	ReplicateCreateReplyTopic ReplicationOp = "create-reply-topic"
+	ReplicateDeleteTopic      ReplicationOp = "delete-topic"
+	// ReplicateBatch publishes the messages of Batch together, it's only used by clusters
+	ReplicateBatch ReplicationOp = "batch"
+)
+
+// ReplicationEntry is a change of the leader's state, followers apply the entries in offset order.
+type ReplicationEntry struct {
+	Offset uint64        `json:"offset"`
+	Op     ReplicationOp `json:"op"`
+	Topic  string        `json:"topic"`
+	// Message is the published message, ProducerId and Sequence the producer sequence it was published with
+	Message    *ReplicatedMessage `json:"message,omitempty"`
+	ProducerId string             `json:"producerId,omitempty"`
+	Sequence   uint64             `json:"sequence,omitempty"`
+	// Address is the subscriber which subscribed, unsubscribed or acknowledged MessageId
+	Address   string `json:"address,omitempty"`
+	MessageId string `json:"messageId,omitempty"`
+	// Subscription holds the delivery settings of a subscribe
+	Subscription *ReplicatedSubscription `json:"subscription,omitempty"`
+	// ExpiresAt is when a reply topic is deleted and Owner the principal that created it
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	// Batch holds the publishes of a batch
+	Batch []ReplicationEntry `json:"batch,omitempty"`
+}
+
+type ReplicatedMessage struct {
+	Id            string            `json:"id"`
+	Payload       []byte            `json:"payload"`
+	ContentType   string            `json:"contentType,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	TTL           time.Duration     `json:"ttl,omitempty"`
+	Priority      int               `json:"priority,omitempty"`
+	ReplyTo       string            `json:"replyTo,omitempty"`
+	CorrelationId string            `json:"correlationId,omitempty"`
+	Owner         string            `json:"owner,omitempty"`
+	AddedAt       time.Time         `json:"addedAt"`
+}
+
+type ReplicatedSubscription struct {
+	ReadOld bool   `json:"readOld,omitempty"`
+	Raw     bool   `json:"raw,omitempty"`
+	Filter  string `json:"filter,omitempty"`
+	Secret  []byte `json:"secret,omitempty"`
+	Owner   string `json:"owner,omitempty"`
+}
+
+// SubscriberState is the saved state of a subscriber of a topic.
+type SubscriberState struct {
+	Topic        string                 `json:"topic"`
+	Address      string                 `json:"address"`
+	Subscription ReplicatedSubscription `json:"subscription"`
+	Active       bool                   `json:"active"`
+	LastActive   time.Time              `json:"lastActive"`
+	// LastAckedId is the id of the last message delivered to the subscriber or expired, Delivered counts them
+	LastAckedId string `json:"lastAckedId,omitempty"`
+	Delivered   uint64 `json:"delivered"`
+	// LastAckedAt is when the last acknowledged message was added to the topic, it positions the
+	// subscriber when the topic no longer holds that message
+	LastAckedAt time.Time `json:"lastAckedAt"`
+}
+
+// PatternState is the saved state of a wildcard subscription.
+type PatternState struct {
+	Pattern      string                 `json:"pattern"`
+	Address      string                 `json:"address"`
+	Subscription ReplicatedSubscription `json:"subscription"`
+}
+
+// BrokerState holds the subscriptions a broker restores when it restarts.
+type BrokerState struct {
+	SavedAt     time.Time         `json:"savedAt"`
+	Subscribers []SubscriberState `json:"subscribers"`
+	Patterns    []PatternState    `json:"patterns"`
+}
+
+// Snapshot is the portable state of a broker, it's restored into another broker.
+type Snapshot struct {
+	Version   int              `json:"version"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Topics    []TopicSnapshot  `json:"topics"`
+	Patterns  []PatternState   `json:"patterns"`
+	Scheduled []ScheduledEntry `json:"scheduled"`
+	Producers []ProducerState  `json:"producers"`
+	// Offset is the last replication change of the leader the snapshot holds, a follower replicates from the next one
+	Offset uint64 `json:"offset,omitempty"`
+}
+
+type TopicSnapshot struct {
+	Name            string     `json:"name"`
+	Priority        bool       `json:"priority,omitempty"`
+	StarvationLimit int        `json:"starvationLimit,omitempty"`
+	Temporary       bool       `json:"temporary,omitempty"`
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`
+	Owner           string     `json:"owner,omitempty"`
+	// Messages are the messages held by the topic in publish order
+	Messages    []SnapshotMessage    `json:"messages"`
+	Dedup       []DedupEntry         `json:"dedup"`
+	Subscribers []SubscriberSnapshot `json:"subscribers"`
+	// Expired counts the messages that expired before delivery, ExpiredIds are the ones still held
+	Expired    uint64   `json:"expired,omitempty"`
+	ExpiredIds []string `json:"expiredIds,omitempty"`
+}
+
+type SnapshotMessage struct {
+	ReplicatedMessage
+	// Delivered is whether each subscriber tracking the message acknowledged it
+	Delivered map[string]bool `json:"delivered,omitempty"`
+}
+
+type DedupEntry struct {
+	Id     string    `json:"id"`
+	SeenAt time.Time `json:"seenAt"`
+}
+
+type SubscriberSnapshot struct {
+	SubscriberState
+	// Queue holds the ids of the messages the subscriber has still to receive
+	Queue   []string `json:"queue"`
+	Expired uint64   `json:"expired,omitempty"`
+}
+
+// ScheduledEntry is a message waiting for its delivery time.
+type ScheduledEntry struct {
+	Topic     string            `json:"topic"`
+	DeliverAt time.Time         `json:"deliverAt"`
+	Message   ReplicatedMessage `json:"message"`
+}
+
+// ProducerState is the last sequence accepted from a producer on a topic.
+type ProducerState struct {
+	Topic      string    `json:"topic"`
+	ProducerId string    `json:"producerId"`
+	Sequence   uint64    `json:"sequence"`
+	LastSeen   time.Time `json:"lastSeen"`
+}
+
+type ReplicationLogResponse struct {
+	Entries []ReplicationEntry `json:"entries"`
+	// Head is the offset of the last change of the leader
+	Head uint64 `json:"head"`
+}
+
+type ReplicationStatus struct {
+	Role string `json:"role"`
+	// Head is the offset of the last change applied by the broker
+	Head      uint64           `json:"head"`
+	Leader    string           `json:"leader,omitempty"`
+	Followers []FollowerStatus `json:"followers,omitempty"`
+}
+
+type FollowerStatus struct {
+	Id string `json:"id"`
+	// Offset is the last change the follower has replicated
+	Offset   uint64    `json:"offset"`
+	InSync   bool      `json:"inSync"`
+	LastSeen time.Time `json:"lastSeen"`
 }
@@ -0,0 +1,95 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/NamanBalaji/flux/pkg/constants"
+	"github.com/NamanBalaji/flux/pkg/dedup"
+)
+
+// version prefixes the signature so the scheme can be changed without breaking verifiers
+const version = "v1="
+
+var (
+	ErrMissingSignature = errors.New("missing signature")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrStaleTimestamp   = errors.New("timestamp outside the tolerance window")
+	ErrReplayed         = errors.New("replayed delivery")
+)
+
+// Sign computes the signature of a delivery body sent at the unix timestamp, the nonce is unique
+// per delivery attempt so identical bodies pushed in the same second are not taken for replays.
+func Sign(secret []byte, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return version + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetHeaders signs the body and sets the timestamp, nonce and signature headers.
+func SetHeaders(header http.Header, secret []byte, now time.Time, body []byte) {
+	timestamp := now.Unix()
+	nonce := uuid.New().String()
+	header.Set(constants.TimestampHeader, strconv.FormatInt(timestamp, 10))
+	header.Set(constants.NonceHeader, nonce)
+	header.Set(constants.SignatureHeader, Sign(secret, timestamp, nonce, body))
+}
+
+// Verifier checks the deliveries are signed with the secret, recent, and not replayed.
+type Verifier struct {
+	secret    []byte
+	tolerance time.Duration
+	// seen remembers the nonces accepted within the tolerance window to reject replays
+	seen *dedup.Window
+}
+
+func NewVerifier(secret []byte, tolerance time.Duration) *Verifier {
+	return &Verifier{
+		secret:    secret,
+		tolerance: tolerance,
+		// timestamps are accepted up to tolerance in the past or the future
+		seen: dedup.NewWindow(2*tolerance, 0),
+	}
+}
+
+func (v *Verifier) Verify(header http.Header, body []byte, now time.Time) error {
+	signature := header.Get(constants.SignatureHeader)
+	ts := header.Get(constants.TimestampHeader)
+	nonce := header.Get(constants.NonceHeader)
+	if signature == "" || ts == "" || nonce == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrInvalidSignature, ts)
+	}
+
+	if d := now.Sub(time.Unix(timestamp, 0)); d > v.tolerance || d < -v.tolerance {
+		return ErrStaleTimestamp
+	}
+
+	if !strings.HasPrefix(signature, version) || !hmac.Equal([]byte(signature), []byte(Sign(v.secret, timestamp, nonce, body))) {
+		return ErrInvalidSignature
+	}
+
+	if !v.seen.Add(nonce, now) {
+		return ErrReplayed
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package signature
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/constants"
+)
+
+func TestVerify(t *testing.T) {
+	v := NewVerifier([]byte("secret"), time.Minute)
+	now := time.Now()
+	body := []byte(`{"id":"1"}`)
+
+	header := http.Header{}
+	SetHeaders(header, []byte("secret"), now, body)
+
+	if err := v.Verify(header, body, now); err != nil {
+		t.Errorf("Signed delivery should be accepted, got %s", err)
+	}
+
+	if err := v.Verify(header, body, now); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Replayed delivery should be rejected, got %v", err)
+	}
+
+	header = http.Header{}
+	SetHeaders(header, []byte("secret"), now, body)
+	if err := v.Verify(header, []byte(`{"id":"2"}`), now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Tampered body should be rejected, got %v", err)
+	}
+
+	header = http.Header{}
+	SetHeaders(header, []byte("other"), now, body)
+	if err := v.Verify(header, body, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Delivery signed with another secret should be rejected, got %v", err)
+	}
+
+	header = http.Header{}
+	SetHeaders(header, []byte("secret"), now.Add(-2*time.Minute), body)
+	if err := v.Verify(header, body, now); !errors.Is(err, ErrStaleTimestamp) {
+		t.Errorf("Delivery outside the tolerance should be rejected, got %v", err)
+	}
+
+	if err := v.Verify(http.Header{}, body, now); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("Unsigned delivery should be rejected, got %v", err)
+	}
+}
+
+func TestVerifyTimestampIsSigned(t *testing.T) {
+	v := NewVerifier([]byte("secret"), time.Minute)
+	now := time.Now()
+	body := []byte("data")
+
+	header := http.Header{}
+	SetHeaders(header, []byte("secret"), now.Add(-time.Hour), body)
+	// moving the timestamp into the window invalidates the signature
+	header.Set(constants.TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+
+	if err := v.Verify(header, body, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Delivery with a changed timestamp should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NamanBalaji/flux/pkg/config"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "flux test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate signed by the ca, valid for 127.0.0.1, and returns its file paths.
+func (ca *testCA) issue(t *testing.T, dir string, name string, serial int64) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTLSServer(t *testing.T, r *Reloader) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = r.ServerConfig()
+	server.StartTLS()
+
+	return server
+}
+
+func get(client *Reloader, url string) (*http.Response, error) {
+	transport := &http.Transport{TLSClientConfig: client.ClientConfig()}
+	defer transport.CloseIdleConnections()
+
+	return (&http.Client{Transport: transport}).Get(url)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+
+	serverCert, serverKey := ca.issue(t, dir, "server", 2)
+	clientCert, clientKey := ca.issue(t, dir, "client", 3)
+
+	serverTLS, err := NewReloader(config.TLS{CertFile: serverCert, KeyFile: serverKey, CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTLSServer(t, serverTLS)
+	defer server.Close()
+
+	clientTLS, _ := NewReloader(config.TLS{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile})
+	resp, err := get(clientTLS, server.URL)
+	if err != nil {
+		t.Fatalf("Client with a certificate signed by the ca should connect, got %s", err)
+	}
+	resp.Body.Close()
+
+	anonymous, _ := NewReloader(config.TLS{CAFile: caFile})
+	if _, err := get(anonymous, server.URL); err == nil {
+		t.Error("Client without a certificate should be rejected")
+	}
+
+	otherCAFile := filepath.Join(dir, "other-ca.crt")
+	writeFile(t, otherCAFile, newCA(t).pem)
+	untrusting, _ := NewReloader(config.TLS{CertFile: clientCert, KeyFile: clientKey, CAFile: otherCAFile})
+	if _, err := get(untrusting, server.URL); err == nil {
+		t.Error("Client should reject a server certificate not signed by its ca")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t)
+	certFile, keyFile := ca.issue(t, dir, "server", 2)
+
+	r, err := NewReloader(config.TLS{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := r.current()
+
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ := r.current(); cert != before {
+		t.Error("Unchanged files should not be reloaded")
+	}
+
+	// reissue the certificate in place with a later modification time
+	ca.issue(t, dir, "server", 4)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := r.current()
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if leaf.SerialNumber.Int64() != 4 {
+		t.Errorf("Changed certificate should be reloaded, got serial %d", leaf.SerialNumber.Int64())
+	}
+
+	writeFile(t, certFile, []byte("not a certificate"))
+	os.Chtimes(certFile, later.Add(time.Minute), later.Add(time.Minute))
+	if err := r.Reload(); err == nil {
+		t.Error("Invalid certificate should fail to reload")
+	}
+	if current, _ := r.current(); current != cert {
+		t.Error("Previous certificate should be kept when the reload fails")
+	}
+}
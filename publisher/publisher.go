@@ -2,43 +2,288 @@ package publisher
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/NamanBalaji/flux/pkg/constants"
 	"github.com/NamanBalaji/flux/pkg/request"
 )
 
+// ErrDuplicate is returned, together with the request, when the broker already received a
+// message with the same id on the topic within its dedup window.
+var ErrDuplicate = errors.New("duplicate message")
+
+// Publisher is an idempotent producer, every message carries the producer id and a per topic
+// sequence number so retries of the same message are deduplicated by the broker.
 type Publisher struct {
+	mu            sync.Mutex
 	brokerAddress string
+	producerId    string
+	sequences     map[string]uint64
+	retries       int
+	retryInterval time.Duration
+	credentials   request.Credentials
 }
 
 func NewPublisher(brokerAddress string) *Publisher {
-	return &Publisher{brokerAddress: brokerAddress}
+	return NewPublisherWithRetries(brokerAddress, constants.DefaultPublishRetries, constants.DefaultPublishRetryInterval)
+}
+
+// NewPublisherWithRetries creates a publisher retrying failed publishes with the same sequence.
+func NewPublisherWithRetries(brokerAddress string, retries int, retryInterval time.Duration) *Publisher {
+	return &Publisher{
+		brokerAddress: brokerAddress,
+		producerId:    uuid.New().String(),
+		sequences:     make(map[string]uint64),
+		retries:       retries,
+		retryInterval: retryInterval,
+	}
+}
+
+// SetCredentials sets the credentials sent to a broker requiring authentication.
+func (p *Publisher) SetCredentials(credentials request.Credentials) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.credentials = credentials
 }
 
 func (p *Publisher) Publish(topic string, message string) (*request.PublishMessageRequest, error) {
-	id := uuid.New().String()
-	requestBody := request.PublishMessageRequest{
-		Id:      id,
+	return p.PublishWithHeaders(topic, message, nil)
+}
+
+// PublishWithHeaders publishes a message carrying the given headers, e.g. content-type or correlation ids.
+func (p *Publisher) PublishWithHeaders(topic string, message string, headers map[string]string) (*request.PublishMessageRequest, error) {
+	return p.publish(request.PublishMessageRequest{
+		Id:      uuid.New().String(),
+		Message: message,
+		Topic:   topic,
+		Headers: headers,
+	})
+}
+
+// PublishBytes publishes an opaque binary payload such as a protobuf or avro encoded record.
+func (p *Publisher) PublishBytes(topic string, data []byte, contentType string, headers map[string]string) (*request.PublishMessageRequest, error) {
+	return p.publish(request.PublishMessageRequest{
+		Id:          uuid.New().String(),
+		Data:        data,
+		ContentType: contentType,
+		Topic:       topic,
+		Headers:     headers,
+	})
+}
+
+// PublishAt publishes a message which the broker only delivers to subscribers at deliverAt.
+func (p *Publisher) PublishAt(topic string, message string, deliverAt time.Time) (*request.PublishMessageRequest, error) {
+	return p.publish(request.PublishMessageRequest{
+		Id:        uuid.New().String(),
+		Message:   message,
+		Topic:     topic,
+		DeliverAt: &deliverAt,
+	})
+}
+
+// PublishWithTTL publishes a message which expires, and is no longer delivered, after ttl.
+func (p *Publisher) PublishWithTTL(topic string, message string, ttl time.Duration) (*request.PublishMessageRequest, error) {
+	return p.publish(request.PublishMessageRequest{
+		Id:      uuid.New().String(),
 		Message: message,
 		Topic:   topic,
+		TTL:     ttl.String(),
+	})
+}
+
+// PublishWithPriority publishes a message delivered before lower priorities on priority topics.
+func (p *Publisher) PublishWithPriority(topic string, message string, priority int) (*request.PublishMessageRequest, error) {
+	return p.publish(request.PublishMessageRequest{
+		Id:       uuid.New().String(),
+		Message:  message,
+		Topic:    topic,
+		Priority: priority,
+	})
+}
+
+// Forward republishes a received message to the topic keeping its id, payload, content type,
+// headers and correlation id, so a broker receiving it twice drops the copy as a duplicate.
+func (p *Publisher) Forward(topic string, msg request.PollMessage) (*request.PublishMessageRequest, error) {
+	return p.publish(request.PublishMessageRequest{
+		Id:            msg.Id,
+		Data:          msg.Payload,
+		ContentType:   msg.ContentType,
+		Topic:         topic,
+		Headers:       msg.Headers,
+		CorrelationId: msg.CorrelationId,
+	})
+}
+
+// Request publishes a request message with a temporary reply topic and waits for the matching
+// reply until the context is done. The reply topic is deleted once the call returns.
+func (p *Publisher) Request(ctx context.Context, topic string, message string) (*request.PollMessage, error) {
+	replyTopic, err := p.createReplyTopic(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer p.deleteReplyTopic(replyTopic)
 
-	requestBodyJson, err := json.Marshal(requestBody)
+	correlationId := uuid.New().String()
+	_, err = p.publish(request.PublishMessageRequest{
+		Id:            uuid.New().String(),
+		Message:       message,
+		Topic:         topic,
+		ReplyTo:       replyTopic,
+		CorrelationId: correlationId,
+	})
 	if err != nil {
 		return nil, err
 	}
-	_, status, err := request.SendHTTPRequest(http.MethodPost, fmt.Sprintf("%s/publish", p.brokerAddress), bytes.NewBuffer(requestBodyJson))
+
+	query := url.Values{"correlationId": {correlationId}}
+	if deadline, ok := ctx.Deadline(); ok {
+		// rounded so the duration is sent without sub millisecond units like µs
+		query.Set("timeout", time.Until(deadline).Round(time.Millisecond).String())
+	}
+
+	replyURL := fmt.Sprintf("%s/reply-topics/%s/reply?%s", p.brokerAddress, url.PathEscape(replyTopic), query.Encode())
+	body, status, err := p.sendRequest(ctx, http.MethodGet, replyURL, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	if status != http.StatusOK {
-		return nil, fmt.Errorf("publish request failed with status code %d", status)
+		return nil, fmt.Errorf("reply request failed with status code %d", status)
+	}
+
+	var reply request.PollMessage
+	if err := json.NewDecoder(body).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("invalid reply: %w", err)
+	}
+
+	return &reply, nil
+}
+
+// Reply publishes the reply to a message received with a reply topic.
+func (p *Publisher) Reply(req request.PollMessage, message string) (*request.PublishMessageRequest, error) {
+	if req.ReplyTo == "" {
+		return nil, fmt.Errorf("message with id %s does not expect a reply", req.Id)
+	}
+
+	return p.publish(request.PublishMessageRequest{
+		Id:            uuid.New().String(),
+		Message:       message,
+		Topic:         req.ReplyTo,
+		CorrelationId: req.CorrelationId,
+	})
+}
+
+func (p *Publisher) createReplyTopic(ctx context.Context) (string, error) {
+	body, status, err := p.sendRequest(ctx, http.MethodPost, fmt.Sprintf("%s/reply-topics", p.brokerAddress), nil)
+	if err != nil {
+		return "", err
 	}
 
-	return &requestBody, nil
+	if status != http.StatusOK {
+		return "", fmt.Errorf("reply topic request failed with status code %d", status)
+	}
+
+	var res struct {
+		Topic string `json:"topic"`
+	}
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		return "", fmt.Errorf("invalid reply topic response: %w", err)
+	}
+
+	return res.Topic, nil
+}
+
+func (p *Publisher) deleteReplyTopic(topic string) {
+	_, _, err := p.sendRequest(context.Background(), http.MethodDelete, fmt.Sprintf("%s/reply-topics/%s", p.brokerAddress, topic), nil)
+	if err != nil {
+		log.Printf("failed to delete reply topic %s: %s", topic, err)
+	}
+}
+
+func (p *Publisher) publish(requestBody request.PublishMessageRequest) (*request.PublishMessageRequest, error) {
+	// publishes are serialized so the broker receives the sequences in order
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	requestBody.ProducerId = p.producerId
+	requestBody.Sequence = p.sequences[requestBody.Topic] + 1
+
+	requestBodyJson, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		response, status, err := p.send(requestBodyJson)
+		if err == nil && status == http.StatusOK {
+			p.sequences[requestBody.Topic] = requestBody.Sequence
+
+			// a duplicate on a retry means an earlier attempt reached the broker
+			if response.Duplicate && attempt == 0 {
+				return &requestBody, ErrDuplicate
+			}
+
+			return &requestBody, nil
+		}
+
+		if err == nil {
+			err = fmt.Errorf("publish request failed with status code %d", status)
+		}
+
+		retryable := status == 0 || status >= http.StatusInternalServerError
+		if !retryable || attempt >= p.retries {
+			// the broker may or may not have the message, a new session avoids sequence gaps
+			p.resetSession()
+
+			return nil, err
+		}
+
+		log.Printf("retrying publish of message %s: %s", requestBody.Id, err)
+		time.Sleep(p.retryInterval)
+	}
+}
+
+func (p *Publisher) send(requestBodyJson []byte) (request.PublishResponse, int, error) {
+	var response request.PublishResponse
+
+	body, status, err := request.SendAuthenticatedRequest(context.Background(), http.MethodPost, fmt.Sprintf("%s/publish", p.brokerAddress), bytes.NewBuffer(requestBodyJson), p.credentials)
+	if err != nil {
+		return response, 0, err
+	}
+
+	if status == http.StatusOK {
+		if err := json.NewDecoder(body).Decode(&response); err != nil {
+			// the message was accepted, only the duplicate flag is unknown
+			log.Printf("invalid publish response: %s", err)
+		}
+	}
+
+	return response, status, nil
+}
+
+// sendRequest sends a request with the publisher's credentials.
+func (p *Publisher) sendRequest(ctx context.Context, method string, url string, body io.Reader) (io.Reader, int, error) {
+	p.mu.Lock()
+	credentials := p.credentials
+	p.mu.Unlock()
+
+	return request.SendAuthenticatedRequest(ctx, method, url, body, credentials)
+}
+
+// resetSession starts a new producer session, the caller must hold p.mu.
+func (p *Publisher) resetSession() {
+	p.producerId = uuid.New().String()
+	p.sequences = make(map[string]uint64)
 }
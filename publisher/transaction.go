@@ -0,0 +1,110 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/NamanBalaji/flux/pkg/request"
+)
+
+// Transaction groups messages, possibly to different topics, which subscribers only see once
+// the transaction is committed. Transactional messages don't carry producer sequences.
+type Transaction struct {
+	Id        string
+	publisher *Publisher
+}
+
+// Begin opens a transaction on the broker, a zero timeout uses the broker's default.
+func (p *Publisher) Begin(timeout time.Duration) (*Transaction, error) {
+	var body request.BeginTransactionRequest
+	if timeout > 0 {
+		body.Timeout = timeout.String()
+	}
+
+	requestBodyJson, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	res, status, err := p.sendRequest(context.Background(), http.MethodPost, fmt.Sprintf("%s/transactions", p.brokerAddress), bytes.NewBuffer(requestBodyJson))
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("begin transaction request failed with status code %d", status)
+	}
+
+	var response struct {
+		Id string `json:"id"`
+	}
+	if err := json.NewDecoder(res).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid begin transaction response: %w", err)
+	}
+
+	return &Transaction{Id: response.Id, publisher: p}, nil
+}
+
+// Publish adds a message to the transaction.
+func (tx *Transaction) Publish(topic string, message string) (*request.PublishMessageRequest, error) {
+	requestBody := request.PublishMessageRequest{
+		Id:      uuid.New().String(),
+		Message: message,
+		Topic:   topic,
+	}
+
+	requestBodyJson, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	_, status, err := tx.publisher.sendRequest(context.Background(), http.MethodPost, fmt.Sprintf("%s/transactions/%s/publish", tx.publisher.brokerAddress, tx.Id), bytes.NewBuffer(requestBodyJson))
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("transaction publish request failed with status code %d", status)
+	}
+
+	return &requestBody, nil
+}
+
+// Commit publishes every message of the transaction at once.
+func (tx *Transaction) Commit() (*request.CommitTransactionResponse, error) {
+	res, status, err := tx.publisher.sendRequest(context.Background(), http.MethodPost, fmt.Sprintf("%s/transactions/%s/commit", tx.publisher.brokerAddress, tx.Id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("commit request failed with status code %d", status)
+	}
+
+	var response request.CommitTransactionResponse
+	if err := json.NewDecoder(res).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid commit response: %w", err)
+	}
+
+	return &response, nil
+}
+
+// Abort discards every message of the transaction.
+func (tx *Transaction) Abort() error {
+	_, status, err := tx.publisher.sendRequest(context.Background(), http.MethodPost, fmt.Sprintf("%s/transactions/%s/abort", tx.publisher.brokerAddress, tx.Id), nil)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return fmt.Errorf("abort request failed with status code %d", status)
+	}
+
+	return nil
+}
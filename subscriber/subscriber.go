@@ -2,6 +2,10 @@ package subscriber
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,7 +14,11 @@ import (
 	"sync"
 
 	"github.com/NamanBalaji/flux/internal/subscriber/api"
+	"github.com/NamanBalaji/flux/pkg/config"
+	"github.com/NamanBalaji/flux/pkg/constants"
 	"github.com/NamanBalaji/flux/pkg/request"
+	"github.com/NamanBalaji/flux/pkg/signature"
+	"github.com/NamanBalaji/flux/pkg/tlsconfig"
 )
 
 type Subscriber struct {
@@ -20,24 +28,55 @@ type Subscriber struct {
 	brokerAddress string
 	topics        []string
 	messageChan   chan request.PollMessage
+	credentials   request.Credentials
+	// secret is sent with every subscription so the broker signs its pushes with it
+	secret string
 }
 
 func NewSubscriber(host string, port int, brokerAddr string) *Subscriber {
+	return newSubscriber(host, port, brokerAddr, nil)
+}
+
+// NewTLSSubscriber serves the pushes over TLS with the configured certificate, the host should
+// use the https scheme. With a CA file the broker must present a client certificate signed by it.
+// The certificate files are reloaded when they change.
+func NewTLSSubscriber(host string, port int, brokerAddr string, cfg config.TLS) (*Subscriber, error) {
+	if cfg.CertFile == "" {
+		return nil, errors.New("a tls certificate is required to serve pushes over tls")
+	}
+
+	reloader, err := tlsconfig.NewReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.Watch(context.Background(), constants.CertReloadInterval)
+
+	return newSubscriber(host, port, brokerAddr, reloader.ServerConfig()), nil
+}
+
+func newSubscriber(host string, port int, brokerAddr string, tlsConfig *tls.Config) *Subscriber {
 	sub := &Subscriber{
 		host:          host,
 		port:          port,
 		brokerAddress: brokerAddr,
 		messageChan:   make(chan request.PollMessage),
+		secret:        newSecret(),
 	}
 
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: api.SetupRouter(sub.messageChan),
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   api.SetupRouter(sub.messageChan, signature.NewVerifier([]byte(sub.secret), constants.SignatureTolerance)),
+		TLSConfig: tlsConfig,
 	}
 
 	go func() {
 		log.Printf("starting subscriber on port %d", port)
-		err := server.ListenAndServe()
+		var err error
+		if tlsConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Error occurred while trying to start the subscriber server %v \n", err)
 		}
@@ -46,20 +85,52 @@ func NewSubscriber(host string, port int, brokerAddr string) *Subscriber {
 	return sub
 }
 
+// Messages returns the channel on which messages pushed by the broker, including their headers, are delivered.
+func (s *Subscriber) Messages() <-chan request.PollMessage {
+	return s.messageChan
+}
+
+// SetCredentials sets the credentials sent to a broker requiring authentication.
+func (s *Subscriber) SetCredentials(credentials request.Credentials) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.credentials = credentials
+}
+
 func (s *Subscriber) Subscribe(topics []string, realOld bool) error {
+	return s.SubscribeWithOptions(topics, SubscribeOptions{ReadOld: realOld})
+}
+
+// SubscribeOptions configure how the broker delivers messages for a subscription.
+type SubscribeOptions struct {
+	// ReadOld delivers the messages the broker still holds before new ones
+	ReadOld bool
+	// Raw asks the broker to push the payload as the raw request body, the message
+	// metadata is still available on the received request.PollMessage
+	Raw bool
+	// Filter is an expression over message headers, e.g. "region = 'eu' AND priority > 5"
+	Filter string
+}
+
+func (s *Subscriber) SubscribeWithOptions(topics []string, opts SubscribeOptions) error {
 	s.mu.Lock()
 	requestBody := request.RegisterSubscriberRequest{
 		Address: fmt.Sprintf("%s:%d", s.host, s.port),
 		Topics:  topics,
-		ReadOld: realOld,
+		ReadOld: opts.ReadOld,
+		Raw:     opts.Raw,
+		Filter:  opts.Filter,
+		Secret:  s.secret,
 	}
+	credentials := s.credentials
 	s.mu.Unlock()
 
 	reqBody, err := json.Marshal(requestBody)
 	if err != nil {
 		return err
 	}
-	_, status, err := request.SendHTTPRequest(http.MethodPost, fmt.Sprintf("%s/subscrine", s.brokerAddress), bytes.NewBuffer(reqBody))
+	_, status, err := request.SendAuthenticatedRequest(context.Background(), http.MethodPost, fmt.Sprintf("%s/subscribe", s.brokerAddress), bytes.NewBuffer(reqBody), credentials)
 	if err != nil {
 		return err
 	}
@@ -81,13 +152,14 @@ func (s *Subscriber) Unsubscribe(topics []string) error {
 		Address: fmt.Sprintf("%s:%d", s.host, s.port),
 		Topics:  topics,
 	}
+	credentials := s.credentials
 	s.mu.Unlock()
 
 	reqBody, err := json.Marshal(requestBody)
 	if err != nil {
 		return err
 	}
-	_, status, err := request.SendHTTPRequest(http.MethodPost, fmt.Sprintf("%s/unsubscrine", s.brokerAddress), bytes.NewBuffer(reqBody))
+	_, status, err := request.SendAuthenticatedRequest(context.Background(), http.MethodPost, fmt.Sprintf("%s/unsubscribe", s.brokerAddress), bytes.NewBuffer(reqBody), credentials)
 	if err != nil {
 		return err
 	}
@@ -105,6 +177,16 @@ func (s *Subscriber) Unsubscribe(topics []string) error {
 	return nil
 }
 
+// newSecret generates the random secret the broker signs the pushed messages with.
+func newSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Error generating subscription secret: %v", err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
 func (s *Subscriber) removeTopic(topic string) {
 	for i, t := range s.topics {
 		if t == topic {